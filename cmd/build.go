@@ -6,6 +6,7 @@ import (
 	"fishy/internal/parser"
 	"fishy/internal/preprocessor"
 	"fishy/pkg/ast"
+	"fishy/pkg/bytecode"
 	"fishy/pkg/log"
 	"fishy/pkg/token"
 	"fishy/pkg/utils"
@@ -24,6 +25,7 @@ var buildCmd = &cobra.Command{
 		inputFile := args[0]
 
 		var source string
+		var positions []bytecode.Position
 		if !skipPreprocessing {
 			pp, err := preprocessor.New(inputFile, false)
 			if err != nil {
@@ -34,12 +36,14 @@ var buildCmd = &cobra.Command{
 				log.Fatal(err)
 			}
 			source = pp.Output()
+			positions = pp.Positions()
 		} else {
 			sourceBytes, err := os.ReadFile(inputFile)
 			if err != nil {
 				log.Fatal(err)
 			}
 			source = string(sourceBytes)
+			positions = compiler.SourcePositions(inputFile, source)
 
 			if verbose {
 				log.Infof("skipping pre-processing")
@@ -71,6 +75,9 @@ var buildCmd = &cobra.Command{
 		}
 
 		c := compiler.New(statements)
+		if !strip {
+			c.SetSource(source, positions)
+		}
 		bytecode, err := c.Compile()
 		if err != nil {
 			log.Fatal(err)
@@ -98,6 +105,7 @@ func init() {
 
 	buildCmd.Flags().StringVarP(&outputFile, "output", "o", "out.fbc", "output file")
 	buildCmd.Flags().BoolVarP(&skipPreprocessing, "skip-pre-processing", "", false, "skip pre-processing stage")
+	buildCmd.Flags().BoolVarP(&strip, "strip", "", false, "leave out the debug info that maps addresses back to the source")
 	buildCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
 	buildCmd.Flags().BoolVarP(&debugLexer, "debug-lexer", "", false, "only dump the lexer output")
 	buildCmd.Flags().BoolVarP(&debugParser, "debug-parser", "", false, "only dump the parser output")
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/internal/preprocessor"
+)
+
+func compileFile(inputFile string) (*compiler.Compiler, []byte, error) {
+	pp, err := preprocessor.New(inputFile, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = pp.Process()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source := pp.Output()
+	l := lexer.New(source)
+	p := parser.New(l)
+	statements, err := p.Parse()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := compiler.New(statements)
+	c.SetSource(source, pp.Positions())
+	bytecode, err := c.Compile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, bytecode, nil
+}
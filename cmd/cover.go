@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fishy/pkg/cover"
+	"fishy/pkg/log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var coverHTMLFile string
+
+var coverCmd = &cobra.Command{
+	Use:   "cover [file]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Report the coverage written by fishy run --coverage",
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		profile, err := cover.Parse(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if coverHTMLFile == "" {
+			if err := profile.WriteReport(os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		out, err := os.Create(coverHTMLFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out.Close()
+		if err := profile.WriteHTML(out); err != nil {
+			log.Fatal(err)
+		}
+
+		if verbose {
+			log.Info("wrote coverage report", "file", coverHTMLFile)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(coverCmd)
+
+	coverCmd.Flags().StringVarP(&coverHTMLFile, "html", "", "", "write an HTML page showing the covered source lines to this file")
+	coverCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+}
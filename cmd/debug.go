@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"bufio"
+	"fishy/internal/vm"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/log"
+	"fishy/pkg/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug [file]",
+	Args:  cobra.MinimumNArgs(1),
+	Short: "Step through a FishyASM or Fishy Bytecode file in the FishyVM",
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+
+		var bytecode []byte
+		if strings.HasSuffix(inputFile, ".fi") {
+			_, b, err := compileFile(inputFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bytecode = b
+		} else {
+			b, err := os.ReadFile(inputFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bytecode = b
+		}
+
+		m, err := vm.New(bytecode, memorySize, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		session := &debugSession{
+			reader: bufio.NewReader(os.Stdin),
+			labels: map[string]uint64{},
+			info:   m.DebugInfo(),
+		}
+		if session.info != nil {
+			session.labels = session.info.Labels
+		} else if verbose {
+			log.Info("debugging bytecode without debug info, breakpoints by label are unavailable")
+		}
+		m.SetBreakHandler(session.handleBreak)
+
+		mainThread, _ := m.GetThread(0)
+		m.SetStepping(mainThread, true)
+
+		if err := m.Run(); err != nil {
+			reportFault(m, err)
+		}
+
+		if status, ok := m.ExitCode(); ok {
+			fmt.Printf("program exited with status %d\n", status)
+			os.Exit(status)
+		}
+		fmt.Println("program finished")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(debugCmd)
+
+	debugCmd.Flags().IntVarP(&memorySize, "memory-size", "s", 1024*1024, "total amount of memory to use")
+	debugCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+}
+
+type debugSession struct {
+	mu     sync.Mutex
+	reader *bufio.Reader
+	labels map[string]uint64
+	info   *bytecode.DebugInfo
+}
+
+func (d *debugSession) handleBreak(m *vm.Machine, thread *vm.Thread, reason vm.BreakReason) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index, _ := m.GetThreadIndex(thread)
+	ip := m.RegisterValue(thread, utils.RegisterToIndex("ip"))
+	if position, ok := m.SourceAt(ip); ok {
+		fmt.Printf("thread %d stopped at %s in %s (%s)\n", index, d.formatAddress(ip), position, reason)
+	} else {
+		fmt.Printf("thread %d stopped at %s (%s)\n", index, d.formatAddress(ip), reason)
+	}
+
+	for {
+		fmt.Print("(fishy) ")
+		line, err := d.reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			os.Exit(0)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "s", "step":
+			m.SetStepping(thread, true)
+			return
+		case "c", "continue":
+			m.SetStepping(thread, false)
+			return
+		case "b", "break":
+			if len(fields) != 2 {
+				fmt.Println("usage: break <label|file:line|address>")
+				continue
+			}
+			addr, err := d.parseAddress(fields[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			m.SetBreakpoint(addr)
+			fmt.Printf("breakpoint set at %s\n", d.formatAddress(addr))
+		case "d", "delete":
+			if len(fields) != 2 {
+				fmt.Println("usage: delete <label|file:line|address>")
+				continue
+			}
+			addr, err := d.parseAddress(fields[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if !m.ClearBreakpoint(addr) {
+				fmt.Printf("no breakpoint at %s\n", d.formatAddress(addr))
+			}
+		case "bl", "breakpoints":
+			for _, addr := range m.Breakpoints() {
+				fmt.Println(d.formatAddress(addr))
+			}
+		case "r", "regs", "registers":
+			regsIndex := index
+			if len(fields) == 2 {
+				n, err := strconv.Atoi(fields[1])
+				if err != nil {
+					fmt.Printf("invalid thread index %s\n", fields[1])
+					continue
+				}
+				regsIndex = n
+			}
+			if _, ok := m.GetThread(regsIndex); !ok {
+				fmt.Printf("thread %d does not exist\n", regsIndex)
+				continue
+			}
+			m.DumpRegisters(regsIndex)
+		case "p", "print":
+			if len(fields) != 2 {
+				fmt.Println("usage: print <register>")
+				continue
+			}
+			reg := utils.RegisterToIndex(strings.ToLower(fields[1]))
+			if reg == -1 {
+				fmt.Printf("unknown register %s\n", fields[1])
+				continue
+			}
+			value := m.RegisterValue(thread, reg)
+			fmt.Printf("%s = 0x%016X (%d)\n", utils.IndexToRegister(reg), value, value)
+		case "x", "mem", "memory":
+			if len(fields) < 2 || len(fields) > 3 {
+				fmt.Println("usage: memory <label|address> [length]")
+				continue
+			}
+			start, err := d.parseAddress(fields[1])
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			length := uint64(64)
+			if len(fields) == 3 {
+				length, err = strconv.ParseUint(fields[2], 0, 64)
+				if err != nil {
+					fmt.Printf("invalid length %s\n", fields[2])
+					continue
+				}
+			}
+			if start >= uint64(m.MemorySize()) {
+				fmt.Printf("address 0x%X is out of bounds\n", start)
+				continue
+			}
+			m.DumpMemory(int(start), int(start+length))
+		case "q", "quit":
+			os.Exit(0)
+		case "h", "help":
+			printDebugHelp()
+		default:
+			fmt.Printf("unknown command %s, type help for a list of commands\n", fields[0])
+		}
+	}
+}
+
+func (d *debugSession) parseAddress(value string) (uint64, error) {
+	if addr, ok := d.labels[value]; ok {
+		return addr, nil
+	}
+	if addr, ok := d.lineAddress(value); ok {
+		return addr, nil
+	}
+	addr, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unknown label, line or address %s", value)
+	}
+	return addr, nil
+}
+
+// lineAddress resolves file:line to the first instruction compiled from that
+// line, the file may be given without its directory.
+func (d *debugSession) lineAddress(value string) (uint64, bool) {
+	i := strings.LastIndex(value, ":")
+	if d.info == nil || i < 0 {
+		return 0, false
+	}
+	file := value[:i]
+	line, err := strconv.Atoi(value[i+1:])
+	if err != nil {
+		return 0, false
+	}
+
+	for _, entry := range d.info.Lines {
+		position := entry.Position
+		if position.Line == line && (position.File == file || filepath.Base(position.File) == file) {
+			return entry.Addr, true
+		}
+	}
+	return 0, false
+}
+
+func (d *debugSession) formatAddress(addr uint64) string {
+	name := ""
+	nearest := uint64(0)
+	for label, labelAddr := range d.labels {
+		if labelAddr > addr || labelAddr < nearest {
+			continue
+		}
+		if labelAddr == nearest && name != "" && label > name {
+			continue
+		}
+		name, nearest = label, labelAddr
+	}
+
+	if name == "" {
+		return fmt.Sprintf("0x%04X", addr)
+	}
+	if addr == nearest {
+		return fmt.Sprintf("0x%04X <%s>", addr, name)
+	}
+	return fmt.Sprintf("0x%04X <%s+%d>", addr, name, addr-nearest)
+}
+
+func printDebugHelp() {
+	fmt.Println("step, s                      execute one instruction")
+	fmt.Println("continue, c                  run until the next breakpoint")
+	fmt.Println("break, b <label|address>     set a breakpoint, file:line works with debug info")
+	fmt.Println("delete, d <label|address>    remove a breakpoint")
+	fmt.Println("breakpoints, bl              list breakpoints")
+	fmt.Println("registers, regs, r [thread]  dump the registers of a thread")
+	fmt.Println("print, p <register>          print a single register")
+	fmt.Println("memory, mem, x <addr> [len]  dump memory starting at an address")
+	fmt.Println("quit, q                      exit the debugger")
+}
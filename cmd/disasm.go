@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fishy/pkg/disasm"
+	"fishy/pkg/log"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	disasmOutputFile string
+	showAddresses    bool
+)
+
+var disasmCmd = &cobra.Command{
+	Use:   "disasm [file]",
+	Args:  cobra.MinimumNArgs(1),
+	Short: "Disassemble a Fishy Bytecode file back into FishyASM",
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFile := args[0]
+		inputData, err := os.ReadFile(inputFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		program, err := disasm.ParseProgram(inputData)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var w io.Writer = os.Stdout
+		if disasmOutputFile != "" {
+			file, err := os.OpenFile(disasmOutputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			w = file
+		}
+
+		err = disasm.Disassemble(w, program, showAddresses)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if verbose && disasmOutputFile != "" {
+			log.Info("wrote disassembly to output", "file", disasmOutputFile)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(disasmCmd)
+
+	disasmCmd.Flags().StringVarP(&disasmOutputFile, "output", "o", "", "output file (defaults to stdout)")
+	disasmCmd.Flags().BoolVarP(&showAddresses, "addresses", "a", false, "annotate every line with its address")
+	disasmCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"fishy/internal/vm"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/log"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// faultExitCode is used when the guest program crashed so scripts can tell it
+// apart from errors of the fishy command itself (EX_SOFTWARE).
+const faultExitCode = 70
+
+// reportFault prints a crash report for a runtime fault and exits. Any other
+// error is treated as fatal.
+func reportFault(m *vm.Machine, err error) {
+	var fault *vm.Fault
+	if !errors.As(err, &fault) {
+		log.Fatal(err)
+	}
+
+	log.Error(fault.Error())
+	if fault.Source != nil {
+		printSourceLine(*fault.Source)
+	}
+
+	log.Infof("thread %d registers:", fault.Thread)
+	m.DumpRegisters(fault.Thread)
+
+	if fault.IP < uint64(m.MemorySize()) {
+		start := 0
+		if fault.IP > 32 {
+			start = int(fault.IP) - 32
+		}
+		log.Infof("memory around 0x%04X:", fault.IP)
+		m.DumpMemory(start, int(fault.IP)+32)
+	}
+
+	os.Exit(faultExitCode)
+}
+
+// printSourceLine shows the line of a position with a marker under the
+// column, if the source file is still around.
+func printSourceLine(position bytecode.Position) {
+	data, err := os.ReadFile(position.File)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(string(data), "\n")
+	if position.Line < 1 || position.Line > len(lines) {
+		return
+	}
+
+	line := strings.TrimRight(lines[position.Line-1], "\r")
+	prefix := fmt.Sprintf("%5d | ", position.Line)
+	fmt.Fprintf(os.Stderr, "%s%s\n", prefix, line)
+	if position.Column >= 1 && position.Column <= len(line)+1 {
+		// keep tabs so the marker lines up with the line above
+		indent := []byte(line[:position.Column-1])
+		for i, c := range indent {
+			if c != '\t' {
+				indent[i] = ' '
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s%s^\n", strings.Repeat(" ", len(prefix)), indent)
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -9,12 +10,28 @@ import (
 var (
 	outputFile        string
 	skipPreprocessing bool
+	strip             bool
 	debugLexer        bool
 	debugParser       bool
 	debugRegisters    int
 	debugMemory       bool
 	verbose           bool
 	memorySize        int
+	sandbox           bool
+	policyFile        string
+	maxSteps          uint64
+	maxThreadSteps    uint64
+	timeout           time.Duration
+	heapSize          uint64
+	stackSize         uint64
+	deterministic     bool
+	seed              int64
+	traceFile         string
+	profileFile       string
+	coverageFile      string
+	snapshotFile      string
+	snapshotAfter     uint64
+	resumeFile        string
 )
 
 var rootCmd = &cobra.Command{
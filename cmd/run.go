@@ -1,27 +1,105 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fishy/internal/vm"
+	"fishy/pkg/cover"
 	"fishy/pkg/log"
 	"fmt"
 	"os"
+	"os/signal"
 
 	"github.com/spf13/cobra"
 )
 
 var runCmd = &cobra.Command{
 	Use:   "run [file]",
-	Args:  cobra.MinimumNArgs(1),
+	Args:  runArgs,
 	Short: "Run Fishy Bytecode file in the FishyVM",
 	Run: func(cmd *cobra.Command, args []string) {
-		inputFile := args[0]
-		inputData, err := os.ReadFile(inputFile)
+		var m *vm.Machine
+		var err error
+		if resumeFile != "" {
+			m, err = restoreMachine(cmd)
+		} else {
+			m, err = loadMachine(args[0])
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		m := vm.New(inputData, memorySize, false)
-		m.Run()
+		m.SetStepLimit(maxSteps)
+		m.SetThreadStepLimit(maxThreadSteps)
+		m.SetTimeout(timeout)
+		if snapshotFile != "" {
+			m.PauseAfter(snapshotAfter)
+			// Ctrl-C saves the machine instead of killing it
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+			defer signal.Stop(interrupt)
+			go func() {
+				for range interrupt {
+					m.Pause()
+				}
+			}()
+		}
+
+		var trace *bufio.Writer
+		if traceFile != "" {
+			file, err := os.Create(traceFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			trace = bufio.NewWriter(file)
+			m.SetTrace(trace)
+		}
+
+		if profileFile != "" {
+			m.EnableProfile()
+		}
+		if coverageFile != "" {
+			m.EnableCoverage()
+		}
+
+		if sandbox || policyFile != "" {
+			policy := vm.DefaultPolicy()
+			if policyFile != "" {
+				policy, err = vm.LoadPolicy(policyFile)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			m.SetPolicy(policy)
+
+			if verbose {
+				log.Info("running in sandbox", "policy", policyFile)
+			}
+		}
+		err = m.Run()
+		if errors.Is(err, vm.ErrPaused) {
+			if snapshotFile == "" {
+				log.Fatal("the program was paused but there is no --snapshot file to save it to")
+			}
+			writeSnapshot(m, snapshotFile)
+			err = nil
+		}
+		// flushed first, the trace is most useful when the program faulted
+		if trace != nil {
+			if err := trace.Flush(); err != nil {
+				log.Error("writing the trace failed", "err", err)
+			}
+		}
+		if profileFile != "" {
+			writeProfile(m.Profile(), profileFile)
+		}
+		if coverageFile != "" {
+			writeCoverage(m.Coverage(), coverageFile)
+		}
+		if err != nil {
+			reportFault(m, err)
+		}
 
 		if debugRegisters > -1 {
 			msg := "main thread"
@@ -58,7 +136,11 @@ var runCmd = &cobra.Command{
 
 		if debugMemory {
 			log.Info("debugging memory")
-			m.DumpMemory(0, memorySize)
+			m.DumpMemory(0, m.MemorySize())
+		}
+
+		if status, ok := m.ExitCode(); ok {
+			os.Exit(status)
 		}
 	},
 }
@@ -67,7 +149,123 @@ func init() {
 	rootCmd.AddCommand(runCmd)
 
 	runCmd.Flags().IntVarP(&memorySize, "memory-size", "s", 1024*1024, "total amount of memory to use")
+	runCmd.Flags().Uint64VarP(&heapSize, "heap-size", "", 0, "bytes of memory reserved for SYS_ALLOC and thread stacks (0 = what the main stack leaves)")
+	runCmd.Flags().Uint64VarP(&stackSize, "stack-size", "", 0, "bytes of memory reserved for the main thread's stack (0 = what the heap leaves)")
 	runCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
 	runCmd.Flags().IntVarP(&debugRegisters, "debug-registers", "", -2, "dump the registers at the index when done (-1 = all)")
 	runCmd.Flags().BoolVarP(&debugMemory, "debug-memory", "", false, "dump the memory when done")
+	runCmd.Flags().BoolVarP(&sandbox, "sandbox", "", false, "deny filesystem and network access, see --policy")
+	runCmd.Flags().StringVarP(&policyFile, "policy", "", "", "sandbox policy file listing allowed paths, addresses and syscalls (implies --sandbox)")
+	runCmd.Flags().Uint64VarP(&maxSteps, "max-steps", "", 0, "stop after this many instructions across all threads (0 = no limit)")
+	runCmd.Flags().Uint64VarP(&maxThreadSteps, "max-thread-steps", "", 0, "stop a thread after it executed this many instructions (0 = no limit)")
+	runCmd.Flags().DurationVarP(&timeout, "timeout", "", 0, "stop the program after this long, e.g. 5s (0 = no limit)")
+	runCmd.Flags().BoolVarP(&deterministic, "deterministic", "", false, "run all threads on one scheduler so they interleave the same way every run")
+	runCmd.Flags().Int64VarP(&seed, "seed", "", 0, "seed of the --deterministic scheduler")
+	runCmd.Flags().StringVarP(&traceFile, "trace", "", "", "write every executed instruction to this file as JSON lines")
+	runCmd.Flags().StringVarP(&profileFile, "profile", "", "", "print where the program spent its time and write a pprof profile to this file")
+	runCmd.Flags().StringVarP(&coverageFile, "coverage", "", "", "print which lines and branches ran and write the coverage to this file, see fishy cover")
+	runCmd.Flags().StringVarP(&snapshotFile, "snapshot", "", "", "save the paused program to this file on Ctrl-C or after --snapshot-after instructions, see --resume")
+	runCmd.Flags().Uint64VarP(&snapshotAfter, "snapshot-after", "", 0, "pause and save the program after this many instructions (0 = only on Ctrl-C)")
+	runCmd.Flags().StringVarP(&resumeFile, "resume", "", "", "carry on the program saved in this snapshot file instead of running a new one")
+}
+
+func runArgs(cmd *cobra.Command, args []string) error {
+	if resumeFile != "" {
+		if len(args) > 0 {
+			return errors.New("--resume runs the program of the snapshot, leave out the file")
+		}
+		return nil
+	}
+	return cobra.ExactArgs(1)(cmd, args)
+}
+
+func loadMachine(inputFile string) (*vm.Machine, error) {
+	inputData, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := vm.New(inputData, memorySize, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if heapSize != 0 {
+		if err := m.SetHeapSize(heapSize); err != nil {
+			return nil, err
+		}
+	}
+	if stackSize != 0 {
+		if err := m.SetStackSize(stackSize); err != nil {
+			return nil, err
+		}
+	}
+	if deterministic {
+		m.SetDeterministic(seed)
+	}
+	return m, nil
+}
+
+// restoreMachine loads the --resume snapshot. Its memory layout and
+// scheduling were fixed when the program started.
+func restoreMachine(cmd *cobra.Command) (*vm.Machine, error) {
+	for _, name := range []string{"memory-size", "heap-size", "stack-size", "deterministic", "seed"} {
+		if cmd.Flags().Changed(name) {
+			return nil, fmt.Errorf("--%s cannot be changed when resuming a snapshot", name)
+		}
+	}
+
+	snapshot, err := os.ReadFile(resumeFile)
+	if err != nil {
+		return nil, err
+	}
+	return vm.Restore(snapshot)
+}
+
+// writeSnapshot saves the paused machine, the program can be carried on with
+// fishy run --resume.
+func writeSnapshot(m *vm.Machine, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := m.Snapshot(file); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("saved the paused program", "snapshot", path, "steps", m.Steps())
+}
+
+// writeProfile prints the hottest labels to stderr, the guest owns stdout.
+func writeProfile(profile *vm.Profile, path string) {
+	if err := profile.WriteReport(os.Stderr); err != nil {
+		log.Error("writing the profile report failed", "err", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Error("writing the profile failed", "err", err)
+		return
+	}
+	defer file.Close()
+	if err := profile.WritePprof(file); err != nil {
+		log.Error("writing the profile failed", "err", err)
+	}
+}
+
+// writeCoverage prints the coverage summary to stderr like writeProfile.
+func writeCoverage(profile *cover.Profile, path string) {
+	if err := profile.WriteReport(os.Stderr); err != nil {
+		log.Error("writing the coverage report failed", "err", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Error("writing the coverage failed", "err", err)
+		return
+	}
+	defer file.Close()
+	if err := profile.Write(file); err != nil {
+		log.Error("writing the coverage failed", "err", err)
+	}
 }
@@ -8,6 +8,9 @@ import (
 )
 
 func (c *Compiler) compileArithmetic(instruction *ast.Instruction) error {
+	if len(instruction.Args) == 1 && isUnaryArithmetic(instruction.Name) {
+		return c.compileUnaryArithmetic(instruction)
+	}
 	if len(instruction.Args) != 2 {
 		return fmt.Errorf("%s expected 2 arguments", instruction.Name)
 	}
@@ -21,6 +24,23 @@ func (c *Compiler) compileArithmetic(instruction *ast.Instruction) error {
 		switch a1 := arg1.(type) {
 		case *ast.NumberLiteral:
 			num, err := ParseStringUint(a1.Value)
+			if isFloatArithmetic(instruction.Name) {
+				// `fadd x0, 2` means 2.0, the literal is stored as a float
+				num, err = ParseStringFloat(a1.Value, instruction.DataType)
+			}
+			if err != nil {
+				return err
+			}
+			op, err := getArithmeticOpcode(instruction.Name, "REG_LIT")
+			if err != nil {
+				return err
+			}
+			*section = append(*section, utils.Bytes2(uint16(op))...)
+			*section = append(*section, byte(instruction.DataType))
+			*section = append(*section, byte(a0.Value))
+			*section = append(*section, instruction.DataType.MakeBytes(num)...)
+		case *ast.FloatLiteral:
+			num, err := ParseStringFloat(a1.Value, instruction.DataType)
 			if err != nil {
 				return err
 			}
@@ -139,12 +159,69 @@ func (c *Compiler) compileArithmetic(instruction *ast.Instruction) error {
 	return nil
 }
 
+// compileUnaryArithmetic compiles the single operand form of an unary
+// instruction like `neg x0`, which works on the register in place.
+func (c *Compiler) compileUnaryArithmetic(instruction *ast.Instruction) error {
+	register, ok := instruction.Args[0].(*ast.Register)
+	if !ok {
+		return fmt.Errorf("%s expected argument #1 to be REGISTER got %s", instruction.Name, instruction.Args[0].String())
+	}
+
+	op, err := getArithmeticOpcode(instruction.Name, "REG")
+	if err != nil {
+		return err
+	}
+
+	section := c.currentSectionBytecode()
+	*section = append(*section, utils.Bytes2(uint16(op))...)
+	*section = append(*section, byte(instruction.DataType))
+	*section = append(*section, byte(register.Value))
+	return nil
+}
+
+func isUnaryArithmetic(name string) bool {
+	switch name {
+	case "neg", "not", "inc", "dec", "itof", "ftoi":
+		return true
+	default:
+		return false
+	}
+}
+
+// isFloatArithmetic reports whether the instruction works on the float in its
+// register, itof and ftoi convert between integers and floats instead.
+func isFloatArithmetic(name string) bool {
+	switch name {
+	case "fadd", "fsub", "fmul", "fdiv", "fcmp":
+		return true
+	default:
+		return false
+	}
+}
+
 func getArithmeticOpcode(name, kind string) (opcode.Opcode, error) {
 	opcodes := map[string]map[string]opcode.Opcode{
 		"add": {"REG_LIT": opcode.ADD_REG_LIT, "REG_REG": opcode.ADD_REG_REG, "REG_AOF": opcode.ADD_REG_AOF},
 		"sub": {"REG_LIT": opcode.SUB_REG_LIT, "REG_REG": opcode.SUB_REG_REG, "REG_AOF": opcode.SUB_REG_AOF},
 		"mul": {"REG_LIT": opcode.MUL_REG_LIT, "REG_REG": opcode.MUL_REG_REG, "REG_AOF": opcode.MUL_REG_AOF},
 		"div": {"REG_LIT": opcode.DIV_REG_LIT, "REG_REG": opcode.DIV_REG_REG, "REG_AOF": opcode.DIV_REG_AOF},
+
+		"imul": {"REG_LIT": opcode.IMUL_REG_LIT, "REG_REG": opcode.IMUL_REG_REG, "REG_AOF": opcode.IMUL_REG_AOF},
+		"idiv": {"REG_LIT": opcode.IDIV_REG_LIT, "REG_REG": opcode.IDIV_REG_REG, "REG_AOF": opcode.IDIV_REG_AOF},
+		"mod":  {"REG_LIT": opcode.MOD_REG_LIT, "REG_REG": opcode.MOD_REG_REG, "REG_AOF": opcode.MOD_REG_AOF},
+
+		"neg": {"REG": opcode.NEG_REG, "REG_LIT": opcode.NEG_REG_LIT, "REG_REG": opcode.NEG_REG_REG, "REG_AOF": opcode.NEG_REG_AOF},
+		"not": {"REG": opcode.NOT_REG, "REG_LIT": opcode.NOT_REG_LIT, "REG_REG": opcode.NOT_REG_REG, "REG_AOF": opcode.NOT_REG_AOF},
+		"inc": {"REG": opcode.INC_REG, "REG_LIT": opcode.INC_REG_LIT, "REG_REG": opcode.INC_REG_REG, "REG_AOF": opcode.INC_REG_AOF},
+		"dec": {"REG": opcode.DEC_REG, "REG_LIT": opcode.DEC_REG_LIT, "REG_REG": opcode.DEC_REG_REG, "REG_AOF": opcode.DEC_REG_AOF},
+
+		"fadd": {"REG_LIT": opcode.FADD_REG_LIT, "REG_REG": opcode.FADD_REG_REG, "REG_AOF": opcode.FADD_REG_AOF},
+		"fsub": {"REG_LIT": opcode.FSUB_REG_LIT, "REG_REG": opcode.FSUB_REG_REG, "REG_AOF": opcode.FSUB_REG_AOF},
+		"fmul": {"REG_LIT": opcode.FMUL_REG_LIT, "REG_REG": opcode.FMUL_REG_REG, "REG_AOF": opcode.FMUL_REG_AOF},
+		"fdiv": {"REG_LIT": opcode.FDIV_REG_LIT, "REG_REG": opcode.FDIV_REG_REG, "REG_AOF": opcode.FDIV_REG_AOF},
+		"fcmp": {"REG_LIT": opcode.FCMP_REG_LIT, "REG_REG": opcode.FCMP_REG_REG, "REG_AOF": opcode.FCMP_REG_AOF},
+		"itof": {"REG": opcode.ITOF_REG, "REG_LIT": opcode.ITOF_REG_LIT, "REG_REG": opcode.ITOF_REG_REG, "REG_AOF": opcode.ITOF_REG_AOF},
+		"ftoi": {"REG": opcode.FTOI_REG, "REG_LIT": opcode.FTOI_REG_LIT, "REG_REG": opcode.FTOI_REG_REG, "REG_AOF": opcode.FTOI_REG_AOF},
 	}
 
 	ops, found := opcodes[name]
@@ -0,0 +1,70 @@
+package compiler
+
+import (
+	"fishy/pkg/ast"
+	"fishy/pkg/opcode"
+	"fishy/pkg/utils"
+	"fmt"
+)
+
+// compileAtomic compiles `xchg reg, reg`, `xchg reg, [addr]`, `xadd reg, [addr]`
+// and `cas expected, new, [addr]`.
+func (c *Compiler) compileAtomic(instruction *ast.Instruction) error {
+	section := c.currentSectionBytecode()
+
+	switch instruction.Name {
+	case "xchg", "xadd":
+		if len(instruction.Args) != 2 {
+			return fmt.Errorf("%s expected 2 arguments", instruction.Name)
+		}
+		a0, ok := instruction.Args[0].(*ast.Register)
+		if !ok {
+			return fmt.Errorf("%s expected argument #1 to be REGISTER got %s", instruction.Name, instruction.Args[0].String())
+		}
+
+		switch a1 := instruction.Args[1].(type) {
+		case *ast.Register:
+			if instruction.Name != "xchg" {
+				return fmt.Errorf("%s expected argument #2 to be ADDRESS_OF got %s", instruction.Name, a1.String())
+			}
+			*section = append(*section, utils.Bytes2(uint16(opcode.XCHG_REG_REG))...)
+			*section = append(*section, byte(instruction.DataType))
+			*section = append(*section, byte(a0.Value))
+			*section = append(*section, byte(a1.Value))
+		case *ast.AddressOf:
+			op := opcode.XCHG_REG_AOF
+			if instruction.Name == "xadd" {
+				op = opcode.XADD_REG_AOF
+			}
+			*section = append(*section, utils.Bytes2(uint16(op))...)
+			*section = append(*section, byte(instruction.DataType))
+			*section = append(*section, byte(a0.Value))
+			return c.compileAddressOf(instruction, a1, 2)
+		default:
+			return fmt.Errorf("%s expected argument #2 to be REGISTER or ADDRESS_OF got %s", instruction.Name, a1.String())
+		}
+	case "cas":
+		if len(instruction.Args) != 3 {
+			return fmt.Errorf("%s expected 3 arguments", instruction.Name)
+		}
+		a0, ok := instruction.Args[0].(*ast.Register)
+		if !ok {
+			return fmt.Errorf("%s expected argument #1 to be REGISTER got %s", instruction.Name, instruction.Args[0].String())
+		}
+		a1, ok := instruction.Args[1].(*ast.Register)
+		if !ok {
+			return fmt.Errorf("%s expected argument #2 to be REGISTER got %s", instruction.Name, instruction.Args[1].String())
+		}
+		a2, ok := instruction.Args[2].(*ast.AddressOf)
+		if !ok {
+			return fmt.Errorf("%s expected argument #3 to be ADDRESS_OF got %s", instruction.Name, instruction.Args[2].String())
+		}
+		*section = append(*section, utils.Bytes2(uint16(opcode.CAS_REG_REG_AOF))...)
+		*section = append(*section, byte(instruction.DataType))
+		*section = append(*section, byte(a0.Value))
+		*section = append(*section, byte(a1.Value))
+		return c.compileAddressOf(instruction, a2, 3)
+	}
+
+	return nil
+}
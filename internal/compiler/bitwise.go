@@ -67,6 +67,7 @@ func getBitwiseOpcode(name, kind string) (opcode.Opcode, error) {
 		"xor": {"REG_LIT": opcode.XOR_REG_LIT, "REG_REG": opcode.XOR_REG_REG},
 		"shl": {"REG_LIT": opcode.SHL_REG_LIT, "REG_REG": opcode.SHL_REG_REG},
 		"shr": {"REG_LIT": opcode.SHR_REG_LIT, "REG_REG": opcode.SHR_REG_REG},
+		"sar": {"REG_LIT": opcode.SAR_REG_LIT, "REG_REG": opcode.SAR_REG_REG},
 	}
 
 	ops, found := opcodes[name]
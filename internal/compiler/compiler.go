@@ -2,11 +2,13 @@ package compiler
 
 import (
 	"fishy/pkg/ast"
+	"fishy/pkg/bytecode"
 	"fishy/pkg/datatype"
 	"fishy/pkg/log"
 	"fishy/pkg/opcode"
 	"fishy/pkg/utils"
 	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -16,6 +18,7 @@ const (
 	SectionText Section = iota
 	SectionData
 	SectionBSS
+	SectionRodata
 )
 
 type Fixup struct {
@@ -29,27 +32,30 @@ type Compiler struct {
 	statements    []ast.Statement
 	lastStatement ast.Statement
 
-	headerStart       []byte
 	headerSymbolTable []byte
 
-	text []byte
-	data []byte
-	bss  []byte
+	text   []byte
+	rodata []byte
+	data   []byte
+	bss    []byte
 
 	symbolTable *SymbolTable
 	fixups      []Fixup
 
 	currentSection Section
 	entry          string
+
+	// only set when compiling with debug info, see debug.go
+	source *source
 }
 
 func New(statements []ast.Statement) *Compiler {
 	return &Compiler{
 		statements:        statements,
 		lastStatement:     nil,
-		headerStart:       make([]byte, 8),
 		headerSymbolTable: make([]byte, 0),
 		text:              make([]byte, 0),
+		rodata:            make([]byte, 0),
 		data:              make([]byte, 0),
 		bss:               make([]byte, 0),
 		symbolTable:       NewSymbolTable(),
@@ -68,10 +74,14 @@ func (c *Compiler) Compile() ([]byte, error) {
 				return nil, err
 			}
 		case *ast.Instruction:
+			addr := len(c.text)
 			err := c.compileInstruction(s)
 			if err != nil {
 				return nil, err
 			}
+			if c.source != nil && c.currentSection == SectionText && len(c.text) > addr {
+				c.addLine(uint64(addr), s.Start)
+			}
 		case *ast.Sequence:
 			err := c.compileSequence(s)
 			if err != nil {
@@ -83,24 +93,26 @@ func (c *Compiler) Compile() ([]byte, error) {
 
 	c.resolveFixups()
 
-	c.writeHeaderStart()
-
-	bytecode := c.headerStart
-
-	stSize := c.symbolTable.GetSize()
-	stStart := len(bytecode) + 24
-	stEnd := stStart + len(c.headerSymbolTable)
-
-	bytecode = append(bytecode, utils.Bytes8(uint64(stSize))...)
-	bytecode = append(bytecode, utils.Bytes8(uint64(stStart))...)
-	bytecode = append(bytecode, utils.Bytes8(uint64(stEnd))...)
-
-	bytecode = append(bytecode, c.headerSymbolTable...)
+	textSize := uint64(len(c.text))
+	rodataSize := uint64(len(c.rodata))
+	dataSize := uint64(len(c.data))
+
+	file := &bytecode.File{
+		Version: bytecode.Version,
+		Entry:   c.entryAddr(),
+		Sections: []*bytecode.Section{
+			{Kind: bytecode.SECTION_TEXT, Addr: 0, Size: textSize, Data: c.text},
+			{Kind: bytecode.SECTION_RODATA, Addr: textSize, Size: rodataSize, Data: c.rodata},
+			{Kind: bytecode.SECTION_DATA, Addr: textSize + rodataSize, Size: dataSize, Data: c.data},
+			{Kind: bytecode.SECTION_BSS, Addr: textSize + rodataSize + dataSize, Size: uint64(len(c.bss))},
+			{Kind: bytecode.SECTION_SYMBOLS, EntSize: uint8(c.symbolTable.GetSize()), Data: c.headerSymbolTable},
+		},
+	}
+	if c.source != nil {
+		file.Sections = append(file.Sections, &bytecode.Section{Kind: bytecode.SECTION_DEBUG, Data: c.debugInfo()})
+	}
 
-	bytecode = append(bytecode, c.text...)
-	bytecode = append(bytecode, c.data...)
-	bytecode = append(bytecode, c.bss...)
-	return bytecode, nil
+	return file.Encode(), nil
 }
 
 func (c *Compiler) compileLabel(label *ast.Label) error {
@@ -156,15 +168,23 @@ func (c *Compiler) compileInstruction(instruction *ast.Instruction) error {
 		opcode := utils.Bytes2(uint16(opcode.SYSCALL))
 		section := c.currentSectionBytecode()
 		*section = append(*section, opcode...)
+	case "yield":
+		opcode := utils.Bytes2(uint16(opcode.YIELD))
+		section := c.currentSectionBytecode()
+		*section = append(*section, opcode...)
 	case "mov":
 		return c.compileMov(instruction)
-	case "add", "sub", "mul", "div":
+	case "add", "sub", "mul", "div", "imul", "idiv", "mod",
+		"neg", "not", "inc", "dec",
+		"fadd", "fsub", "fmul", "fdiv", "fcmp", "itof", "ftoi":
 		return c.compileArithmetic(instruction)
-	case "and", "or", "xor", "shl", "shr":
+	case "and", "or", "xor", "shl", "shr", "sar":
 		return c.compileBitwise(instruction)
 	case "cmp":
 		return c.compileCompare(instruction)
-	case "jmp", "jeq", "jne", "jlt", "jgt", "jle", "jge", "jz":
+	case "jmp", "jeq", "jne", "jlt", "jgt", "jle", "jge", "jz",
+		"jl", "jg", "jng", "jnl",
+		"jnz", "jc", "jnc", "jo", "jno", "js", "jns":
 		return c.compileJump(instruction)
 	case "push":
 		return c.compilePush(instruction)
@@ -172,6 +192,8 @@ func (c *Compiler) compileInstruction(instruction *ast.Instruction) error {
 		return c.compilePop(instruction)
 	case "call":
 		return c.compileCall(instruction)
+	case "xchg", "xadd", "cas":
+		return c.compileAtomic(instruction)
 	case "ret":
 		return c.compileRet()
 	default:
@@ -240,6 +262,12 @@ func (c *Compiler) compileSequence(sequence *ast.Sequence) error {
 					return err
 				}
 				bytecode = append(bytecode, utils.Bytes4(uint32(num))...)
+			case *ast.FloatLiteral:
+				num, err := ParseStringFloat(v.Value, datatype.DWORD)
+				if err != nil {
+					return err
+				}
+				bytecode = append(bytecode, utils.Bytes4(uint32(num))...)
 			default:
 				return fmt.Errorf("%s expected argument #%d to be NUMBER got %s", sequence.Name, i, v.String())
 			}
@@ -261,8 +289,23 @@ func (c *Compiler) compileSequence(sequence *ast.Sequence) error {
 					return err
 				}
 				bytecode = append(bytecode, utils.Bytes8(num)...)
+			case *ast.FloatLiteral:
+				num, err := ParseStringFloat(v.Value, datatype.QWORD)
+				if err != nil {
+					return err
+				}
+				bytecode = append(bytecode, utils.Bytes8(num)...)
+			case *ast.Identifier:
+				// labels make tables of pointers, like `dq handler_a, handler_b`
+				c.fixups = append(c.fixups, Fixup{
+					addr:     len(*section) + len(bytecode),
+					section:  c.currentSection,
+					label:    v.Value,
+					dataType: datatype.QWORD,
+				})
+				bytecode = append(bytecode, utils.Bytes8(0)...)
 			default:
-				return fmt.Errorf("%s expected argument #%d to be NUMBER got %s", sequence.Name, i, v.String())
+				return fmt.Errorf("%s expected argument #%d to be NUMBER or IDENTIFIER got %s", sequence.Name, i, v.String())
 			}
 		}
 
@@ -373,6 +416,8 @@ func (c *Compiler) changeSection(section string) {
 	switch section {
 	case "text":
 		c.currentSection = SectionText
+	case "rodata":
+		c.currentSection = SectionRodata
 	case "data":
 		c.currentSection = SectionData
 	case "bss":
@@ -385,47 +430,29 @@ func (c *Compiler) changeSection(section string) {
 func (c *Compiler) resolveFixups() {
 	for _, fixup := range c.fixups {
 		if symbol := c.symbolTable.Get(fixup.label); symbol != nil {
-			currentSection := fixup.section
 			fixupAddr := fixup.addr
 
-			if currentSection == symbol.section {
-				bytes := fixup.dataType.MakeBytes(symbol.addr)
-
-				kv := c.symbolTable.Compile(symbol.name, symbol.addr)
-				c.headerSymbolTable = append(c.headerSymbolTable, kv...)
-
-				if fixup.section == SectionText {
-					for i := 0; i < fixup.dataType.Size(); i++ {
-						c.text[(fixupAddr + i)] = bytes[i]
-					}
-				} else if fixup.section == SectionData {
-					for i := 0; i < fixup.dataType.Size(); i++ {
-						c.data[(fixupAddr + i)] = bytes[i]
-					}
-				} else {
-					for i := 0; i < fixup.dataType.Size(); i++ {
-						c.bss[(fixupAddr + i)] = bytes[i]
-					}
+			offset := c.getAddrOffset(symbol.addr, symbol.section)
+			bytes := fixup.dataType.MakeBytes(offset)
+
+			kv := c.symbolTable.Compile(symbol.name, offset)
+			c.headerSymbolTable = append(c.headerSymbolTable, kv...)
+
+			if fixup.section == SectionText {
+				for i := 0; i < fixup.dataType.Size(); i++ {
+					c.text[(fixupAddr + i)] = bytes[i]
+				}
+			} else if fixup.section == SectionRodata {
+				for i := 0; i < fixup.dataType.Size(); i++ {
+					c.rodata[(fixupAddr + i)] = bytes[i]
+				}
+			} else if fixup.section == SectionData {
+				for i := 0; i < fixup.dataType.Size(); i++ {
+					c.data[(fixupAddr + i)] = bytes[i]
 				}
 			} else {
-				offset := c.getAddrOffset(symbol.addr, symbol.section)
-				bytes := fixup.dataType.MakeBytes(offset)
-
-				kv := c.symbolTable.Compile(symbol.name, offset)
-				c.headerSymbolTable = append(c.headerSymbolTable, kv...)
-
-				if fixup.section == SectionText {
-					for i := 0; i < fixup.dataType.Size(); i++ {
-						c.text[(fixupAddr + i)] = bytes[i]
-					}
-				} else if fixup.section == SectionData {
-					for i := 0; i < fixup.dataType.Size(); i++ {
-						c.data[(fixupAddr + i)] = bytes[i]
-					}
-				} else {
-					for i := 0; i < fixup.dataType.Size(); i++ {
-						c.bss[(fixupAddr + i)] = bytes[i]
-					}
+				for i := 0; i < fixup.dataType.Size(); i++ {
+					c.bss[(fixupAddr + i)] = bytes[i]
 				}
 			}
 		} else {
@@ -434,31 +461,46 @@ func (c *Compiler) resolveFixups() {
 	}
 }
 
-func (c *Compiler) writeHeaderStart() {
+func (c *Compiler) entryAddr() uint64 {
 	if symbol := c.symbolTable.Get(c.entry); symbol != nil {
-		addr := c.getAddrOffset(symbol.addr, symbol.section)
-		bytes := utils.Bytes8(addr)
-		copy(c.headerStart, bytes[:])
+		return c.getAddrOffset(symbol.addr, symbol.section)
 	}
+	return 0
 }
 
+// getAddrOffset turns a section relative address into a memory address. The
+// sections are laid out as text, rodata, data and then bss.
 func (c *Compiler) getAddrOffset(addr uint64, section Section) uint64 {
-	if section == SectionText {
+	textSectionSize := uint64(len(c.text))
+	rodataSectionSize := uint64(len(c.rodata))
+	dataSectionSize := uint64(len(c.data))
+
+	switch section {
+	case SectionText:
 		return addr
-	} else if section == SectionData {
-		textSectionSize := uint64(len(c.text))
+	case SectionRodata:
 		return textSectionSize + addr
-	} else {
-		textSectionSize := uint64(len(c.text))
-		dataSectionSize := uint64(len(c.data))
-		return textSectionSize + dataSectionSize + addr
+	case SectionData:
+		return textSectionSize + rodataSectionSize + addr
+	default:
+		return textSectionSize + rodataSectionSize + dataSectionSize + addr
+	}
+}
+
+func (c *Compiler) Labels() map[string]uint64 {
+	labels := make(map[string]uint64)
+	for name, symbol := range c.symbolTable.symbols {
+		labels[name] = c.getAddrOffset(symbol.addr, symbol.section)
 	}
+	return labels
 }
 
 func (c *Compiler) currentSectionBytecode() *[]byte {
 	switch c.currentSection {
 	case SectionText:
 		return &c.text
+	case SectionRodata:
+		return &c.rodata
 	case SectionData:
 		return &c.data
 	case SectionBSS:
@@ -469,6 +511,101 @@ func (c *Compiler) currentSectionBytecode() *[]byte {
 	return nil
 }
 
+// compileAddressOf appends the encoding of a memory operand, the value index
+// followed by its address expression. Numbers and labels take the size of the
+// instruction's data type.
+func (c *Compiler) compileAddressOf(instruction *ast.Instruction, aof *ast.AddressOf, argument int) error {
+	section := c.currentSectionBytecode()
+
+	index := aof.Value.Index()
+	switch value := aof.Value.(type) {
+	case *ast.NumberLiteral:
+		num, err := ParseStringUint(value.Value)
+		if err != nil {
+			return err
+		}
+		*section = append(*section, byte(index))
+		*section = append(*section, instruction.DataType.MakeBytes(num)...)
+	case *ast.Register:
+		*section = append(*section, byte(index))
+		*section = append(*section, byte(value.Value))
+	case *ast.Identifier:
+		*section = append(*section, byte(index))
+		c.fixups = append(c.fixups, Fixup{
+			addr:     len(*section),
+			section:  c.currentSection,
+			label:    value.Value,
+			dataType: instruction.DataType,
+		})
+		*section = append(*section, instruction.DataType.MakeBytes(0)...)
+	case *ast.RegisterOffsetNumber:
+		num, err := ParseStringUint(value.Right.Value)
+		if err != nil {
+			return err
+		}
+		*section = append(*section, byte(index))
+		*section = append(*section, byte(value.Left.Value))
+		*section = append(*section, byte(int(value.Operator)))
+		*section = append(*section, instruction.DataType.MakeBytes(num)...)
+	case *ast.RegisterOffsetRegister:
+		*section = append(*section, byte(index))
+		*section = append(*section, byte(value.Left.Value))
+		*section = append(*section, byte(int(value.Operator)))
+		*section = append(*section, byte(value.Right.Value))
+	case *ast.LabelOffsetNumber:
+		num, err := ParseStringUint(value.Right.Value)
+		if err != nil {
+			return err
+		}
+		*section = append(*section, byte(index))
+		c.fixups = append(c.fixups, Fixup{
+			addr:     len(*section),
+			section:  c.currentSection,
+			label:    value.Left.(*ast.Identifier).Value,
+			dataType: instruction.DataType,
+		})
+		*section = append(*section, instruction.DataType.MakeBytes(0)...)
+		*section = append(*section, byte(int(value.Operator)))
+		*section = append(*section, instruction.DataType.MakeBytes(num)...)
+	case *ast.LabelOffsetRegister:
+		*section = append(*section, byte(index))
+		c.fixups = append(c.fixups, Fixup{
+			addr:     len(*section),
+			section:  c.currentSection,
+			label:    value.Left.(*ast.Identifier).Value,
+			dataType: instruction.DataType,
+		})
+		*section = append(*section, instruction.DataType.MakeBytes(0)...)
+		*section = append(*section, byte(int(value.Operator)))
+		*section = append(*section, byte(value.Right.Value))
+	default:
+		return fmt.Errorf("%s expected argument #%d to be ADDRESS_OF[REGISTER], ADDRESS_OF[NUMBER], ADDRESS_OF[IDENTIFIER], ADDRESS_OF[REGISTER_OFFSET], or ADDRESS_OF[LABEL_OFFSET] got ADDRESS_OF[%s]", instruction.Name, argument, value.String())
+	}
+
+	return nil
+}
+
+// ParseStringFloat returns the bits of a float literal, single precision for
+// dword and double precision for qword. A byte or word cannot hold one.
+func ParseStringFloat(value string, dataType datatype.DataType) (uint64, error) {
+	if dataType == datatype.BYTE || dataType == datatype.WORD {
+		return 0, fmt.Errorf("float literal %s does not fit in a %s, use a DWORD or QWORD", value, dataType)
+	}
+	if dataType == datatype.DWORD {
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid float string: %s", value)
+		}
+		return uint64(math.Float32bits(float32(f))), nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid float string: %s", value)
+	}
+	return math.Float64bits(f), nil
+}
+
 func ParseStringUint(value string) (uint64, error) {
 	intVal, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
@@ -0,0 +1,63 @@
+package compiler
+
+import (
+	"fishy/pkg/bytecode"
+	"sort"
+	"strings"
+)
+
+// source maps offsets of the compiled source back to the files it was
+// preprocessed from.
+type source struct {
+	lineStarts []int
+	positions  []bytecode.Position
+	lines      []bytecode.LineEntry
+}
+
+// SetSource makes Compile add a debug info section. text is what the
+// statements were parsed from and positions holds where each of its lines
+// came from, like the preprocessor's Positions.
+func (c *Compiler) SetSource(text string, positions []bytecode.Position) {
+	s := &source{lineStarts: []int{0}, positions: positions}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			s.lineStarts = append(s.lineStarts, i+1)
+		}
+	}
+	c.source = s
+}
+
+// SourcePositions is SetSource's positions for a file that was not
+// preprocessed.
+func SourcePositions(file string, text string) []bytecode.Position {
+	lines := strings.Split(text, "\n")
+	positions := make([]bytecode.Position, len(lines))
+	for i := range lines {
+		positions[i] = bytecode.Position{File: file, Line: i + 1, Column: 1}
+	}
+	return positions
+}
+
+func (s *source) position(offset int) (bytecode.Position, bool) {
+	line := sort.Search(len(s.lineStarts), func(i int) bool { return s.lineStarts[i] > offset }) - 1
+	if line < 0 || line >= len(s.positions) {
+		return bytecode.Position{}, false
+	}
+	position := s.positions[line]
+	position.Column += offset - s.lineStarts[line]
+	return position, true
+}
+
+func (c *Compiler) addLine(addr uint64, offset int) {
+	if position, ok := c.source.position(offset); ok {
+		c.source.lines = append(c.source.lines, bytecode.LineEntry{Addr: addr, Position: position})
+	}
+}
+
+func (c *Compiler) debugInfo() []byte {
+	info := &bytecode.DebugInfo{
+		Labels: c.Labels(),
+		Lines:  c.source.lines,
+	}
+	return info.Encode()
+}
@@ -72,6 +72,20 @@ func getJumpOpcode(name string) (struct{ reg, lit opcode.Opcode }, error) {
 		"jgt": {opcode.JGT_REG, opcode.JGT_LIT},
 		"jle": {opcode.JLE_REG, opcode.JLE_LIT},
 		"jge": {opcode.JGE_REG, opcode.JGE_LIT},
+		"jl":  {opcode.JL_REG, opcode.JL_LIT},
+		"jg":  {opcode.JG_REG, opcode.JG_LIT},
+		"jng": {opcode.JNG_REG, opcode.JNG_LIT},
+		"jnl": {opcode.JNL_REG, opcode.JNL_LIT},
+		"jo":  {opcode.JO_REG, opcode.JO_LIT},
+		"jno": {opcode.JNO_REG, opcode.JNO_LIT},
+		"js":  {opcode.JS_REG, opcode.JS_LIT},
+		"jns": {opcode.JNS_REG, opcode.JNS_LIT},
+
+		// aliases for the jumps above that read better after arithmetic
+		"jz":  {opcode.JEQ_REG, opcode.JEQ_LIT},
+		"jnz": {opcode.JNE_REG, opcode.JNE_LIT},
+		"jc":  {opcode.JLT_REG, opcode.JLT_LIT},
+		"jnc": {opcode.JGE_REG, opcode.JGE_LIT},
 	}
 
 	op, found := opcodes[name]
@@ -36,6 +36,16 @@ func (c *Compiler) compileMov(instruction *ast.Instruction) error {
 			*section = append(*section, byte(instruction.DataType))
 			*section = append(*section, byte(a0.Value))
 			*section = append(*section, instruction.DataType.MakeBytes(num)...)
+		case *ast.FloatLiteral:
+			num, err := ParseStringFloat(a1.Value, instruction.DataType)
+			if err != nil {
+				return err
+			}
+			opcode := utils.Bytes2(uint16(opcode.MOV_REG_LIT))
+			*section = append(*section, opcode...)
+			*section = append(*section, byte(instruction.DataType))
+			*section = append(*section, byte(a0.Value))
+			*section = append(*section, instruction.DataType.MakeBytes(num)...)
 		case *ast.Identifier:
 			opcode := utils.Bytes2(uint16(opcode.MOV_REG_ADR))
 			*section = append(*section, opcode...)
@@ -139,8 +149,17 @@ func (c *Compiler) compileMov(instruction *ast.Instruction) error {
 				return err
 			}
 			bytecode = append(bytecode, instruction.DataType.MakeBytes(num)...)
+		case *ast.FloatLiteral:
+			opcode := utils.Bytes2(uint16(opcode.MOV_AOF_LIT))
+			*section = append(*section, opcode...)
+			*section = append(*section, byte(instruction.DataType))
+			num, err := ParseStringFloat(a1.Value, instruction.DataType)
+			if err != nil {
+				return err
+			}
+			bytecode = append(bytecode, instruction.DataType.MakeBytes(num)...)
 		default:
-			return fmt.Errorf("mov expected argument #2 to be REGISTER or NUMBER got %s", a1.String())
+			return fmt.Errorf("mov expected argument #2 to be REGISTER, NUMBER or FLOAT got %s", a1.String())
 		}
 
 		index := a0.Value.Index()
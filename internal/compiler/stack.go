@@ -30,7 +30,16 @@ func (c *Compiler) compilePush(instruction *ast.Instruction) error {
 		opcode := utils.Bytes2(uint16(opcode.PUSH_LIT))
 		*section = append(*section, opcode...)
 		*section = append(*section, byte(instruction.DataType))
-		*section = append(*section, utils.Bytes8(uint64(num))...)
+		*section = append(*section, instruction.DataType.MakeBytes(num)...)
+	case *ast.FloatLiteral:
+		num, err := ParseStringFloat(a.Value, instruction.DataType)
+		if err != nil {
+			return err
+		}
+		opcode := utils.Bytes2(uint16(opcode.PUSH_LIT))
+		*section = append(*section, opcode...)
+		*section = append(*section, byte(instruction.DataType))
+		*section = append(*section, instruction.DataType.MakeBytes(num)...)
 	case *ast.Identifier:
 		opcode := utils.Bytes2(uint16(opcode.PUSH_LIT))
 		*section = append(*section, opcode...)
@@ -36,8 +36,20 @@ func (c *Compiler) compileCall(instruction *ast.Instruction) error {
 			dataType: datatype.UNSET,
 		})
 		*section = append(*section, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}...)
+	case *ast.Register:
+		opcode := utils.Bytes2(uint16(opcode.CALL_REG))
+		*section = append(*section, opcode...)
+		*section = append(*section, byte(a.Value))
+	case *ast.AddressOf:
+		opcode := utils.Bytes2(uint16(opcode.CALL_AOF))
+		*section = append(*section, opcode...)
+		*section = append(*section, byte(instruction.DataType))
+
+		if err := c.compileAddressOf(instruction, a, 1); err != nil {
+			return err
+		}
 	default:
-		return fmt.Errorf("%s expected argument #1 to be NUMBER or IDENTIFIER got %s", instruction.Name, a.String())
+		return fmt.Errorf("%s expected argument #1 to be NUMBER, IDENTIFIER, REGISTER or ADDRESS_OF got %s", instruction.Name, a.String())
 	}
 
 	return nil
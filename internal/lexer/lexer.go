@@ -193,6 +193,9 @@ func (l *Lexer) readImmediateWithNegative(start int) token.Token {
 		for isDigit(l.ch) {
 			l.readChar()
 		}
+		if (l.ch == '.' && isDigit(l.peekChar())) || l.ch == 'e' || l.ch == 'E' {
+			return l.readFloat(start)
+		}
 	}
 
 	value := l.input[start:l.position]
@@ -216,6 +219,30 @@ func (l *Lexer) readImmediateWithNegative(start int) token.Token {
 	return token.Token{Kind: token.IMMEDIATE, Value: value, Start: start, End: l.position}
 }
 
+// readFloat continues reading a decimal immediate that turned out to have a
+// fraction or an exponent, like 1.5, -0.25 or 6.02e23.
+func (l *Lexer) readFloat(start int) token.Token {
+	if l.ch == '.' {
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+
+	value := l.input[start:l.position]
+	value = strings.Replace(value, "$", "", 1)
+	return token.Token{Kind: token.FLOAT, Value: value, Start: start, End: l.position}
+}
+
 func (l *Lexer) readDirective() token.Token {
 	start := l.position
 	l.readChar()
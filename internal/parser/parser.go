@@ -61,9 +61,9 @@ func (p *Parser) parseStatement() (ast.Statement, error) {
 }
 
 func (p *Parser) parseLabel() (ast.Statement, error) {
-	name := p.currentToken.Value
+	label := &ast.Label{Name: p.currentToken.Value, Start: p.currentToken.Start}
 	p.nextToken()
-	return &ast.Label{Name: name}, nil
+	return label, nil
 }
 
 func (p *Parser) parseIdentifier() (ast.Statement, error) {
@@ -74,7 +74,7 @@ func (p *Parser) parseIdentifier() (ast.Statement, error) {
 
 func (p *Parser) parseInstruction() (ast.Statement, error) {
 	instructionName := p.currentToken.Value
-	instruction := &ast.Instruction{Name: instructionName}
+	instruction := &ast.Instruction{Name: instructionName, Start: p.currentToken.Start}
 	p.nextToken()
 
 	if p.currentToken.Kind == token.DATA_TYPE {
@@ -108,6 +108,7 @@ func (p *Parser) parseInstruction() (ast.Statement, error) {
 
 func (p *Parser) parseSequence() (ast.Statement, error) {
 	sequenceName := p.currentToken.Value
+	start := p.currentToken.Start
 	p.nextToken()
 
 	var values []ast.Value
@@ -124,7 +125,7 @@ func (p *Parser) parseSequence() (ast.Statement, error) {
 		p.nextToken()
 	}
 
-	return &ast.Sequence{Name: sequenceName, Values: values}, nil
+	return &ast.Sequence{Name: sequenceName, Values: values, Start: start}, nil
 }
 
 func (p *Parser) parseArgument() (ast.Value, error) {
@@ -141,6 +142,10 @@ func (p *Parser) parseArgument() (ast.Value, error) {
 		lit := p.currentToken.Value
 		p.nextToken()
 		return &ast.NumberLiteral{Value: lit}, nil
+	case token.FLOAT:
+		lit := p.currentToken.Value
+		p.nextToken()
+		return &ast.FloatLiteral{Value: lit}, nil
 	case token.STRING:
 		lit := p.currentToken.Value
 		p.nextToken()
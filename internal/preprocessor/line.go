@@ -2,9 +2,14 @@ package preprocessor
 
 import "fmt"
 
+// Line is a line of the output. n is the zero based line and col the zero
+// based column of its first character in file, a line expanded from a macro
+// keeps the ones of the call.
 type Line struct {
 	data string
 	n    int
+	col  int
+	file string
 }
 
 func (l Line) Print() {
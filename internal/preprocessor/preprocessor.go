@@ -2,6 +2,7 @@ package preprocessor
 
 import (
 	"bufio"
+	"fishy/pkg/bytecode"
 	"fmt"
 	"os"
 	"strings"
@@ -35,7 +36,9 @@ func (p *PreProcessor) Process() error {
 	for linesIdx := 0; linesIdx < len(p.lines); linesIdx++ {
 		line := &p.lines[linesIdx]
 
-		line.data = strings.TrimSpace(line.data)
+		trimmed := strings.TrimLeft(line.data, " \t")
+		line.col += len(line.data) - len(trimmed)
+		line.data = strings.TrimSpace(trimmed)
 
 		inString, wasComment := false, false
 
@@ -304,6 +307,8 @@ func (p *PreProcessor) Process() error {
 				return fmt.Errorf("macro %s expects %d argument(s), received %d", mnemonic, len(macroExists.params), len(arguments))
 			}
 
+			// line points into p.lines, which the call is removed from
+			call := *line
 			p.lines = append(p.lines[:linesIdx], p.lines[linesIdx+1:]...)
 
 			insertIdx := linesIdx
@@ -333,7 +338,7 @@ func (p *PreProcessor) Process() error {
 					argIndex++
 				}
 
-				p.lines = append(p.lines[:insertIdx], append([]Line{{n: line.n, data: macroLine}}, p.lines[insertIdx:]...)...)
+				p.lines = append(p.lines[:insertIdx], append([]Line{{n: call.n, col: call.col, file: call.file, data: macroLine}}, p.lines[insertIdx:]...)...)
 				insertIdx++
 
 				continue
@@ -370,6 +375,15 @@ func (p *PreProcessor) Output() string {
 	return output
 }
 
+// Positions returns where every line of Output came from.
+func (p *PreProcessor) Positions() []bytecode.Position {
+	positions := make([]bytecode.Position, len(p.lines))
+	for i, line := range p.lines {
+		positions[i] = bytecode.Position{File: line.file, Line: line.n + 1, Column: line.col + 1}
+	}
+	return positions
+}
+
 func (p *PreProcessor) readSourceFile(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -386,6 +400,7 @@ func (p *PreProcessor) readSourceFile(filename string) error {
 		if lineContent != "" {
 			p.lines = append(p.lines, Line{
 				n:    lineNumber,
+				file: filename,
 				data: lineContent,
 			})
 		}
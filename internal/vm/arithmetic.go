@@ -2,127 +2,173 @@ package vm
 
 import (
 	"encoding/binary"
-	"fishy/pkg/ast"
 	"fishy/pkg/datatype"
-	"fishy/pkg/log"
 	"fishy/pkg/opcode"
-	"fishy/pkg/utils"
-	"strconv"
 )
 
-func (m *Machine) handleArithmetic(thread *Thread, op opcode.Opcode) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	pos := m.position(thread)
-	rdt := datatype.DataType(m.decodeNumber("byte", pos))
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	switch op {
+func (m *Machine) handleArithmetic(thread *Thread, in *instruction) {
+	switch in.op {
 	case opcode.ADD_REG_LIT:
-		m.applyRegLitArithmetic(thread, rdt, func(reg, lit uint64) uint64 { return reg + lit })
+		m.applyRegLit(thread, in, aluAdd)
 	case opcode.ADD_REG_REG:
-		m.applyRegRegArithmetic(thread, func(reg0, reg1 uint64) uint64 { return reg0 + reg1 })
+		m.applyRegReg(thread, in, aluAdd)
 	case opcode.ADD_REG_AOF:
-		m.applyRegAof(thread, rdt, func(reg0, value uint64) uint64 { return reg0 + value })
+		m.applyRegAof(thread, in, in.dt, aluAdd)
 	case opcode.SUB_REG_LIT:
-		m.applyRegLitArithmetic(thread, rdt, func(reg, lit uint64) uint64 { return reg - lit })
+		m.applyRegLit(thread, in, aluSub)
 	case opcode.SUB_REG_REG:
-		m.applyRegRegArithmetic(thread, func(reg0, reg1 uint64) uint64 { return reg0 - reg1 })
+		m.applyRegReg(thread, in, aluSub)
 	case opcode.SUB_REG_AOF:
-		m.applyRegAof(thread, rdt, func(reg0, value uint64) uint64 { return reg0 - value })
+		m.applyRegAof(thread, in, in.dt, aluSub)
 	case opcode.MUL_REG_LIT:
-		m.applyRegLitArithmetic(thread, rdt, func(reg, lit uint64) uint64 { return reg * lit })
+		m.applyRegLit(thread, in, aluMul)
 	case opcode.MUL_REG_REG:
-		m.applyRegRegArithmetic(thread, func(reg0, reg1 uint64) uint64 { return reg0 * reg1 })
+		m.applyRegReg(thread, in, aluMul)
 	case opcode.MUL_REG_AOF:
-		m.applyRegAof(thread, rdt, func(reg0, value uint64) uint64 { return reg0 * value })
+		m.applyRegAof(thread, in, in.dt, aluMul)
 	case opcode.DIV_REG_LIT:
-		m.applyRegLitArithmetic(thread, rdt, func(reg, lit uint64) uint64 { return reg / lit })
+		m.applyRegLit(thread, in, aluDiv)
 	case opcode.DIV_REG_REG:
-		m.applyRegRegArithmetic(thread, func(reg0, reg1 uint64) uint64 { return reg0 / reg1 })
+		m.applyRegReg(thread, in, aluDiv)
 	case opcode.DIV_REG_AOF:
-		m.applyRegAof(thread, rdt, func(reg0, value uint64) uint64 { return reg0 / value })
+		m.applyRegAof(thread, in, in.dt, aluDiv)
+	case opcode.IMUL_REG_LIT:
+		m.applyRegLitSigned(thread, in, in.dt, aluImul)
+	case opcode.IMUL_REG_REG:
+		m.applyRegReg(thread, in, aluImul)
+	case opcode.IMUL_REG_AOF:
+		m.applyRegAofSigned(thread, in, in.dt, aluImul)
+	case opcode.IDIV_REG_LIT:
+		m.applyRegLitSigned(thread, in, in.dt, aluIdiv)
+	case opcode.IDIV_REG_REG:
+		m.applyRegReg(thread, in, aluIdiv)
+	case opcode.IDIV_REG_AOF:
+		m.applyRegAofSigned(thread, in, in.dt, aluIdiv)
+	case opcode.MOD_REG_LIT:
+		m.applyRegLit(thread, in, aluMod)
+	case opcode.MOD_REG_REG:
+		m.applyRegReg(thread, in, aluMod)
+	case opcode.MOD_REG_AOF:
+		m.applyRegAof(thread, in, in.dt, aluMod)
+	case opcode.NEG_REG:
+		m.applyReg(thread, in, aluNeg)
+	case opcode.NEG_REG_LIT:
+		m.applyRegLit(thread, in, aluNeg)
+	case opcode.NEG_REG_REG:
+		m.applyRegReg(thread, in, aluNeg)
+	case opcode.NEG_REG_AOF:
+		m.applyRegAof(thread, in, in.dt, aluNeg)
+	case opcode.INC_REG:
+		m.applyReg(thread, in, aluInc)
+	case opcode.INC_REG_LIT:
+		m.applyRegLit(thread, in, aluInc)
+	case opcode.INC_REG_REG:
+		m.applyRegReg(thread, in, aluInc)
+	case opcode.INC_REG_AOF:
+		m.applyRegAof(thread, in, in.dt, aluInc)
+	case opcode.DEC_REG:
+		m.applyReg(thread, in, aluDec)
+	case opcode.DEC_REG_LIT:
+		m.applyRegLit(thread, in, aluDec)
+	case opcode.DEC_REG_REG:
+		m.applyRegReg(thread, in, aluDec)
+	case opcode.DEC_REG_AOF:
+		m.applyRegAof(thread, in, in.dt, aluDec)
 	}
 }
 
-func (m *Machine) applyRegLitArithmetic(thread *Thread, dataType datatype.DataType, operation func(uint64, uint64) uint64) {
-	reg := m.readRegister(thread)
-	lit := m.readLiteral(thread, dataType)
-	temp := m.getRegister(thread, reg)
-	m.setRegister(thread, reg, operation(temp, lit))
+// signExtend widens a value read with the given data type to an int64.
+func signExtend(value uint64, dataType datatype.DataType) int64 {
+	switch dataType {
+	case datatype.BYTE:
+		return int64(int8(value))
+	case datatype.WORD:
+		return int64(int16(value))
+	case datatype.DWORD:
+		return int64(int32(value))
+	default:
+		return int64(value)
+	}
 }
 
-func (m *Machine) applyRegRegArithmetic(thread *Thread, operation func(uint64, uint64) uint64) {
-	reg0 := m.readRegister(thread)
-	reg1 := m.readRegister(thread)
-	temp0 := m.getRegister(thread, reg0)
-	temp1 := m.getRegister(thread, reg1)
-	m.setRegister(thread, reg0, operation(temp0, temp1))
+// applyReg runs an unary operation on a register in place. The two operand
+// forms of unary instructions store the result of the operation on the second
+// operand in the register instead.
+func (m *Machine) applyReg(thread *Thread, in *instruction, operation aluOperation) {
+	result, flags := operation(0, m.getRegister(thread, in.reg0))
+	m.setRegister(thread, in.reg0, result)
+	m.setFlags(thread, flags)
 }
 
-func (m *Machine) applyRegAof(thread *Thread, dataType datatype.DataType, operation func(uint64, uint64) uint64) {
-	reg0 := m.readRegister(thread)
+func (m *Machine) applyRegLit(thread *Thread, in *instruction, operation aluOperation) {
+	result, flags := operation(m.getRegister(thread, in.reg0), in.lit)
+	m.setRegister(thread, in.reg0, result)
+	m.setFlags(thread, flags)
+}
 
-	value := m.decodeValue(thread, dataType)
-	addr := 0
-	switch v := value.(type) {
-	case *ast.NumberLiteral:
-		num, _ := strconv.ParseUint(v.Value, 10, 64)
-		addr += int(num)
-	case *ast.Register:
-		num := m.getRegister(thread, v.Value)
-		addr += int(num)
-	case *ast.RegisterOffsetNumber:
-		num := m.getRegister(thread, v.Left.Value)
-		addr += int(num)
-	case *ast.RegisterOffsetRegister:
-		num := m.getRegister(thread, v.Left.Value)
-		addr += int(num)
-	case *ast.LabelOffsetNumber:
-		num, _ := strconv.ParseUint(v.Left.(*ast.NumberLiteral).Value, 10, 64)
-		addr += int(num)
-	case *ast.LabelOffsetRegister:
-		num, _ := strconv.ParseUint(v.Left.(*ast.NumberLiteral).Value, 10, 64)
-		addr += int(num)
-	default:
-		log.Fatal("unknown value to get address of", "value", value)
-	}
+func (m *Machine) applyRegReg(thread *Thread, in *instruction, operation aluOperation) {
+	result, flags := operation(m.getRegister(thread, in.reg0), m.getRegister(thread, in.reg1))
+	m.setRegister(thread, in.reg0, result)
+	m.setFlags(thread, flags)
+}
 
-	dt := datatype.DataType(datatype.UNSET)
-	if adt, ok := m.symbolTable[uint64(addr)]; ok {
-		dt = adt
-	}
-	if dataType != datatype.UNSET {
-		dt = dataType
-	}
+func (m *Machine) applyRegAof(thread *Thread, in *instruction, dataType datatype.DataType, operation aluOperation) {
+	value, _ := m.readAof(thread, in, dataType)
+	result, flags := operation(m.getRegister(thread, in.reg0), value)
+	m.setRegister(thread, in.reg0, result)
+	m.setFlags(thread, flags)
+}
+
+// The signed variants sign extend the operand from its data type first.
 
-	switch v := value.(type) {
-	case *ast.RegisterOffsetNumber:
-		addr = applyOffset(addr, v.Operator, v.Right.Value)
-	case *ast.RegisterOffsetRegister:
-		addr = applyOffset(addr, v.Operator, strconv.Itoa(int(m.getRegister(thread, v.Right.Value))))
-	case *ast.LabelOffsetNumber:
-		addr = applyOffset(addr, v.Operator, v.Right.Value)
-	case *ast.LabelOffsetRegister:
-		addr = applyOffset(addr, v.Operator, strconv.Itoa(int(m.getRegister(thread, v.Right.Value))))
+func (m *Machine) applyRegLitSigned(thread *Thread, in *instruction, dataType datatype.DataType, operation aluOperation) {
+	result, flags := operation(m.getRegister(thread, in.reg0), uint64(signExtend(in.lit, dataType)))
+	m.setRegister(thread, in.reg0, result)
+	m.setFlags(thread, flags)
+}
+
+func (m *Machine) applyRegAofSigned(thread *Thread, in *instruction, dataType datatype.DataType, operation aluOperation) {
+	value, dt := m.readAof(thread, in, dataType)
+	result, flags := operation(m.getRegister(thread, in.reg0), uint64(signExtend(value, dt)))
+	m.setRegister(thread, in.reg0, result)
+	m.setFlags(thread, flags)
+}
+
+// readAof reads the memory operand of an instruction. The data type is taken
+// from the instruction, or from the symbol table when the instruction has none.
+func (m *Machine) readAof(thread *Thread, in *instruction, dataType datatype.DataType) (uint64, datatype.DataType) {
+	addr, dt := m.address(thread, &in.mem, dataType)
+	return m.loadValue(addr, dt), dt
+}
+
+func (m *Machine) loadValue(addr int, dt datatype.DataType) uint64 {
+	m.checkAccess(uint64(addr), dt.Size(), PERM_READ)
+
+	switch dt {
+	case datatype.BYTE:
+		return uint64(m.memory[addr])
+	case datatype.WORD:
+		return uint64(binary.BigEndian.Uint16(m.memory[addr : addr+dt.Size()]))
+	case datatype.DWORD:
+		return uint64(binary.BigEndian.Uint32(m.memory[addr : addr+dt.Size()]))
+	default:
+		return binary.BigEndian.Uint64(m.memory[addr : addr+dt.Size()])
 	}
+}
+
+// storeValue writes the low bytes of value that fit the data type.
+func (m *Machine) storeValue(thread *Thread, addr int, dt datatype.DataType, value uint64) {
+	m.checkAccess(uint64(addr), dt.Size(), PERM_WRITE)
+	m.traceWrite(thread, uint64(addr), dt.Size())
 
 	switch dt {
 	case datatype.BYTE:
-		temp0 := m.getRegister(thread, reg0)
-		temp1 := uint64(m.memory[addr])
-		m.setRegister(thread, reg0, operation(temp0, temp1))
+		m.memory[addr] = byte(value)
 	case datatype.WORD:
-		temp0 := m.getRegister(thread, reg0)
-		temp1 := binary.BigEndian.Uint16(m.memory[addr : addr+dt.Size()])
-		m.setRegister(thread, reg0, operation(temp0, uint64(temp1)))
+		binary.BigEndian.PutUint16(m.memory[addr:addr+dt.Size()], uint16(value))
 	case datatype.DWORD:
-		temp0 := m.getRegister(thread, reg0)
-		temp1 := binary.BigEndian.Uint32(m.memory[addr : addr+dt.Size()])
-		m.setRegister(thread, reg0, operation(temp0, uint64(temp1)))
-	case datatype.QWORD, datatype.UNSET:
-		temp0 := m.getRegister(thread, reg0)
-		temp1 := binary.BigEndian.Uint64(m.memory[addr : addr+dt.Size()])
-		m.setRegister(thread, reg0, operation(temp0, temp1))
+		binary.BigEndian.PutUint32(m.memory[addr:addr+dt.Size()], uint32(value))
+	default:
+		binary.BigEndian.PutUint64(m.memory[addr:addr+dt.Size()], value)
 	}
 }
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"fishy/pkg/datatype"
+	"fishy/pkg/opcode"
+)
+
+// handleAtomic runs xchg, xadd and cas. They are atomic with respect to each
+// other, plain movs to the same memory are not.
+func (m *Machine) handleAtomic(thread *Thread, in *instruction) {
+	switch in.op {
+	case opcode.XCHG_REG_REG:
+		reg0, reg1 := in.reg0, in.reg1
+		value0, value1 := m.getRegister(thread, reg0), m.getRegister(thread, reg1)
+		m.setRegister(thread, reg0, value1)
+		m.setRegister(thread, reg1, value0)
+	case opcode.XCHG_REG_AOF:
+		reg := in.reg0
+		addr, dt := m.address(thread, &in.mem, in.dt)
+
+		m.atomicMu.Lock()
+		defer m.atomicMu.Unlock()
+		old := m.loadValue(addr, dt)
+		m.storeValue(thread, addr, dt, m.getRegister(thread, reg))
+		m.setRegister(thread, reg, old)
+	case opcode.XADD_REG_AOF:
+		reg := in.reg0
+		addr, dt := m.address(thread, &in.mem, in.dt)
+
+		m.atomicMu.Lock()
+		defer m.atomicMu.Unlock()
+		old := m.loadValue(addr, dt)
+		result, flags := aluAdd(old, m.getRegister(thread, reg))
+		m.storeValue(thread, addr, dt, result)
+		m.setRegister(thread, reg, old)
+		m.setFlags(thread, flags)
+	case opcode.CAS_REG_REG_AOF:
+		expected, replacement := in.reg0, in.reg1
+		addr, dt := m.address(thread, &in.mem, in.dt)
+
+		m.atomicMu.Lock()
+		defer m.atomicMu.Unlock()
+		current := m.loadValue(addr, dt)
+		if current == truncate(m.getRegister(thread, expected), dt) {
+			m.storeValue(thread, addr, dt, m.getRegister(thread, replacement))
+			m.setFlags(thread, FLAG_ZERO)
+		} else {
+			m.setRegister(thread, expected, current)
+			m.setFlags(thread, 0)
+		}
+	}
+}
+
+// truncate keeps the bytes of value that fit the data type.
+func truncate(value uint64, dt datatype.DataType) uint64 {
+	switch dt {
+	case datatype.BYTE:
+		return value & 0xFF
+	case datatype.WORD:
+		return value & 0xFFFF
+	case datatype.DWORD:
+		return value & 0xFFFFFFFF
+	default:
+		return value
+	}
+}
@@ -0,0 +1,263 @@
+package vm
+
+import (
+	"fishy/pkg/utils"
+)
+
+// maxChanCapacity bounds the buffer SYS_CHAN_CREATE allocates on the host.
+const maxChanCapacity = 1 << 16
+
+// guestChan is a channel of qwords. The values travel through a Go channel,
+// closing is signalled on done instead of closing values so a sender racing
+// with chan_close fails with EPIPE instead of panicking.
+//
+// The deterministic scheduler never blocks on values. An unbuffered channel
+// gets a buffer of one there, and a sender waits until received catches up
+// with the ticket of its value.
+type guestChan struct {
+	values     chan uint64
+	done       chan struct{}
+	closed     bool
+	unbuffered bool
+
+	received  uint64
+	receivers int
+}
+
+func (m *Machine) chanCreate(capacity uint64) uint64 {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &guestChan{done: make(chan struct{}), unbuffered: capacity == 0}
+	if m.scheduler != nil && capacity == 0 {
+		capacity = 1
+	}
+	c.values = make(chan uint64, capacity)
+
+	handle := s.next
+	s.next++
+	s.chans[handle] = c
+	return handle
+}
+
+func (m *Machine) getChan(handle uint64) (*guestChan, bool) {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.chans[handle]
+	return c, ok
+}
+
+func (m *Machine) chanClose(handle uint64) ErrorCode {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.chans[handle]
+	if !ok || c.closed {
+		return EINVAL
+	}
+	c.closed = true
+	close(c.done)
+	return 0
+}
+
+func (c *guestChan) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// chanSend sends value, waiting for room unless try is set. Sending on a
+// closed channel fails with EPIPE, a full one with EAGAIN when trying.
+func (m *Machine) chanSend(thread *Thread, handle uint64, value uint64, try bool) ErrorCode {
+	c, ok := m.getChan(handle)
+	if !ok {
+		return EINVAL
+	}
+	if m.scheduler != nil {
+		return m.chanSendScheduled(thread, c, value, try)
+	}
+	if c.isClosed() {
+		return EPIPE
+	}
+
+	if try {
+		select {
+		case c.values <- value:
+			return 0
+		default:
+			return EAGAIN
+		}
+	}
+
+	select {
+	case c.values <- value:
+		return 0
+	case <-c.done:
+		return EPIPE
+	case <-m.quit:
+		return EINTR
+	case <-thread.stopped:
+		return EINTR
+	case <-m.pauseChan(thread):
+		m.block(thread)
+		return 0
+	}
+}
+
+func (m *Machine) chanSendScheduled(thread *Thread, c *guestChan, value uint64, try bool) ErrorCode {
+	if thread.sending == c {
+		if c.received < thread.ticket {
+			m.block(thread)
+			return 0
+		}
+		thread.sending = nil
+		return 0
+	}
+	if c.isClosed() {
+		return EPIPE
+	}
+	if try && c.unbuffered && c.receivers == 0 {
+		return EAGAIN
+	}
+
+	select {
+	case c.values <- value:
+	default:
+		if try {
+			return EAGAIN
+		}
+		m.block(thread)
+		return 0
+	}
+
+	// like a Go channel an unbuffered send only returns once it was received
+	if c.unbuffered && !try {
+		thread.sending = c
+		thread.ticket = c.received + uint64(len(c.values))
+		m.block(thread)
+	}
+	return 0
+}
+
+// chanRecv returns the next value, ok is false once the channel was closed
+// and everything sent before was received. An empty channel fails with
+// EAGAIN when trying.
+func (m *Machine) chanRecv(thread *Thread, handle uint64, try bool) (value uint64, ok bool, code ErrorCode) {
+	c, found := m.getChan(handle)
+	if !found {
+		return 0, false, EINVAL
+	}
+	if m.scheduler != nil {
+		return m.chanRecvScheduled(thread, c, try)
+	}
+
+	if try {
+		select {
+		case value := <-c.values:
+			return value, true, 0
+		default:
+			if c.isClosed() {
+				return 0, false, 0
+			}
+			return 0, false, EAGAIN
+		}
+	}
+
+	select {
+	case value := <-c.values:
+		return value, true, 0
+	case <-c.done:
+		// values sent before the close are still delivered
+		select {
+		case value := <-c.values:
+			return value, true, 0
+		default:
+			return 0, false, 0
+		}
+	case <-m.quit:
+		return 0, false, EINTR
+	case <-thread.stopped:
+		return 0, false, EINTR
+	case <-m.pauseChan(thread):
+		m.block(thread)
+		return 0, false, 0
+	}
+}
+
+func (m *Machine) chanRecvScheduled(thread *Thread, c *guestChan, try bool) (uint64, bool, ErrorCode) {
+	if thread.receiving == c {
+		thread.receiving = nil
+		c.receivers--
+	}
+
+	select {
+	case value := <-c.values:
+		c.received++
+		return value, true, 0
+	default:
+	}
+	if c.isClosed() {
+		return 0, false, 0
+	}
+	if try {
+		return 0, false, EAGAIN
+	}
+
+	// counted so a try_send on an unbuffered channel sees the receiver
+	thread.receiving = c
+	c.receivers++
+	m.block(thread)
+	return 0, false, 0
+}
+
+// chanSendSyscall sends x1 on the channel x0.
+func chanSendSyscall(try bool) SyscallFunction {
+	return func(m *Machine, thread *Thread) {
+		handle := m.getRegister(thread, utils.RegisterToIndex("x0"))
+		value := m.getRegister(thread, utils.RegisterToIndex("x1"))
+
+		n := -1
+		if code := m.chanSend(thread, handle, value, try); code != 0 {
+			m.SetErrorCodeRegister(thread, code)
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+			return
+		}
+		if thread.blocked {
+			return
+		}
+
+		m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+	}
+}
+
+// chanRecvSyscall receives from the channel x0 into x0, x1 is 1 if a value
+// was received and 0 if the channel is closed.
+func chanRecvSyscall(try bool) SyscallFunction {
+	return func(m *Machine, thread *Thread) {
+		handle := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+		n := -1
+		value, ok, code := m.chanRecv(thread, handle, try)
+		if code != 0 {
+			m.SetErrorCodeRegister(thread, code)
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+			return
+		}
+		if thread.blocked {
+			return
+		}
+
+		received := uint64(0)
+		if ok {
+			received = 1
+		}
+		m.setRegister(thread, utils.RegisterToIndex("x0"), value)
+		m.setRegister(thread, utils.RegisterToIndex("x1"), received)
+	}
+}
@@ -1,56 +1,17 @@
 package vm
 
 import (
-	"fishy/pkg/datatype"
 	"fishy/pkg/opcode"
-	"fishy/pkg/utils"
 )
 
-type Flag int
-
-const (
-	FLAG_EQ Flag = iota
-	FLAG_LT
-	FLAG_GT
-)
-
-func (m *Machine) handleCompare(thread *Thread, op opcode.Opcode) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	switch op {
+// handleCompare sets the flags like sub would without storing the result.
+func (m *Machine) handleCompare(thread *Thread, in *instruction) {
+	switch in.op {
 	case opcode.CMP_REG_LIT:
-		m.applyRegLitCompare(thread, func(reg, lit uint64) Flag {
-			if reg == lit {
-				return FLAG_EQ
-			}
-			if reg < lit {
-				return FLAG_LT
-			}
-			return FLAG_GT
-		})
+		_, flags := aluSub(m.getRegister(thread, in.reg0), in.lit)
+		m.setFlags(thread, flags)
 	case opcode.CMP_REG_REG:
-		m.applyRegRegCompare(thread, func(reg0, reg1 uint64) Flag {
-			if reg0 == reg1 {
-				return FLAG_EQ
-			}
-			if reg0 < reg1 {
-				return FLAG_LT
-			}
-			return FLAG_GT
-		})
+		_, flags := aluSub(m.getRegister(thread, in.reg0), m.getRegister(thread, in.reg1))
+		m.setFlags(thread, flags)
 	}
 }
-
-func (m *Machine) applyRegLitCompare(thread *Thread, operation func(uint64, uint64) Flag) {
-	reg := m.readRegister(thread)
-	lit := m.readLiteral(thread, datatype.QWORD)
-	result := operation(m.getRegister(thread, reg), lit)
-	m.setRegister(thread, utils.RegisterToIndex("cp"), uint64(result))
-}
-
-func (m *Machine) applyRegRegCompare(thread *Thread, operation func(uint64, uint64) Flag) {
-	reg0 := m.readRegister(thread)
-	reg1 := m.readRegister(thread)
-	result := operation(m.getRegister(thread, reg0), m.getRegister(thread, reg1))
-	m.setRegister(thread, utils.RegisterToIndex("cp"), uint64(result))
-}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"fishy/pkg/bytecode"
+	"fishy/pkg/cover"
+	"fishy/pkg/datatype"
+	"fishy/pkg/disasm"
+	"fishy/pkg/opcode"
+	"sort"
+)
+
+type coverCount struct {
+	count    uint64
+	taken    uint64
+	notTaken uint64
+}
+
+// EnableCoverage counts how often every instruction runs and which way
+// conditional jumps go. See Coverage.
+func (m *Machine) EnableCoverage() {
+	m.covering = true
+}
+
+// coverInstruction counts the instruction at ip after it ran. A syscall that
+// blocked is not counted, it runs again once the thread is resumed.
+func (m *Machine) coverInstruction(thread *Thread, ip uint64, op opcode.Opcode) {
+	if thread.blocked {
+		return
+	}
+	if thread.coverage == nil {
+		thread.coverage = make(map[uint64]*coverCount)
+	}
+	count, ok := thread.coverage[ip]
+	if !ok {
+		count = &coverCount{}
+		thread.coverage[ip] = count
+	}
+	count.count++
+
+	if next, ok := conditionalJump(op, ip); ok {
+		if m.getRegister(thread, regIP) == next {
+			count.notTaken++
+		} else {
+			count.taken++
+		}
+	}
+}
+
+// conditionalJump returns where a conditional jump at ip continues when it
+// is not taken, ok is false for every other instruction.
+func conditionalJump(op opcode.Opcode, ip uint64) (uint64, bool) {
+	// the first condition is jmp
+	for _, jump := range jumpConditions[1:] {
+		switch op {
+		case jump.lit:
+			return ip + 2 + uint64(datatype.QWORD.Size()), true
+		case jump.reg:
+			return ip + 3, true
+		}
+	}
+	return 0, false
+}
+
+// Coverage merges the counts of all threads for every instruction of the
+// text section, it has to be called after Run returned.
+func (m *Machine) Coverage() *cover.Profile {
+	counts := make(map[uint64]coverCount)
+	m.threadsMu.RLock()
+	for i := 0; i < len(m.threads); i++ {
+		for ip, count := range m.threads[i].coverage {
+			total := counts[ip]
+			total.count += count.count
+			total.taken += count.taken
+			total.notTaken += count.notTaken
+			counts[ip] = total
+		}
+	}
+	m.threadsMu.RUnlock()
+
+	p := &cover.Profile{}
+	decoder := disasm.NewDecoder(m.memory, m.labelNames())
+	for _, r := range m.regions {
+		if r.name != bytecode.SECTION_TEXT.String() {
+			continue
+		}
+		for addr := r.start; addr < r.end; {
+			decoded, err := decoder.Decode(addr)
+			if err != nil {
+				// data in the text section, continue with the next line
+				next, ok := m.nextLine(addr)
+				if !ok || next >= r.end {
+					break
+				}
+				addr = next
+				continue
+			}
+
+			count := counts[addr]
+			instruction := cover.Instruction{
+				Addr:        addr,
+				Instruction: disasm.FormatInstruction(decoded.Instruction),
+				Count:       count.count,
+				Taken:       count.taken,
+				NotTaken:    count.notTaken,
+			}
+			_, instruction.Branch = conditionalJump(decoded.Opcode, addr)
+			if position := m.sourceAt(addr); position != nil {
+				instruction.Position = *position
+			}
+			p.Instructions = append(p.Instructions, instruction)
+			addr += uint64(len(decoded.Bytes))
+		}
+	}
+	return p
+}
+
+// nextLine returns the address of the first debug info line after addr.
+func (m *Machine) nextLine(addr uint64) (uint64, bool) {
+	if m.debugInfo == nil {
+		return 0, false
+	}
+	lines := m.debugInfo.Lines
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].Addr > addr })
+	if i == len(lines) {
+		return 0, false
+	}
+	return lines[i].Addr, true
+}
@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"fishy/pkg/bytecode"
+	"fishy/pkg/disasm"
+	"fishy/pkg/opcode"
+	"slices"
+	"sync"
+)
+
+type BreakReason int
+
+const (
+	BREAK_STEP BreakReason = iota
+	BREAK_BREAKPOINT
+	BREAK_INSTRUCTION
+)
+
+func (b BreakReason) String() string {
+	switch b {
+	case BREAK_STEP:
+		return "step"
+	case BREAK_BREAKPOINT:
+		return "breakpoint"
+	case BREAK_INSTRUCTION:
+		return "brk instruction"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakHandler is called from the thread that stopped and blocks it until the
+// handler returns.
+type BreakHandler func(m *Machine, thread *Thread, reason BreakReason)
+
+type debugger struct {
+	mu          sync.Mutex
+	handler     BreakHandler
+	breakpoints map[uint64]bool
+}
+
+func (m *Machine) SetBreakHandler(handler BreakHandler) {
+	m.debugger.mu.Lock()
+	defer m.debugger.mu.Unlock()
+	m.debugger.handler = handler
+}
+
+func (m *Machine) SetBreakpoint(addr uint64) {
+	m.debugger.mu.Lock()
+	defer m.debugger.mu.Unlock()
+	m.debugger.breakpoints[addr] = true
+}
+
+func (m *Machine) ClearBreakpoint(addr uint64) bool {
+	m.debugger.mu.Lock()
+	defer m.debugger.mu.Unlock()
+	if _, ok := m.debugger.breakpoints[addr]; !ok {
+		return false
+	}
+	delete(m.debugger.breakpoints, addr)
+	return true
+}
+
+func (m *Machine) Breakpoints() []uint64 {
+	m.debugger.mu.Lock()
+	defer m.debugger.mu.Unlock()
+	addrs := make([]uint64, 0, len(m.debugger.breakpoints))
+	for addr := range m.debugger.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+// SetStepping makes the thread stop before every instruction it executes.
+func (m *Machine) SetStepping(thread *Thread, stepping bool) {
+	thread.stepping = stepping
+}
+
+func (m *Machine) RegisterValue(thread *Thread, index int) uint64 {
+	return m.getRegister(thread, index)
+}
+
+func (m *Machine) MemorySize() int {
+	return len(m.memory)
+}
+
+func (m *Machine) checkBreak(thread *Thread, op opcode.Opcode) {
+	m.debugger.mu.Lock()
+	handler := m.debugger.handler
+	hit := m.debugger.breakpoints[m.getRegister(thread, regIP)]
+	m.debugger.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+
+	switch {
+	case op == opcode.BRK:
+		handler(m, thread, BREAK_INSTRUCTION)
+	case hit:
+		handler(m, thread, BREAK_BREAKPOINT)
+	case thread.stepping:
+		handler(m, thread, BREAK_STEP)
+	}
+}
+
+// DebugInfo is the debug info section of the program, nil if it was built
+// without one.
+func (m *Machine) DebugInfo() *bytecode.DebugInfo {
+	return m.debugInfo
+}
+
+// SourceAt returns where the instruction at addr was compiled from.
+func (m *Machine) SourceAt(addr uint64) (bytecode.Position, bool) {
+	if position := m.sourceAt(addr); position != nil {
+		return *position, true
+	}
+	return bytecode.Position{}, false
+}
+
+// labelNames names addresses for reports. Without debug info the names are
+// made up like the disassembler does. When several labels share an address
+// the first in alphabetical order wins.
+func (m *Machine) labelNames() map[uint64]string {
+	if m.debugInfo == nil {
+		return disasm.LabelNames(m.symbolTable, m.entry)
+	}
+	return disasm.DebugLabelNames(m.debugInfo)
+}
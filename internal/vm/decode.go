@@ -0,0 +1,306 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fishy/pkg/ast"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/datatype"
+	"fishy/pkg/opcode"
+	"fishy/pkg/utils"
+	"sync/atomic"
+)
+
+// Indices of the registers the interpreter uses on every instruction.
+var (
+	regIP = utils.RegisterToIndex("ip")
+	regSP = utils.RegisterToIndex("sp")
+	regCP = utils.RegisterToIndex("cp")
+	regER = utils.RegisterToIndex("er")
+)
+
+// instruction is an instruction decoded once from memory. Registers are
+// indices into the registers of a thread and literals are numbers, so the
+// handlers never look at the encoding.
+type instruction struct {
+	op   opcode.Opcode
+	size uint64
+	dt   datatype.DataType
+	reg0 int
+	reg1 int
+	lit  uint64
+	mem  operand
+	// condition of a jump, reg0 holds its target unless it is -1
+	condition func(Flags) bool
+}
+
+// operand is a memory operand like [label], [x0 + 8] or [label + x1]. The
+// base is a literal address unless baseReg is set, the offset is applied after
+// the data type was looked up for the base, see address.
+type operand struct {
+	base      uint64
+	baseReg   int
+	operator  ast.Operator
+	offset    uint64
+	offsetReg int
+	hasOffset bool
+	// symbol is the symbol table entry of a literal base
+	symbol   datatype.DataType
+	isSymbol bool
+}
+
+// codeCache holds the decoded instructions of the text section by address.
+// Threads decode concurrently, every slot is written at most once with an
+// equal value until the host patches the code.
+type codeCache struct {
+	start uint64
+	slots []atomic.Pointer[instruction]
+}
+
+func (m *Machine) newCodeCache(file *bytecode.File) {
+	text := file.Section(bytecode.SECTION_TEXT)
+	if text == nil || text.Size == 0 {
+		return
+	}
+	m.code = &codeCache{start: text.Addr, slots: make([]atomic.Pointer[instruction], text.Size)}
+}
+
+// cached returns the decoded instruction at ip or nil if it was not decoded
+// yet or lies outside of the text section.
+func (m *Machine) cached(ip uint64) *instruction {
+	if m.code == nil || ip < m.code.start || ip-m.code.start >= uint64(len(m.code.slots)) {
+		return nil
+	}
+	return m.code.slots[ip-m.code.start].Load()
+}
+
+// fetch decodes the instruction at ip and remembers it when it is part of the
+// text section. Decoding faults like executing the instruction would.
+func (m *Machine) fetch(ip uint64) *instruction {
+	in := m.decode(ip)
+	if m.code != nil && ip >= m.code.start && ip-m.code.start < uint64(len(m.code.slots)) {
+		m.code.slots[ip-m.code.start].Store(in)
+	}
+	return in
+}
+
+// invalidateCode forgets every decoded instruction after the host changed
+// [addr, addr+size) in the text section.
+func (m *Machine) invalidateCode(addr uint64, size uint64) {
+	if m.code == nil || addr+size <= m.code.start || addr >= m.code.start+uint64(len(m.code.slots)) {
+		return
+	}
+	for i := range m.code.slots {
+		m.code.slots[i].Store(nil)
+	}
+}
+
+// decoder reads the encoding of one instruction. Reading past the end of
+// memory panics and faults the thread.
+type decoder struct {
+	memory []byte
+	pos    uint64
+}
+
+func (d *decoder) byte() byte {
+	b := d.memory[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *decoder) number(dt datatype.DataType) uint64 {
+	var value uint64
+	switch dt {
+	case datatype.BYTE:
+		value = uint64(d.memory[d.pos])
+	case datatype.WORD:
+		value = uint64(binary.BigEndian.Uint16(d.memory[d.pos:]))
+	case datatype.DWORD:
+		value = uint64(binary.BigEndian.Uint32(d.memory[d.pos:]))
+	default:
+		value = binary.BigEndian.Uint64(d.memory[d.pos:])
+	}
+	d.pos += uint64(dt.Size())
+	return value
+}
+
+func (d *decoder) register() int {
+	return int(d.byte())
+}
+
+func (m *Machine) decodeDataType(d *decoder) datatype.DataType {
+	dt := datatype.DataType(d.byte())
+	switch dt {
+	case datatype.BYTE, datatype.WORD, datatype.DWORD, datatype.QWORD, datatype.UNSET:
+		return dt
+	default:
+		m.fault("invalid data type 0x%02X", int(dt))
+		return dt
+	}
+}
+
+// decodeOperand reads a memory operand, its literals have the size of the
+// data type of the instruction.
+func (m *Machine) decodeOperand(d *decoder, dt datatype.DataType) operand {
+	o := operand{baseReg: -1, offsetReg: -1}
+	switch kind := d.byte(); kind {
+	case 0, 1, 3, 4:
+		o.base = d.number(dt)
+	case 2:
+		o.baseReg = d.register()
+	case 5:
+		o.baseReg = d.register()
+		o.operator = ast.Operator(d.byte())
+		o.offset = d.number(dt)
+		o.hasOffset = true
+	case 6:
+		o.baseReg = d.register()
+		o.operator = ast.Operator(d.byte())
+		o.offsetReg = d.register()
+		o.hasOffset = true
+	case 7:
+		o.base = d.number(dt)
+		o.operator = ast.Operator(d.byte())
+		o.offset = d.number(dt)
+		o.hasOffset = true
+	case 8:
+		o.base = d.number(dt)
+		o.operator = ast.Operator(d.byte())
+		o.offsetReg = d.register()
+		o.hasOffset = true
+	default:
+		m.fault("unknown value index %d", kind)
+	}
+
+	if o.baseReg < 0 {
+		o.symbol, o.isSymbol = m.symbolTable[o.base]
+	}
+	return o
+}
+
+// decode reads the instruction at addr. Unknown opcodes decode to an
+// instruction without operands that faults when it is executed.
+func (m *Machine) decode(addr uint64) *instruction {
+	d := &decoder{memory: m.memory, pos: addr}
+	in := &instruction{op: opcode.Opcode(d.number(datatype.WORD)), dt: datatype.UNSET}
+
+	switch in.op {
+	case opcode.MOV_REG_REG:
+		// the data type is encoded but unused
+		d.byte()
+		in.reg0 = d.register()
+		in.reg1 = d.register()
+	case opcode.MOV_REG_LIT, opcode.MOV_REG_ADR,
+		opcode.ADD_REG_LIT, opcode.SUB_REG_LIT, opcode.MUL_REG_LIT, opcode.DIV_REG_LIT,
+		opcode.IMUL_REG_LIT, opcode.IDIV_REG_LIT, opcode.MOD_REG_LIT,
+		opcode.NEG_REG_LIT, opcode.INC_REG_LIT, opcode.DEC_REG_LIT,
+		opcode.AND_REG_LIT, opcode.OR_REG_LIT, opcode.XOR_REG_LIT,
+		opcode.SHL_REG_LIT, opcode.SHR_REG_LIT, opcode.SAR_REG_LIT, opcode.NOT_REG_LIT,
+		opcode.FADD_REG_LIT, opcode.FSUB_REG_LIT, opcode.FMUL_REG_LIT, opcode.FDIV_REG_LIT,
+		opcode.FCMP_REG_LIT, opcode.ITOF_REG_LIT, opcode.FTOI_REG_LIT:
+		in.dt = m.decodeDataType(d)
+		in.reg0 = d.register()
+		in.lit = d.number(in.dt)
+	case opcode.ADD_REG_REG, opcode.SUB_REG_REG, opcode.MUL_REG_REG, opcode.DIV_REG_REG,
+		opcode.IMUL_REG_REG, opcode.IDIV_REG_REG, opcode.MOD_REG_REG,
+		opcode.NEG_REG_REG, opcode.INC_REG_REG, opcode.DEC_REG_REG,
+		opcode.AND_REG_REG, opcode.OR_REG_REG, opcode.XOR_REG_REG,
+		opcode.SHL_REG_REG, opcode.SHR_REG_REG, opcode.SAR_REG_REG, opcode.NOT_REG_REG,
+		opcode.FADD_REG_REG, opcode.FSUB_REG_REG, opcode.FMUL_REG_REG, opcode.FDIV_REG_REG,
+		opcode.FCMP_REG_REG, opcode.ITOF_REG_REG, opcode.FTOI_REG_REG,
+		opcode.XCHG_REG_REG:
+		in.dt = m.decodeDataType(d)
+		in.reg0 = d.register()
+		in.reg1 = d.register()
+	case opcode.MOV_REG_AOF,
+		opcode.ADD_REG_AOF, opcode.SUB_REG_AOF, opcode.MUL_REG_AOF, opcode.DIV_REG_AOF,
+		opcode.IMUL_REG_AOF, opcode.IDIV_REG_AOF, opcode.MOD_REG_AOF,
+		opcode.NEG_REG_AOF, opcode.INC_REG_AOF, opcode.DEC_REG_AOF, opcode.NOT_REG_AOF,
+		opcode.FADD_REG_AOF, opcode.FSUB_REG_AOF, opcode.FMUL_REG_AOF, opcode.FDIV_REG_AOF,
+		opcode.FCMP_REG_AOF, opcode.ITOF_REG_AOF, opcode.FTOI_REG_AOF,
+		opcode.XCHG_REG_AOF, opcode.XADD_REG_AOF:
+		in.dt = m.decodeDataType(d)
+		in.reg0 = d.register()
+		in.mem = m.decodeOperand(d, in.dt)
+	case opcode.NEG_REG, opcode.INC_REG, opcode.DEC_REG, opcode.NOT_REG,
+		opcode.ITOF_REG, opcode.FTOI_REG,
+		opcode.PUSH_REG, opcode.POP_REG:
+		in.dt = m.decodeDataType(d)
+		in.reg0 = d.register()
+	case opcode.MOV_AOF_REG:
+		in.dt = m.decodeDataType(d)
+		in.mem = m.decodeOperand(d, in.dt)
+		in.reg0 = d.register()
+	case opcode.MOV_AOF_LIT:
+		in.dt = m.decodeDataType(d)
+		in.mem = m.decodeOperand(d, in.dt)
+		in.lit = d.number(in.dt)
+	case opcode.PUSH_LIT:
+		in.dt = m.decodeDataType(d)
+		in.lit = d.number(in.dt)
+	case opcode.PUSH_AOF, opcode.POP_AOF, opcode.CALL_AOF:
+		in.dt = m.decodeDataType(d)
+		in.mem = m.decodeOperand(d, in.dt)
+	case opcode.CAS_REG_REG_AOF:
+		in.dt = m.decodeDataType(d)
+		in.reg0 = d.register()
+		in.reg1 = d.register()
+		in.mem = m.decodeOperand(d, in.dt)
+	case opcode.CMP_REG_LIT:
+		in.reg0 = d.register()
+		in.lit = d.number(datatype.QWORD)
+	case opcode.CMP_REG_REG:
+		in.reg0 = d.register()
+		in.reg1 = d.register()
+	case opcode.CALL_LIT:
+		in.lit = d.number(datatype.QWORD)
+	case opcode.CALL_REG:
+		in.reg0 = d.register()
+	default:
+		decodeJump(d, in)
+	}
+
+	in.size = d.pos - addr
+	return in
+}
+
+// address resolves a memory operand to the address it refers to and the data
+// type to access it with. Without a data type the symbol table entry of the
+// base address decides, before the offset is applied.
+func (m *Machine) address(thread *Thread, o *operand, dataType datatype.DataType) (int, datatype.DataType) {
+	addr := int(o.base)
+	dt := dataType
+	if o.baseReg >= 0 {
+		addr = int(thread.registers[o.baseReg])
+		if dt == datatype.UNSET {
+			if symbol, ok := m.symbolTable[uint64(addr)]; ok {
+				dt = symbol
+			}
+		}
+	} else if dt == datatype.UNSET && o.isSymbol {
+		dt = o.symbol
+	}
+
+	if o.hasOffset {
+		offset := o.offset
+		if o.offsetReg >= 0 {
+			offset = thread.registers[o.offsetReg]
+		}
+		addr = applyOffset(addr, o.operator, offset)
+	}
+	return addr, dt
+}
+
+func applyOffset(addr int, operator ast.Operator, value uint64) int {
+	switch operator {
+	case ast.ADD:
+		return addr + int(value)
+	case ast.SUBTRACT:
+		return addr - int(value)
+	case ast.MULTIPLY:
+		return addr * int(value)
+	case ast.DIVIDE:
+		return addr / int(value)
+	default:
+		return addr
+	}
+}
@@ -66,6 +66,10 @@ var ErrorMap = map[ErrorCode]string{
 	EADDROUTOFBOUNDS: "address out of bounds",
 	EINVALIDLENGTH:   "invalid length",
 	EBADHOSTADDRESS:  "bad host address",
+	ENOMEM:           "cannot allocate memory",
+	EDOUBLEFREE:      "double free",
+	EINTR:            "interrupted system call",
+	ETHREADFAULT:     "thread faulted",
 }
 
 const (
@@ -119,6 +123,10 @@ const (
 	EADDROUTOFBOUNDS
 	EINVALIDLENGTH
 	EBADHOSTADDRESS
+	ENOMEM
+	EDOUBLEFREE
+	EINTR
+	ETHREADFAULT
 )
 
 func MatchString(value string) ErrorCode {
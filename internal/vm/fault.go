@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"errors"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/opcode"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Fault describes a guest program error that stopped a thread. Opcode is -1
+// when the fault happened before the instruction could be fetched. Err is set
+// for faults callers may want to test for with errors.Is, like ErrTimeout.
+// Source is the line of the instruction if the program has debug info.
+type Fault struct {
+	Thread int
+	IP     uint64
+	Opcode opcode.Opcode
+	Reason string
+	Err    error
+	Source *bytecode.Position
+}
+
+func (f *Fault) Error() string {
+	at := fmt.Sprintf("0x%04X", f.IP)
+	if f.Opcode >= 0 {
+		at += fmt.Sprintf(" (%s)", f.Opcode.String())
+	}
+	if f.Source != nil {
+		at += " in " + f.Source.String()
+	}
+	return fmt.Sprintf("thread %d faulted at %s: %s", f.Thread, at, f.Reason)
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// fault aborts the current instruction. The panic is recovered by RunThread
+// which fills in where it happened and returns it as a *Fault.
+func (m *Machine) fault(format string, args ...interface{}) {
+	panic(&Fault{Reason: fmt.Sprintf(format, args...)})
+}
+
+func (m *Machine) recoverFault(thread *Thread, ip uint64, op opcode.Opcode, r interface{}) *Fault {
+	fault, ok := r.(*Fault)
+	if !ok {
+		fault = &Fault{Reason: fmt.Sprint(r)}
+
+		var rerr runtime.Error
+		if err, isErr := r.(error); isErr && errors.As(err, &rerr) {
+			message := rerr.Error()
+			switch {
+			case strings.Contains(message, "index out of range"), strings.Contains(message, "slice bounds out of range"):
+				fault.Reason = "memory access out of bounds"
+			default:
+				fault.Reason = strings.TrimPrefix(message, "runtime error: ")
+			}
+		}
+	}
+
+	fault.Thread, _ = m.GetThreadIndex(thread)
+	fault.IP = ip
+	fault.Opcode = op
+	fault.Source = m.sourceAt(ip)
+	return fault
+}
+
+// sourceAt returns the source position of the instruction at addr, or nil
+// without debug info or outside of the text section.
+func (m *Machine) sourceAt(addr uint64) *bytecode.Position {
+	if m.debugInfo == nil {
+		return nil
+	}
+	if r, ok := m.regionAt(addr); !ok || r.name != bytecode.SECTION_TEXT.String() {
+		return nil
+	}
+	position, ok := m.debugInfo.Lookup(addr)
+	if !ok {
+		return nil
+	}
+	return &position
+}
@@ -0,0 +1,165 @@
+package vm
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Flags is the value of the cp register. Arithmetic, bitwise and compare
+// instructions set it from their 64 bit result, conditional jumps read it.
+type Flags uint64
+
+const (
+	FLAG_ZERO Flags = 1 << iota
+	FLAG_SIGN
+	FLAG_CARRY
+	FLAG_OVERFLOW
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+func (m *Machine) flags(thread *Thread) Flags {
+	return Flags(m.getRegister(thread, regCP))
+}
+
+func (m *Machine) setFlags(thread *Thread, flags Flags) {
+	m.setRegister(thread, regCP, uint64(flags))
+}
+
+// aluOperation computes the result of an instruction and the flags it sets.
+type aluOperation func(a, b uint64) (uint64, Flags)
+
+func resultFlags(result uint64) Flags {
+	var flags Flags
+	if result == 0 {
+		flags |= FLAG_ZERO
+	}
+	if int64(result) < 0 {
+		flags |= FLAG_SIGN
+	}
+	return flags
+}
+
+func aluAdd(a, b uint64) (uint64, Flags) {
+	result, carry := bits.Add64(a, b, 0)
+	flags := resultFlags(result)
+	if carry != 0 {
+		flags |= FLAG_CARRY
+	}
+	if (a^result)&(b^result)>>63 != 0 {
+		flags |= FLAG_OVERFLOW
+	}
+	return result, flags
+}
+
+func aluSub(a, b uint64) (uint64, Flags) {
+	result, borrow := bits.Sub64(a, b, 0)
+	flags := resultFlags(result)
+	if borrow != 0 {
+		flags |= FLAG_CARRY
+	}
+	if (a^b)&(a^result)>>63 != 0 {
+		flags |= FLAG_OVERFLOW
+	}
+	return result, flags
+}
+
+func aluMul(a, b uint64) (uint64, Flags) {
+	hi, result := bits.Mul64(a, b)
+	flags := resultFlags(result)
+	if hi != 0 {
+		flags |= FLAG_CARRY | FLAG_OVERFLOW
+	}
+	return result, flags
+}
+
+func aluImul(a, b uint64) (uint64, Flags) {
+	x, y := int64(a), int64(b)
+	result := x * y
+	flags := resultFlags(uint64(result))
+	if x != 0 && (result/x != y || (x == -1 && y == math.MinInt64)) {
+		flags |= FLAG_CARRY | FLAG_OVERFLOW
+	}
+	return uint64(result), flags
+}
+
+func aluDiv(a, b uint64) (uint64, Flags) {
+	result := a / b
+	return result, resultFlags(result)
+}
+
+func aluIdiv(a, b uint64) (uint64, Flags) {
+	x, y := int64(a), int64(b)
+	result := uint64(x / y)
+	flags := resultFlags(result)
+	if x == math.MinInt64 && y == -1 {
+		flags |= FLAG_OVERFLOW
+	}
+	return result, flags
+}
+
+func aluMod(a, b uint64) (uint64, Flags) {
+	result := a % b
+	return result, resultFlags(result)
+}
+
+func aluAnd(a, b uint64) (uint64, Flags) {
+	return a & b, resultFlags(a & b)
+}
+
+func aluOr(a, b uint64) (uint64, Flags) {
+	return a | b, resultFlags(a | b)
+}
+
+func aluXor(a, b uint64) (uint64, Flags) {
+	return a ^ b, resultFlags(a ^ b)
+}
+
+// The shifts set carry to the last bit shifted out.
+
+func aluShl(a, b uint64) (uint64, Flags) {
+	result := a << b
+	flags := resultFlags(result)
+	if b > 0 && b <= 64 && (a>>(64-b))&1 != 0 {
+		flags |= FLAG_CARRY
+	}
+	return result, flags
+}
+
+func aluShr(a, b uint64) (uint64, Flags) {
+	result := a >> b
+	flags := resultFlags(result)
+	if b > 0 && b <= 64 && (a>>(b-1))&1 != 0 {
+		flags |= FLAG_CARRY
+	}
+	return result, flags
+}
+
+func aluSar(a, b uint64) (uint64, Flags) {
+	result := uint64(int64(a) >> b)
+	flags := resultFlags(result)
+	if b > 0 && uint64(int64(a)>>min(b-1, 63))&1 != 0 {
+		flags |= FLAG_CARRY
+	}
+	return result, flags
+}
+
+// The unary operations work on their second operand, see applyReg.
+
+func aluNeg(_, b uint64) (uint64, Flags) {
+	return aluSub(0, b)
+}
+
+func aluNot(_, b uint64) (uint64, Flags) {
+	return ^b, resultFlags(^b)
+}
+
+func aluInc(_, b uint64) (uint64, Flags) {
+	return aluAdd(b, 1)
+}
+
+func aluDec(_, b uint64) (uint64, Flags) {
+	return aluSub(b, 1)
+}
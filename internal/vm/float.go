@@ -0,0 +1,148 @@
+package vm
+
+import (
+	"fishy/pkg/datatype"
+	"fishy/pkg/opcode"
+	"math"
+)
+
+// Floats live in the general purpose registers as their IEEE-754 bits. A dword
+// instruction works on single precision values in the low 32 bits, anything
+// else on double precision values.
+func (m *Machine) handleFloat(thread *Thread, in *instruction) {
+	rdt := in.dt
+
+	// memory operands are read with the width of the float, not the symbol
+	adt := rdt
+	if adt == datatype.UNSET {
+		adt = datatype.QWORD
+	}
+
+	switch in.op {
+	case opcode.FADD_REG_LIT:
+		m.applyRegLit(thread, in, floatOperation(rdt, floatAdd))
+	case opcode.FADD_REG_REG:
+		m.applyRegReg(thread, in, floatOperation(rdt, floatAdd))
+	case opcode.FADD_REG_AOF:
+		m.applyRegAof(thread, in, adt, floatOperation(rdt, floatAdd))
+	case opcode.FSUB_REG_LIT:
+		m.applyRegLit(thread, in, floatOperation(rdt, floatSub))
+	case opcode.FSUB_REG_REG:
+		m.applyRegReg(thread, in, floatOperation(rdt, floatSub))
+	case opcode.FSUB_REG_AOF:
+		m.applyRegAof(thread, in, adt, floatOperation(rdt, floatSub))
+	case opcode.FMUL_REG_LIT:
+		m.applyRegLit(thread, in, floatOperation(rdt, floatMul))
+	case opcode.FMUL_REG_REG:
+		m.applyRegReg(thread, in, floatOperation(rdt, floatMul))
+	case opcode.FMUL_REG_AOF:
+		m.applyRegAof(thread, in, adt, floatOperation(rdt, floatMul))
+	case opcode.FDIV_REG_LIT:
+		m.applyRegLit(thread, in, floatOperation(rdt, floatDiv))
+	case opcode.FDIV_REG_REG:
+		m.applyRegReg(thread, in, floatOperation(rdt, floatDiv))
+	case opcode.FDIV_REG_AOF:
+		m.applyRegAof(thread, in, adt, floatOperation(rdt, floatDiv))
+	case opcode.FCMP_REG_LIT:
+		m.applyRegLit(thread, in, floatCompare(rdt))
+	case opcode.FCMP_REG_REG:
+		m.applyRegReg(thread, in, floatCompare(rdt))
+	case opcode.FCMP_REG_AOF:
+		m.applyRegAof(thread, in, adt, floatCompare(rdt))
+	case opcode.ITOF_REG:
+		m.applyReg(thread, in, intToFloat(rdt))
+	case opcode.ITOF_REG_LIT:
+		m.applyRegLitSigned(thread, in, rdt, intToFloat(rdt))
+	case opcode.ITOF_REG_REG:
+		m.applyRegReg(thread, in, intToFloat(rdt))
+	case opcode.ITOF_REG_AOF:
+		m.applyRegAofSigned(thread, in, adt, intToFloat(rdt))
+	case opcode.FTOI_REG:
+		m.applyReg(thread, in, floatToInt(rdt))
+	case opcode.FTOI_REG_LIT:
+		m.applyRegLit(thread, in, floatToInt(rdt))
+	case opcode.FTOI_REG_REG:
+		m.applyRegReg(thread, in, floatToInt(rdt))
+	case opcode.FTOI_REG_AOF:
+		m.applyRegAof(thread, in, adt, floatToInt(rdt))
+	}
+}
+
+func bitsToFloat(value uint64, dataType datatype.DataType) float64 {
+	if dataType == datatype.DWORD {
+		return float64(math.Float32frombits(uint32(value)))
+	}
+	return math.Float64frombits(value)
+}
+
+func floatToBits(value float64, dataType datatype.DataType) uint64 {
+	if dataType == datatype.DWORD {
+		return uint64(math.Float32bits(float32(value)))
+	}
+	return math.Float64bits(value)
+}
+
+func floatFlags(value float64) Flags {
+	var flags Flags
+	if value == 0 {
+		flags |= FLAG_ZERO
+	}
+	if math.Signbit(value) {
+		flags |= FLAG_SIGN
+	}
+	return flags
+}
+
+func floatAdd(a, b float64) float64 { return a + b }
+func floatSub(a, b float64) float64 { return a - b }
+func floatMul(a, b float64) float64 { return a * b }
+func floatDiv(a, b float64) float64 { return a / b }
+
+func floatOperation(dataType datatype.DataType, operation func(float64, float64) float64) aluOperation {
+	return func(a, b uint64) (uint64, Flags) {
+		result := operation(bitsToFloat(a, dataType), bitsToFloat(b, dataType))
+		if dataType == datatype.DWORD {
+			result = float64(float32(result))
+		}
+		return floatToBits(result, dataType), floatFlags(result)
+	}
+}
+
+// floatCompare sets the flags like an unsigned cmp so jeq, jlt and friends
+// work on the result. Comparing with NaN sets zero, carry and overflow.
+func floatCompare(dataType datatype.DataType) aluOperation {
+	return func(a, b uint64) (uint64, Flags) {
+		x, y := bitsToFloat(a, dataType), bitsToFloat(b, dataType)
+		switch {
+		case math.IsNaN(x) || math.IsNaN(y):
+			return a, FLAG_ZERO | FLAG_CARRY | FLAG_OVERFLOW
+		case x == y:
+			return a, FLAG_ZERO
+		case x < y:
+			return a, FLAG_CARRY
+		default:
+			return a, 0
+		}
+	}
+}
+
+func intToFloat(dataType datatype.DataType) aluOperation {
+	return func(_, b uint64) (uint64, Flags) {
+		result := float64(int64(b))
+		return floatToBits(result, dataType), floatFlags(result)
+	}
+}
+
+// floatToInt truncates towards zero. NaN and values out of range become the
+// smallest int64 and set the overflow flag.
+func floatToInt(dataType datatype.DataType) aluOperation {
+	return func(_, b uint64) (uint64, Flags) {
+		value := math.Trunc(bitsToFloat(b, dataType))
+		if math.IsNaN(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+			result := uint64(1) << 63
+			return result, resultFlags(result) | FLAG_OVERFLOW
+		}
+		result := uint64(int64(value))
+		return result, resultFlags(result)
+	}
+}
@@ -0,0 +1,280 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const heapAlignment = 8
+
+type span struct {
+	addr uint64
+	size uint64
+}
+
+// heap manages the memory between the end of the program image and the stack.
+// Its bookkeeping lives outside of guest memory so a buffer overflow in the
+// guest cannot corrupt it.
+type heap struct {
+	mu     sync.Mutex
+	start  uint64
+	end    uint64
+	blocks map[uint64]uint64
+	free   []span
+}
+
+func newHeap(start, end uint64) *heap {
+	start = alignUp(start)
+	if end < start {
+		end = start
+	}
+	h := &heap{
+		start:  start,
+		end:    end,
+		blocks: make(map[uint64]uint64),
+	}
+	if end > start {
+		h.free = []span{{start, end - start}}
+	}
+	return h
+}
+
+func alignUp(n uint64) uint64 {
+	return (n + heapAlignment - 1) &^ (heapAlignment - 1)
+}
+
+// fits reports whether a block of size bytes could ever be allocated, larger
+// sizes would also overflow when they are aligned.
+func (h *heap) fits(size uint64) bool {
+	return size <= h.end-h.start
+}
+
+// contains reports whether [addr, addr+size) is part of the heap.
+func (h *heap) contains(addr uint64, size uint64) bool {
+	return addr >= h.start && addr <= h.end && size <= h.end-addr
+}
+
+// alloc returns the address of a block of at least size bytes, first fit.
+func (h *heap) alloc(size uint64) (uint64, bool) {
+	size = alignUp(size)
+	for i, s := range h.free {
+		if s.size < size {
+			continue
+		}
+		if s.size == size {
+			h.free = append(h.free[:i], h.free[i+1:]...)
+		} else {
+			h.free[i] = span{s.addr + size, s.size - size}
+		}
+		h.blocks[s.addr] = size
+		return s.addr, true
+	}
+	return 0, false
+}
+
+// release frees the block at addr. Freeing something that is not an allocated
+// block fails with EDOUBLEFREE if it points into free memory and EINVAL if not.
+func (h *heap) release(addr uint64) ErrorCode {
+	size, ok := h.blocks[addr]
+	if !ok {
+		if h.isFree(addr) {
+			return EDOUBLEFREE
+		}
+		return EINVAL
+	}
+	delete(h.blocks, addr)
+	h.insertFree(span{addr, size})
+	return 0
+}
+
+// grow tries to resize the block at addr in place.
+func (h *heap) grow(addr uint64, size uint64) bool {
+	size = alignUp(size)
+	current := h.blocks[addr]
+	if size <= current {
+		if size < current {
+			h.insertFree(span{addr + size, current - size})
+			h.blocks[addr] = size
+		}
+		return true
+	}
+
+	next := addr + current
+	for i, s := range h.free {
+		if s.addr != next {
+			continue
+		}
+		if current+s.size < size {
+			return false
+		}
+		if current+s.size == size {
+			h.free = append(h.free[:i], h.free[i+1:]...)
+		} else {
+			h.free[i] = span{addr + size, current + s.size - size}
+		}
+		h.blocks[addr] = size
+		return true
+	}
+	return false
+}
+
+func (h *heap) isFree(addr uint64) bool {
+	for _, s := range h.free {
+		if addr >= s.addr && addr < s.addr+s.size {
+			return true
+		}
+	}
+	return false
+}
+
+// insertFree adds a span to the free list, merging it with its neighbours.
+func (h *heap) insertFree(freed span) {
+	i := sort.Search(len(h.free), func(i int) bool { return h.free[i].addr > freed.addr })
+	h.free = append(h.free, span{})
+	copy(h.free[i+1:], h.free[i:])
+	h.free[i] = freed
+
+	if i+1 < len(h.free) && h.free[i].addr+h.free[i].size == h.free[i+1].addr {
+		h.free[i].size += h.free[i+1].size
+		h.free = append(h.free[:i+1], h.free[i+2:]...)
+	}
+	if i > 0 && h.free[i-1].addr+h.free[i-1].size == h.free[i].addr {
+		h.free[i-1].size += h.free[i].size
+		h.free = append(h.free[:i], h.free[i+1:]...)
+	}
+}
+
+// SetHeapSize reserves size bytes after the program image for SYS_ALLOC, the
+// main stack gets the rest unless SetStackSize was called too. It has to be
+// called before the machine runs.
+func (m *Machine) SetHeapSize(size uint64) error {
+	return m.setLayout(size, m.requestedStack)
+}
+
+// SetStackSize makes the stack of the main thread size bytes, the heap gets
+// the rest unless SetHeapSize was called too. It has to be called before the
+// machine runs.
+func (m *Machine) SetStackSize(size uint64) error {
+	return m.setLayout(m.requestedHeap, size)
+}
+
+// setLayout splits the memory after the program into the heap, a guard and
+// the main stack. A size of 0 gets whatever the other one leaves, when both
+// are 0 they split it in half. Memory left over when both are given becomes
+// part of the guard.
+func (m *Machine) setLayout(heapSize, stackSize uint64) error {
+	free := uint64(len(m.memory)) - m.imageSize
+	if free < stackGuardSize {
+		return fmt.Errorf("the %d bytes after the program do not fit a stack guard of %d bytes", free, stackGuardSize)
+	}
+	usable := free - stackGuardSize
+
+	heap, stack := heapSize, stackSize
+	switch {
+	case heap == 0 && stack == 0:
+		heap = usable / 2
+		stack = usable - heap
+	case stack == 0 && heap <= usable:
+		stack = usable - heap
+	case heap == 0 && stack <= usable:
+		heap = usable - stack
+	}
+	if heap > usable || stack > usable-heap {
+		return fmt.Errorf("heap of %d bytes and stack of %d bytes do not fit in the %d bytes after the program", heap, stack, usable)
+	}
+
+	m.heap = newHeap(m.imageSize, m.imageSize+heap)
+	m.mainThread.stackBase = uint64(len(m.memory)) - stack
+	m.mainThread.stackTop = uint64(len(m.memory))
+	m.requestedHeap, m.requestedStack = heapSize, stackSize
+	return nil
+}
+
+// StackSize is the size of the main thread's stack.
+func (m *Machine) StackSize() uint64 {
+	return m.mainThread.stackTop - m.mainThread.stackBase
+}
+
+// HeapSize is the size of the heap region.
+func (m *Machine) HeapSize() uint64 {
+	return m.heap.end - m.heap.start
+}
+
+// heapAlloc returns a cleared block, thread is the one the clearing is traced
+// for.
+func (m *Machine) heapAlloc(thread *Thread, size uint64) (uint64, ErrorCode) {
+	if size == 0 {
+		return 0, EINVAL
+	}
+	if !m.heap.fits(size) {
+		return 0, ENOMEM
+	}
+
+	m.heap.mu.Lock()
+	defer m.heap.mu.Unlock()
+
+	addr, ok := m.heap.alloc(size)
+	if !ok {
+		return 0, ENOMEM
+	}
+	m.traceWrite(thread, addr, int(m.heap.blocks[addr]))
+	clear(m.memory[addr : addr+m.heap.blocks[addr]])
+	return addr, 0
+}
+
+func (m *Machine) heapFree(addr uint64) ErrorCode {
+	if m.isStack(addr) {
+		return EINVAL
+	}
+
+	m.heap.mu.Lock()
+	defer m.heap.mu.Unlock()
+	return m.heap.release(addr)
+}
+
+// heapRealloc works like C's realloc: a null address allocates, a size of
+// zero frees and the contents are kept up to the smaller of both sizes.
+func (m *Machine) heapRealloc(thread *Thread, addr uint64, size uint64) (uint64, ErrorCode) {
+	if addr == 0 {
+		return m.heapAlloc(thread, size)
+	}
+	if size == 0 {
+		return 0, m.heapFree(addr)
+	}
+	if m.isStack(addr) {
+		return 0, EINVAL
+	}
+	if !m.heap.fits(size) {
+		return 0, ENOMEM
+	}
+
+	m.heap.mu.Lock()
+	defer m.heap.mu.Unlock()
+
+	current, ok := m.heap.blocks[addr]
+	if !ok {
+		if m.heap.isFree(addr) {
+			return 0, EDOUBLEFREE
+		}
+		return 0, EINVAL
+	}
+	if m.heap.grow(addr, size) {
+		if grown := m.heap.blocks[addr]; grown > current {
+			m.traceWrite(thread, addr+current, int(grown-current))
+			clear(m.memory[addr+current : addr+grown])
+		}
+		return addr, 0
+	}
+
+	newAddr, ok := m.heap.alloc(size)
+	if !ok {
+		return 0, ENOMEM
+	}
+	newSize := m.heap.blocks[newAddr]
+	m.traceWrite(thread, newAddr, int(newSize))
+	copy(m.memory[newAddr:newAddr+newSize], m.memory[addr:addr+current])
+	clear(m.memory[newAddr+current : newAddr+newSize])
+	m.heap.release(addr)
+	return newAddr, 0
+}
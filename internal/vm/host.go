@@ -0,0 +1,220 @@
+package vm
+
+import (
+	"errors"
+	"fishy/pkg/utils"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"syscall"
+)
+
+// callReturn is the return address of functions started by Call. It is never
+// a valid instruction address, RunThread stops the thread when it reaches it.
+const callReturn = math.MaxUint64
+
+// RegisterSyscall makes fn handle the syscall number index. Registering a
+// number that already exists replaces it, including the builtin ones.
+func (m *Machine) RegisterSyscall(index SyscallIndex, fn SyscallFunction) {
+	m.syscallsMu.Lock()
+	defer m.syscallsMu.Unlock()
+	m.syscalls[index] = fn
+}
+
+func (m *Machine) UnregisterSyscall(index SyscallIndex) {
+	m.syscallsMu.Lock()
+	defer m.syscallsMu.Unlock()
+	delete(m.syscalls, index)
+}
+
+// SetStdin replaces what the guest reads from file descriptor 0.
+func (m *Machine) SetStdin(r io.Reader) {
+	m.stdin = r
+}
+
+// SetStdout replaces where the guest writes to file descriptor 1.
+func (m *Machine) SetStdout(w io.Writer) {
+	m.stdout = w
+}
+
+// SetStderr replaces where the guest writes to file descriptor 2.
+func (m *Machine) SetStderr(w io.Writer) {
+	m.stderr = w
+}
+
+// Exit stops every thread of the machine, Run returns once the current
+// instruction of the main thread is done.
+func (m *Machine) Exit(status int) {
+	m.exitMu.Lock()
+	if !m.exited.Load() {
+		m.exitCode = status
+		m.exited.Store(true)
+	}
+	m.exitMu.Unlock()
+
+	m.interrupt()
+}
+
+// ExitCode returns the status passed to SYS_EXIT, ok is false if the program
+// never called it.
+func (m *Machine) ExitCode() (int, bool) {
+	m.exitMu.Lock()
+	defer m.exitMu.Unlock()
+	return m.exitCode, m.exited.Load()
+}
+
+func (m *Machine) hasExited() bool {
+	return m.exited.Load()
+}
+
+func (m *Machine) SetRegisterValue(thread *Thread, index int, value uint64) {
+	m.setRegister(thread, index, value)
+}
+
+// ReadMemory returns a copy of length bytes starting at addr.
+func (m *Machine) ReadMemory(addr uint64, length uint64) ([]byte, bool) {
+	if addr > uint64(len(m.memory)) || length > uint64(len(m.memory))-addr {
+		return nil, false
+	}
+	return append([]byte{}, m.memory[addr:addr+length]...), true
+}
+
+// WriteMemory copies data to addr. The host is not bound by the memory
+// protection of the guest, so it can patch code and read-only data.
+func (m *Machine) WriteMemory(addr uint64, data []byte) bool {
+	if addr > uint64(len(m.memory)) || uint64(len(data)) > uint64(len(m.memory))-addr {
+		return false
+	}
+	copy(m.memory[addr:], data)
+	m.invalidateCode(addr, uint64(len(data)))
+	return true
+}
+
+// fdTable remembers the host file descriptors the guest opened. A restored
+// machine runs in another process where those numbers mean something else or
+// nothing, so they are invalid until the guest gets the number again.
+type fdTable struct {
+	mu      sync.Mutex
+	open    map[int]bool
+	invalid map[int]bool
+}
+
+func (t *fdTable) track(fd int, open bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !open {
+		delete(t.open, fd)
+		return
+	}
+	if t.open == nil {
+		t.open = make(map[int]bool)
+	}
+	t.open[fd] = true
+	delete(t.invalid, fd)
+}
+
+// invalidFd fails the syscall with EBADF if fd was open when the snapshot the
+// machine was restored from was taken.
+func (m *Machine) invalidFd(thread *Thread, fd uint64) bool {
+	m.fds.mu.Lock()
+	invalid := m.fds.invalid[int(fd)]
+	m.fds.mu.Unlock()
+	if !invalid {
+		return false
+	}
+
+	n := -1
+	m.SetErrorCodeRegister(thread, EBADF)
+	m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+	return true
+}
+
+func (m *Machine) readFd(fd int, buffer []byte) (int, error) {
+	if fd == 0 && m.stdin != nil {
+		n, err := m.interruptible(func() (int, error) { return m.stdin.Read(buffer) })
+		if err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+	return m.interruptible(func() (int, error) { return syscall.Read(fd, buffer) })
+}
+
+// interruptible runs a host call that can block, like reading stdin or a
+// socket, and gives up on it with EINTR once the machine stops. The call keeps
+// running in the background until it returns on its own, so it must not touch
+// guest memory. A timeout faults the syscall that was waiting.
+func (m *Machine) interruptible(call func() (int, error)) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := call()
+		done <- result{n, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-m.quit:
+		m.checkTimeout()
+		return 0, syscall.EINTR
+	}
+}
+
+func (m *Machine) writeFd(fd int, buffer []byte) (int, error) {
+	switch {
+	case fd == 1 && m.stdout != nil:
+		return m.stdout.Write(buffer)
+	case fd == 2 && m.stderr != nil:
+		return m.stderr.Write(buffer)
+	default:
+		return syscall.Write(fd, buffer)
+	}
+}
+
+// Call runs the guest function at addr on a new thread and returns the value
+// it left in x0. Arguments are passed in x0, x1, ... and the function gets the
+// stack below the one of thread, so a syscall handler can call back into the
+// guest with a function pointer it was given. The new thread belongs to
+// thread, the guest cannot see it and it counts towards its coverage and
+// profile.
+func (m *Machine) Call(thread *Thread, addr uint64, args ...uint64) (uint64, error) {
+	if len(args) > 16 {
+		return 0, fmt.Errorf("too many arguments: %d", len(args))
+	}
+
+	sp := m.getRegister(thread, regSP)
+	if sp < thread.stackBase+8 || sp > thread.stackTop {
+		return 0, errors.New("not enough stack for call")
+	}
+
+	callee := newThread()
+	callee.caller = thread
+	if m.covering && thread.coverage == nil {
+		thread.coverage = make(map[uint64]*coverCount)
+	}
+	callee.coverage = thread.coverage
+	if state := thread.profile; state != nil {
+		// profiled as if the syscall called addr
+		ip := m.getRegister(thread, regIP) - 2
+		callee.profile = &threadProfile{root: state.root, current: state.current.child(ip)}
+	}
+	for i, arg := range args {
+		m.setRegister(callee, utils.RegisterToIndex(fmt.Sprintf("x%d", i)), arg)
+	}
+	callee.stackBase = thread.stackBase
+	callee.stackTop = thread.stackTop
+	copy(m.memory[sp-8:sp], utils.Bytes8(callReturn))
+	m.setRegister(callee, regSP, sp-8)
+	m.setRegister(callee, utils.RegisterToIndex("fp"), sp-8)
+	m.setRegister(callee, regIP, addr)
+
+	if err := m.RunThread(callee); err != nil {
+		return 0, err
+	}
+	return m.getRegister(callee, utils.RegisterToIndex("x0")), nil
+}
@@ -3,85 +3,60 @@ package vm
 import (
 	"fishy/pkg/datatype"
 	"fishy/pkg/opcode"
-	"fishy/pkg/utils"
 )
 
-func (m *Machine) handleJump(thread *Thread, op opcode.Opcode) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
+type jumpCondition struct {
+	lit       opcode.Opcode
+	reg       opcode.Opcode
+	condition func(f Flags) bool
+}
 
-	switch op {
-	case opcode.JMP_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-	case opcode.JMP_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-	case opcode.JEQ_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_EQ) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JEQ_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_EQ) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JNE_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_LT) || m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_GT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JNE_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_LT) || m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_GT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JLT_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_LT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JLT_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_LT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JGT_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_GT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JGT_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_GT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JLE_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_EQ) || m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_LT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JLE_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_EQ) || m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_LT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JGE_LIT:
-		target := m.readLiteral(thread, datatype.QWORD)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_EQ) || m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_GT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
-		}
-	case opcode.JGE_REG:
-		targetReg := m.readRegister(thread)
-		target := m.getRegister(thread, targetReg)
-		if m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_EQ) || m.getRegister(thread, utils.RegisterToIndex("cp")) == uint64(FLAG_GT) {
-			m.setRegister(thread, utils.RegisterToIndex("ip"), target)
+// Unsigned comparisons use the carry flag, signed ones sign and overflow like
+// they would after a subtraction.
+var jumpConditions = []jumpCondition{
+	{opcode.JMP_LIT, opcode.JMP_REG, func(f Flags) bool { return true }},
+	{opcode.JEQ_LIT, opcode.JEQ_REG, func(f Flags) bool { return f.Has(FLAG_ZERO) }},
+	{opcode.JNE_LIT, opcode.JNE_REG, func(f Flags) bool { return !f.Has(FLAG_ZERO) }},
+	{opcode.JLT_LIT, opcode.JLT_REG, func(f Flags) bool { return f.Has(FLAG_CARRY) }},
+	{opcode.JGT_LIT, opcode.JGT_REG, func(f Flags) bool { return !f.Has(FLAG_CARRY) && !f.Has(FLAG_ZERO) }},
+	{opcode.JLE_LIT, opcode.JLE_REG, func(f Flags) bool { return f.Has(FLAG_CARRY) || f.Has(FLAG_ZERO) }},
+	{opcode.JGE_LIT, opcode.JGE_REG, func(f Flags) bool { return !f.Has(FLAG_CARRY) }},
+	{opcode.JL_LIT, opcode.JL_REG, func(f Flags) bool { return f.Has(FLAG_SIGN) != f.Has(FLAG_OVERFLOW) }},
+	{opcode.JG_LIT, opcode.JG_REG, func(f Flags) bool { return !f.Has(FLAG_ZERO) && f.Has(FLAG_SIGN) == f.Has(FLAG_OVERFLOW) }},
+	{opcode.JNG_LIT, opcode.JNG_REG, func(f Flags) bool { return f.Has(FLAG_ZERO) || f.Has(FLAG_SIGN) != f.Has(FLAG_OVERFLOW) }},
+	{opcode.JNL_LIT, opcode.JNL_REG, func(f Flags) bool { return f.Has(FLAG_SIGN) == f.Has(FLAG_OVERFLOW) }},
+	{opcode.JO_LIT, opcode.JO_REG, func(f Flags) bool { return f.Has(FLAG_OVERFLOW) }},
+	{opcode.JNO_LIT, opcode.JNO_REG, func(f Flags) bool { return !f.Has(FLAG_OVERFLOW) }},
+	{opcode.JS_LIT, opcode.JS_REG, func(f Flags) bool { return f.Has(FLAG_SIGN) }},
+	{opcode.JNS_LIT, opcode.JNS_REG, func(f Flags) bool { return !f.Has(FLAG_SIGN) }},
+}
+
+// decodeJump fills in the condition and target of a jump, ok is false if op
+// is not one.
+func decodeJump(d *decoder, in *instruction) bool {
+	for _, jump := range jumpConditions {
+		switch in.op {
+		case jump.lit:
+			in.condition = jump.condition
+			in.lit = d.number(datatype.QWORD)
+			in.reg0 = -1
+			return true
+		case jump.reg:
+			in.condition = jump.condition
+			in.reg0 = d.register()
+			return true
 		}
 	}
+	return false
+}
+
+func (m *Machine) handleJump(thread *Thread, in *instruction) {
+	if !in.condition(m.flags(thread)) {
+		return
+	}
+	target := in.lit
+	if in.reg0 >= 0 {
+		target = m.getRegister(thread, in.reg0)
+	}
+	m.setRegister(thread, regIP, target)
 }
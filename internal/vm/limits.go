@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrStepLimit       = errors.New("instruction limit exceeded")
+	ErrThreadStepLimit = errors.New("thread instruction limit exceeded")
+	ErrTimeout         = errors.New("timeout exceeded")
+)
+
+type limits struct {
+	maxSteps       uint64
+	maxThreadSteps uint64
+	timeout        time.Duration
+	pauseAt        uint64
+	steps          atomic.Uint64
+	timedOut       atomic.Bool
+}
+
+// SetStepLimit stops the machine once all of its threads together executed n
+// instructions, 0 means no limit.
+func (m *Machine) SetStepLimit(n uint64) {
+	m.limits.maxSteps = n
+}
+
+// SetThreadStepLimit stops any single thread after it executed n
+// instructions, 0 means no limit.
+func (m *Machine) SetThreadStepLimit(n uint64) {
+	m.limits.maxThreadSteps = n
+}
+
+// SetTimeout stops the machine when Run takes longer than d, 0 means no limit.
+func (m *Machine) SetTimeout(d time.Duration) {
+	m.limits.timeout = d
+}
+
+// Steps is the number of instructions executed by every thread so far.
+func (m *Machine) Steps() uint64 {
+	return m.limits.steps.Load()
+}
+
+func (m *Machine) startTimeout() func() {
+	if m.limits.timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(m.limits.timeout, func() {
+		m.limits.timedOut.Store(true)
+		m.interrupt()
+	})
+	return func() { timer.Stop() }
+}
+
+// checkLimits is called before every instruction and faults the thread once a
+// limit is reached.
+func (m *Machine) checkLimits(thread *Thread) {
+	thread.steps++
+	steps := m.limits.steps.Add(1)
+	if steps == m.limits.pauseAt {
+		m.Pause()
+	}
+
+	m.checkTimeout()
+	if m.limits.maxSteps > 0 && steps > m.limits.maxSteps {
+		m.faultErr(ErrStepLimit, "instruction limit of %d exceeded", m.limits.maxSteps)
+	}
+	if m.limits.maxThreadSteps > 0 && thread.steps > m.limits.maxThreadSteps {
+		m.faultErr(ErrThreadStepLimit, "thread instruction limit of %d exceeded", m.limits.maxThreadSteps)
+	}
+}
+
+// checkTimeout faults the running instruction once the timeout passed.
+func (m *Machine) checkTimeout() {
+	if m.limits.timedOut.Load() {
+		m.faultErr(ErrTimeout, "timeout of %s exceeded", m.limits.timeout)
+	}
+}
+
+func (m *Machine) faultErr(err error, format string, args ...interface{}) {
+	panic(&Fault{Reason: fmt.Sprintf(format, args...), Err: err})
+}
@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"errors"
+)
+
+// ErrPaused is returned by Run when the machine was paused, see Pause.
+var ErrPaused = errors.New("paused")
+
+// Pause makes every thread stop before its next instruction. Run returns
+// ErrPaused once all of them did, after which the machine can be saved with
+// Snapshot or carried on by calling Run again. Threads waiting for a mutex, a
+// condition variable, a channel or another thread try the syscall again when
+// the machine runs, host I/O like reading stdin is waited for. Pause can be
+// called from any goroutine.
+func (m *Machine) Pause() {
+	m.pauseMu.Lock()
+	if !m.paused.Load() {
+		m.paused.Store(true)
+		close(m.pauseCh)
+	}
+	m.pauseMu.Unlock()
+
+	m.wakeWaiters()
+}
+
+// PauseAfter pauses the machine once its threads executed n more
+// instructions, 0 means never.
+func (m *Machine) PauseAfter(n uint64) {
+	m.limits.pauseAt = 0
+	if n > 0 {
+		m.limits.pauseAt = m.limits.steps.Load() + n
+	}
+}
+
+// Paused reports whether the machine was paused and has not run since.
+func (m *Machine) Paused() bool {
+	return m.paused.Load()
+}
+
+// unpause is called by Run, it reports whether the machine was paused before.
+func (m *Machine) unpause() bool {
+	m.pauseMu.Lock()
+	defer m.pauseMu.Unlock()
+	if !m.paused.Load() {
+		return false
+	}
+	m.paused.Store(false)
+	m.pauseCh = make(chan struct{})
+	return true
+}
+
+// pausing reports whether thread has to stop for a pause. Functions the host
+// runs with Call are not paused, the syscall that called them would never
+// finish.
+func (m *Machine) pausing(thread *Thread) bool {
+	return thread.caller == nil && m.paused.Load() && !m.hasExited()
+}
+
+// suspended reports whether thread stopped for a pause instead of finishing.
+func (m *Machine) suspended(thread *Thread) bool {
+	return thread.isRunning && m.pausing(thread)
+}
+
+// pauseChan is closed when the machine is paused, it is nil for threads that
+// are not paused so waiting on it blocks forever.
+func (m *Machine) pauseChan(thread *Thread) chan struct{} {
+	if thread.caller != nil {
+		return nil
+	}
+	m.pauseMu.Lock()
+	defer m.pauseMu.Unlock()
+	return m.pauseCh
+}
+
+// resumeThreads starts the threads that were running when the machine was
+// paused again. The deterministic scheduler still has them.
+func (m *Machine) resumeThreads() {
+	if m.scheduler != nil {
+		return
+	}
+	for i := 1; ; i++ {
+		thread, ok := m.GetThread(i)
+		if !ok {
+			return
+		}
+		if !thread.isRunning || m.ThreadState(thread) != THREAD_RUNNING {
+			continue
+		}
+
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.RunThread(thread)
+		}()
+	}
+}
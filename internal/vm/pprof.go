@@ -0,0 +1,192 @@
+package vm
+
+import (
+	"compress/gzip"
+	"fishy/pkg/bytecode"
+	"io"
+)
+
+// WritePprof writes the profile in the gzipped protobuf format `go tool pprof`
+// reads, with instructions and syscall nanoseconds as sample values. Every
+// label becomes a function and every ip a location in it. Files and line
+// numbers are only known with debug info.
+func (p *Profile) WritePprof(w io.Writer) error {
+	strs := &stringTable{index: map[string]int64{"": 0}, strings: []string{""}}
+	out := &protoBuffer{}
+
+	valueType := func(field int, typ string, unit string) {
+		out.message(field, func(b *protoBuffer) {
+			b.int64(1, strs.add(typ))
+			b.int64(2, strs.add(unit))
+		})
+	}
+	valueType(1, "instructions", "count")
+	valueType(1, "syscall", "nanoseconds")
+
+	locations := make(map[uint64]uint64)
+	var order []uint64
+	for _, sample := range p.Samples {
+		ids := make([]uint64, len(sample.Stack))
+		for i, addr := range sample.Stack {
+			id, ok := locations[addr]
+			if !ok {
+				id = uint64(len(locations) + 1)
+				locations[addr] = id
+				order = append(order, addr)
+			}
+			ids[i] = id
+		}
+		out.message(2, func(b *protoBuffer) {
+			b.packed(1, ids)
+			b.packed(2, []uint64{sample.Instructions, uint64(sample.SyscallTime.Nanoseconds())})
+		})
+	}
+
+	out.message(3, func(b *protoBuffer) {
+		b.uint64(1, 1)
+		b.uint64(3, p.imageSize)
+		b.bool(7, true)
+	})
+
+	functions := make(map[string]uint64)
+	var names []string
+	for _, addr := range order {
+		name := p.labelName(addr)
+		function, ok := functions[name]
+		if !ok {
+			function = uint64(len(functions) + 1)
+			functions[name] = function
+			names = append(names, name)
+		}
+		line, _ := p.source(addr)
+		out.message(4, func(b *protoBuffer) {
+			b.uint64(1, locations[addr])
+			b.uint64(2, 1)
+			b.uint64(3, addr)
+			b.message(4, func(b *protoBuffer) {
+				b.uint64(1, function)
+				b.int64(2, int64(line.Line))
+			})
+		})
+	}
+	for _, name := range names {
+		var start bytecode.Position
+		if label, ok := p.labelAddr(name); ok {
+			start, _ = p.source(label)
+		}
+		out.message(5, func(b *protoBuffer) {
+			b.uint64(1, functions[name])
+			b.int64(2, strs.add(name))
+			b.int64(3, strs.add(name))
+			b.int64(4, strs.add(start.File))
+			b.int64(5, int64(start.Line))
+		})
+	}
+
+	valueType(11, "instructions", "count")
+	out.int64(12, 1)
+	out.int64(14, strs.add("instructions"))
+
+	// the string table goes last, everything above adds to it
+	for _, s := range strs.strings {
+		out.string(6, s)
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out.bytes); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (p *Profile) source(addr uint64) (bytecode.Position, bool) {
+	if p.debugInfo == nil {
+		return bytecode.Position{}, false
+	}
+	return p.debugInfo.Lookup(addr)
+}
+
+func (p *Profile) labelAddr(name string) (uint64, bool) {
+	for addr, label := range p.labels {
+		if label == name {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+type stringTable struct {
+	index   map[string]int64
+	strings []string
+}
+
+func (t *stringTable) add(s string) int64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := int64(len(t.strings))
+	t.index[s] = i
+	t.strings = append(t.strings, s)
+	return i
+}
+
+// protoBuffer encodes the few protobuf wire types profile.proto uses.
+type protoBuffer struct {
+	bytes []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.bytes = append(b.bytes, byte(x)|0x80)
+		x >>= 7
+	}
+	b.bytes = append(b.bytes, byte(x))
+}
+
+func (b *protoBuffer) tag(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint64, int64 and bool leave out zero values like proto3 does.
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.tag(field, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bool(field int, x bool) {
+	if x {
+		b.uint64(field, 1)
+	}
+}
+
+// string always writes s, the string table has to keep its empty first entry.
+func (b *protoBuffer) string(field int, s string) {
+	b.tag(field, 2)
+	b.varint(uint64(len(s)))
+	b.bytes = append(b.bytes, s...)
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	inner := &protoBuffer{}
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.tag(field, 2)
+	b.varint(uint64(len(inner.bytes)))
+	b.bytes = append(b.bytes, inner.bytes...)
+}
+
+func (b *protoBuffer) message(field int, fn func(*protoBuffer)) {
+	inner := &protoBuffer{}
+	fn(inner)
+	b.tag(field, 2)
+	b.varint(uint64(len(inner.bytes)))
+	b.bytes = append(b.bytes, inner.bytes...)
+}
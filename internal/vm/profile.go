@@ -0,0 +1,265 @@
+package vm
+
+import (
+	"fishy/pkg/bytecode"
+	"fishy/pkg/opcode"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// callNode is a function in the call tree of a thread, entered by the call
+// at site. Every thread builds its own tree from call and ret while it runs,
+// Profile merges them.
+type callNode struct {
+	site     uint64
+	parent   *callNode
+	children map[uint64]*callNode
+	costs    map[uint64]*ipCost
+}
+
+type ipCost struct {
+	instructions uint64
+	syscallTime  time.Duration
+}
+
+type threadProfile struct {
+	root    *callNode
+	current *callNode
+}
+
+func newCallNode(site uint64, parent *callNode) *callNode {
+	return &callNode{
+		site:     site,
+		parent:   parent,
+		children: make(map[uint64]*callNode),
+		costs:    make(map[uint64]*ipCost),
+	}
+}
+
+// child returns the function called at site, it is created on the first call.
+func (n *callNode) child(site uint64) *callNode {
+	child, ok := n.children[site]
+	if !ok {
+		child = newCallNode(site, n)
+		n.children[site] = child
+	}
+	return child
+}
+
+// EnableProfile counts the instructions every thread executes and the time
+// spent in syscalls, per ip and call stack. See Profile.
+func (m *Machine) EnableProfile() {
+	m.profiling = true
+}
+
+// profileBegin counts the instruction at ip, for syscalls it returns the time
+// they started.
+func (m *Machine) profileBegin(thread *Thread, ip uint64, op opcode.Opcode) time.Time {
+	if thread.profile == nil {
+		root := newCallNode(0, nil)
+		thread.profile = &threadProfile{root: root, current: root}
+	}
+
+	node := thread.profile.current
+	cost, ok := node.costs[ip]
+	if !ok {
+		cost = &ipCost{}
+		node.costs[ip] = cost
+	}
+	cost.instructions++
+
+	if op == opcode.SYSCALL {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+// profileEnd adds the time a syscall took and follows calls and returns.
+func (m *Machine) profileEnd(thread *Thread, ip uint64, op opcode.Opcode, start time.Time) {
+	state := thread.profile
+	switch op {
+	case opcode.SYSCALL:
+		state.current.costs[ip].syscallTime += time.Since(start)
+	case opcode.CALL_LIT, opcode.CALL_REG, opcode.CALL_AOF:
+		state.current = state.current.child(ip)
+	case opcode.RET:
+		// a ret without a call, e.g. after jumping to a pushed address,
+		// stays where it is
+		if state.current.parent != nil {
+			state.current = state.current.parent
+		}
+	}
+}
+
+// ProfileSample is the cost of one ip reached through one call stack.
+type ProfileSample struct {
+	// Stack starts with the ip, followed by the calls that led to it with
+	// the outermost last.
+	Stack        []uint64
+	Instructions uint64
+	SyscallTime  time.Duration
+}
+
+// LabelCost is a ProfileSample summed up by the label the ip belongs to.
+// Cumulative also counts everything the label called.
+type LabelCost struct {
+	Name         string
+	Addr         uint64
+	Instructions uint64
+	Cumulative   uint64
+	SyscallTime  time.Duration
+}
+
+// Profile is what EnableProfile collected. Every ip is attributed to the
+// nearest label at or before it.
+type Profile struct {
+	Samples []ProfileSample
+
+	imageSize uint64
+	labels    map[uint64]string
+	addrs     []uint64
+	debugInfo *bytecode.DebugInfo
+}
+
+// Profile merges the profiles of all threads, it has to be called after Run
+// returned.
+func (m *Machine) Profile() *Profile {
+	p := &Profile{
+		imageSize: m.imageSize,
+		labels:    m.labelNames(),
+		debugInfo: m.debugInfo,
+	}
+	for addr := range p.labels {
+		p.addrs = append(p.addrs, addr)
+	}
+	slices.Sort(p.addrs)
+
+	m.threadsMu.RLock()
+	defer m.threadsMu.RUnlock()
+	for i := 0; i < len(m.threads); i++ {
+		if state := m.threads[i].profile; state != nil {
+			p.addSamples(state.root, nil)
+		}
+	}
+	return p
+}
+
+func (p *Profile) addSamples(node *callNode, calls []uint64) {
+	if node.parent != nil {
+		calls = append([]uint64{node.site}, calls...)
+	}
+
+	ips := make([]uint64, 0, len(node.costs))
+	for ip := range node.costs {
+		ips = append(ips, ip)
+	}
+	slices.Sort(ips)
+	for _, ip := range ips {
+		cost := node.costs[ip]
+		p.Samples = append(p.Samples, ProfileSample{
+			Stack:        append([]uint64{ip}, calls...),
+			Instructions: cost.instructions,
+			SyscallTime:  cost.syscallTime,
+		})
+	}
+
+	sites := make([]uint64, 0, len(node.children))
+	for site := range node.children {
+		sites = append(sites, site)
+	}
+	slices.Sort(sites)
+	for _, site := range sites {
+		p.addSamples(node.children[site], calls)
+	}
+}
+
+// label returns the address of the label addr belongs to, ok is false if no
+// label comes before it.
+func (p *Profile) label(addr uint64) (uint64, bool) {
+	i := sort.Search(len(p.addrs), func(i int) bool { return p.addrs[i] > addr })
+	if i == 0 {
+		return 0, false
+	}
+	return p.addrs[i-1], true
+}
+
+func (p *Profile) labelName(addr uint64) string {
+	if label, ok := p.label(addr); ok {
+		return p.labels[label]
+	}
+	return "(no label)"
+}
+
+// Labels returns the cost of every label that executed something, the
+// hottest first.
+func (p *Profile) Labels() []LabelCost {
+	costs := make(map[string]*LabelCost)
+	get := func(addr uint64) *LabelCost {
+		name := p.labelName(addr)
+		cost, ok := costs[name]
+		if !ok {
+			label, _ := p.label(addr)
+			cost = &LabelCost{Name: name, Addr: label}
+			costs[name] = cost
+		}
+		return cost
+	}
+
+	for _, sample := range p.Samples {
+		cost := get(sample.Stack[0])
+		cost.Instructions += sample.Instructions
+		cost.SyscallTime += sample.SyscallTime
+
+		// recursive calls count once
+		seen := make(map[*LabelCost]bool)
+		for _, addr := range sample.Stack {
+			if cost := get(addr); !seen[cost] {
+				seen[cost] = true
+				cost.Cumulative += sample.Instructions
+			}
+		}
+	}
+
+	result := make([]LabelCost, 0, len(costs))
+	for _, cost := range costs {
+		if cost.Instructions > 0 {
+			result = append(result, *cost)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Instructions != result[j].Instructions {
+			return result[i].Instructions > result[j].Instructions
+		}
+		return result[i].Addr < result[j].Addr
+	})
+	return result
+}
+
+// WriteReport writes the labels as a table like `go tool pprof -top`.
+func (p *Profile) WriteReport(w io.Writer) error {
+	total := uint64(0)
+	for _, sample := range p.Samples {
+		total += sample.Instructions
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "flat\tflat%%\tcum\tcum%%\tsyscall\t %s\n", "label")
+	for _, cost := range p.Labels() {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\t %s (0x%04X)\n",
+			cost.Instructions, percent(cost.Instructions, total),
+			cost.Cumulative, percent(cost.Cumulative, total),
+			cost.SyscallTime.Round(time.Microsecond), cost.Name, cost.Addr)
+	}
+	return tw.Flush()
+}
+
+func percent(n uint64, total uint64) string {
+	if total == 0 {
+		return "0.00%"
+	}
+	return fmt.Sprintf("%.2f%%", float64(n)*100/float64(total))
+}
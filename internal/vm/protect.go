@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"fishy/pkg/bytecode"
+	"fishy/pkg/utils"
+	"fmt"
+	"math"
+)
+
+type Permission uint8
+
+const (
+	PERM_READ Permission = 1 << iota
+	PERM_WRITE
+	PERM_EXEC
+)
+
+func (p Permission) String() string {
+	perms := []byte("---")
+	if p&PERM_READ != 0 {
+		perms[0] = 'r'
+	}
+	if p&PERM_WRITE != 0 {
+		perms[1] = 'w'
+	}
+	if p&PERM_EXEC != 0 {
+		perms[2] = 'x'
+	}
+	return string(perms)
+}
+
+// ProtectionError is the Err of a fault caused by an access the memory region
+// does not allow, like writing to the text section or executing data.
+type ProtectionError struct {
+	Addr   uint64
+	Access Permission
+	Region string
+	Perm   Permission
+}
+
+func (e *ProtectionError) Error() string {
+	access := "read"
+	switch e.Access {
+	case PERM_WRITE:
+		access = "write"
+	case PERM_EXEC:
+		access = "execute"
+	}
+	return fmt.Sprintf("protection fault: %s at 0x%04X in %s (%s)", access, e.Addr, e.Region, e.Perm)
+}
+
+type region struct {
+	name  string
+	start uint64
+	end   uint64
+	perm  Permission
+}
+
+// setRegions splits memory into the sections of the program, the heap and the
+// stack follow them. Only text is executable and only data, bss, the heap and
+// the stacks are writable, the guards below the stacks and memory between
+// sections cannot be accessed.
+func (m *Machine) setRegions(file *bytecode.File) {
+	m.regions = nil
+	for _, kind := range []bytecode.SectionKind{bytecode.SECTION_TEXT, bytecode.SECTION_RODATA, bytecode.SECTION_DATA, bytecode.SECTION_BSS} {
+		section := file.Section(kind)
+		if section == nil || section.Size == 0 {
+			continue
+		}
+
+		perm := PERM_READ | PERM_WRITE
+		switch kind {
+		case bytecode.SECTION_TEXT:
+			perm = PERM_READ | PERM_EXEC
+		case bytecode.SECTION_RODATA:
+			perm = PERM_READ
+		}
+		m.regions = append(m.regions, region{kind.String(), section.Addr, section.Addr + section.Size, perm})
+	}
+}
+
+func (m *Machine) regionAt(addr uint64) (region, bool) {
+	for _, r := range m.regions {
+		if addr >= r.start && addr < r.end {
+			return r, true
+		}
+	}
+	if addr < m.imageSize {
+		// a gap between the sections of a handcrafted program
+		end := m.imageSize
+		for _, r := range m.regions {
+			if r.start > addr && r.start < end {
+				end = r.start
+			}
+		}
+		return region{"unmapped memory", addr, end, 0}, true
+	}
+
+	stack := m.mainThread
+	switch {
+	case addr >= m.imageSize && addr < m.heap.end:
+		if guard, ok := m.guardAt(addr); ok {
+			return guard, true
+		}
+		return region{"heap", m.imageSize, m.heap.end, PERM_READ | PERM_WRITE}, true
+	case addr >= m.heap.end && addr < stack.stackBase:
+		return region{"stack guard", m.heap.end, stack.stackBase, 0}, true
+	case addr >= stack.stackBase && addr < stack.stackTop:
+		return region{"stack", stack.stackBase, stack.stackTop, PERM_READ | PERM_WRITE}, true
+	}
+	return region{}, false
+}
+
+// allowed reports whether every byte of [addr, addr+size) may be accessed.
+// Addresses outside of memory are left to the bounds checks.
+func (m *Machine) allowed(addr uint64, size int, access Permission) (*ProtectionError, bool) {
+	end := addr + uint64(size)
+	for a := addr; a < end; {
+		r, ok := m.regionAt(a)
+		if !ok {
+			break
+		}
+		if r.perm&access == 0 {
+			return &ProtectionError{Addr: a, Access: access, Region: r.name, Perm: r.perm}, false
+		}
+		a = r.end
+	}
+	return nil, true
+}
+
+// checkAccess faults the thread if the access is not allowed.
+func (m *Machine) checkAccess(addr uint64, size int, access Permission) {
+	if err, ok := m.allowed(addr, size, access); !ok {
+		m.faultErr(err, "%s", err.Error())
+	}
+}
+
+// denyRead is the check used by syscalls that read guest memory, like a
+// buffer to write or a path. They fail with EFAULT instead of faulting, it
+// reports whether the read was denied.
+func (m *Machine) denyRead(thread *Thread, addr uint64, size uint64) bool {
+	if _, ok := m.allowed(addr, int(size), PERM_READ); ok {
+		return false
+	}
+	m.SetErrorCodeRegister(thread, EFAULT)
+	m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(math.MaxUint64))
+	return true
+}
+
+// denyWrite is the check used by syscalls that write to guest memory. Instead
+// of faulting they fail with EFAULT, it reports whether the write was denied.
+func (m *Machine) denyWrite(thread *Thread, addr uint64, size uint64) bool {
+	if _, ok := m.allowed(addr, int(size), PERM_WRITE); ok {
+		m.traceWrite(thread, addr, int(size))
+		return false
+	}
+	m.SetErrorCodeRegister(thread, EFAULT)
+	m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(math.MaxUint64))
+	return true
+}
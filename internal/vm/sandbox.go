@@ -0,0 +1,244 @@
+package vm
+
+import (
+	"encoding/json"
+	"fishy/pkg/utils"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Policy restricts what a sandboxed guest may do. Anything not listed is
+// denied and the syscall fails with EPERM instead of running.
+//
+//	{
+//	    "filesystem": ["./data", "/tmp/fishy"],
+//	    "network": ["127.0.0.1:8080", "*:9000"],
+//	    "syscalls": ["SYS_EXIT", "SYS_READ", "SYS_WRITE", 100]
+//	}
+//
+// Filesystem roots are relative to the directory of the policy file, network
+// entries are host:port pairs where either side may be "*", and syscalls are
+// given by name or by number.
+type Policy struct {
+	Filesystem []string        `json:"filesystem"`
+	Network    []string        `json:"network"`
+	Syscalls   []PolicySyscall `json:"syscalls"`
+}
+
+type PolicySyscall SyscallIndex
+
+func (p *PolicySyscall) UnmarshalJSON(b []byte) error {
+	var number int
+	if err := json.Unmarshal(b, &number); err == nil {
+		*p = PolicySyscall(number)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return fmt.Errorf("syscall must be a name or a number, got %s", string(b))
+	}
+	for index, syscallName := range syscallNames {
+		if strings.EqualFold(name, syscallName) {
+			*p = PolicySyscall(index)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown syscall %s", name)
+}
+
+// DefaultPolicy allows console I/O, conversions, the clock and threads but no
+// filesystem or network access.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Syscalls: []PolicySyscall{
+			PolicySyscall(SYS_EXIT),
+			PolicySyscall(SYS_READ),
+			PolicySyscall(SYS_WRITE),
+			PolicySyscall(SYS_CLOSE),
+			PolicySyscall(SYS_STRERR),
+			PolicySyscall(SYS_INT_TO_STR),
+			PolicySyscall(SYS_STR_TO_INT),
+			PolicySyscall(SYS_FLOAT_TO_STR),
+			PolicySyscall(SYS_STR_TO_FLOAT),
+			PolicySyscall(SYS_ALLOC),
+			PolicySyscall(SYS_FREE),
+			PolicySyscall(SYS_REALLOC),
+			PolicySyscall(SYS_CLOCK),
+			PolicySyscall(SYS_THREAD_SPAWN),
+			PolicySyscall(SYS_THREAD_START),
+			PolicySyscall(SYS_THREAD_STOP),
+			PolicySyscall(SYS_THREAD_JOIN),
+			PolicySyscall(SYS_MUTEX_CREATE),
+			PolicySyscall(SYS_MUTEX_LOCK),
+			PolicySyscall(SYS_MUTEX_UNLOCK),
+			PolicySyscall(SYS_COND_CREATE),
+			PolicySyscall(SYS_COND_WAIT),
+			PolicySyscall(SYS_COND_SIGNAL),
+			PolicySyscall(SYS_COND_BROADCAST),
+			PolicySyscall(SYS_THREAD_SELF),
+			PolicySyscall(SYS_THREAD_STATUS),
+			PolicySyscall(SYS_THREAD_DETACH),
+			PolicySyscall(SYS_THREAD_EXIT),
+			PolicySyscall(SYS_CHAN_CREATE),
+			PolicySyscall(SYS_CHAN_SEND),
+			PolicySyscall(SYS_CHAN_RECV),
+			PolicySyscall(SYS_CHAN_TRY_SEND),
+			PolicySyscall(SYS_CHAN_TRY_RECV),
+			PolicySyscall(SYS_CHAN_CLOSE),
+		},
+	}
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for i, root := range policy.Filesystem {
+		if !filepath.IsAbs(root) {
+			root = filepath.Join(dir, root)
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		policy.Filesystem[i] = abs
+	}
+
+	for _, entry := range policy.Network {
+		if _, _, err := net.SplitHostPort(entry); err != nil {
+			return nil, fmt.Errorf("invalid network entry %q: %w", entry, err)
+		}
+	}
+
+	return policy, nil
+}
+
+type sandbox struct {
+	policy *Policy
+	mu     sync.Mutex
+	// fds the guest opened itself, only those and stdio can be used
+	fds map[int]bool
+}
+
+// SetPolicy sandboxes the machine. It has to be called before Run.
+func (m *Machine) SetPolicy(policy *Policy) {
+	m.sandbox = &sandbox{
+		policy: policy,
+		fds:    map[int]bool{0: true, 1: true, 2: true},
+	}
+}
+
+func (m *Machine) deny(thread *Thread) {
+	n := -1
+	m.SetErrorCodeRegister(thread, EPERM)
+	m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+}
+
+func (m *Machine) allowSyscall(index SyscallIndex) bool {
+	if m.sandbox == nil {
+		return true
+	}
+	for _, allowed := range m.sandbox.policy.Syscalls {
+		if SyscallIndex(allowed) == index {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Machine) allowPath(path string) bool {
+	if m.sandbox == nil {
+		return true
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	// resolve symlinks so a link inside a root can not point outside of it
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	} else if resolved, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(resolved, filepath.Base(abs))
+	}
+
+	for _, root := range m.sandbox.policy.Filesystem {
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Machine) allowAddress(ip net.IP, port int) bool {
+	if m.sandbox == nil {
+		return true
+	}
+
+	for _, entry := range m.sandbox.policy.Network {
+		host, portStr, _ := net.SplitHostPort(entry)
+		if host != "*" && !net.ParseIP(host).Equal(ip) {
+			continue
+		}
+		if portStr != "*" && portStr != strconv.Itoa(port) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (m *Machine) allowFd(fd int) bool {
+	if m.sandbox == nil {
+		return true
+	}
+	m.sandbox.mu.Lock()
+	defer m.sandbox.mu.Unlock()
+	return m.sandbox.fds[fd]
+}
+
+// closeGuestStdio closes stdin, stdout or stderr for a sandboxed guest only,
+// they belong to the host which keeps using them. It reports whether fd was
+// one of them.
+func (m *Machine) closeGuestStdio(fd uint64) bool {
+	if m.sandbox == nil || fd > 2 {
+		return false
+	}
+	m.trackFd(int(fd), false)
+	return true
+}
+
+func (m *Machine) trackFd(fd int, open bool) {
+	if fd < 0 {
+		return
+	}
+	m.fds.track(fd, open)
+	if m.sandbox == nil {
+		return
+	}
+	m.sandbox.mu.Lock()
+	defer m.sandbox.mu.Unlock()
+	if open {
+		m.sandbox.fds[fd] = true
+	} else {
+		delete(m.sandbox.fds, fd)
+	}
+}
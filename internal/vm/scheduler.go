@@ -0,0 +1,164 @@
+package vm
+
+import (
+	"errors"
+	"math/rand"
+	"runtime"
+)
+
+var ErrDeadlock = errors.New("deadlock")
+
+// defaultQuantum is the most instructions a thread runs before the
+// deterministic scheduler switches to another one.
+const defaultQuantum = 64
+
+// scheduler runs every guest thread on the goroutine that called Run. Which
+// thread runs next and for how many instructions is drawn from a seeded
+// random source, so the same program, input and seed always interleave the
+// same way.
+//
+// Threads never wait on a lock in this mode. A syscall that would block
+// rewinds ip to itself and yields, so it is tried again the next time the
+// thread is picked.
+type scheduler struct {
+	rand     *rand.Rand
+	source   *countingSource
+	seed     int64
+	quantum  int
+	runnable []*Thread
+	// progress counts the time slices in which some thread got past an
+	// instruction or finished. When every runnable thread blocked since the
+	// last one nothing can change anymore.
+	progress uint64
+}
+
+// SetDeterministic makes Run schedule all threads itself instead of running
+// each of them on its own goroutine. It has to be called before Run.
+func (m *Machine) SetDeterministic(seed int64) {
+	m.scheduler = newScheduler(seed, 0)
+}
+
+// newScheduler returns a scheduler whose random source already handed out
+// draws numbers, like the one a snapshot was taken of.
+func newScheduler(seed int64, draws uint64) *scheduler {
+	source := &countingSource{Source: rand.NewSource(seed)}
+	for source.draws < draws {
+		source.Int63()
+	}
+	return &scheduler{
+		rand:    rand.New(source),
+		source:  source,
+		seed:    seed,
+		quantum: defaultQuantum,
+	}
+}
+
+// countingSource counts the numbers drawn from it, so a snapshot can record
+// where the sequence of the scheduler is.
+type countingSource struct {
+	rand.Source
+	draws uint64
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.Source.Int63()
+}
+
+// Deterministic reports whether SetDeterministic was called.
+func (m *Machine) Deterministic() bool {
+	return m.scheduler != nil
+}
+
+// start adds a thread started by SYS_THREAD_START to the threads the
+// scheduler picks from.
+func (s *scheduler) start(thread *Thread) {
+	for _, t := range s.runnable {
+		if t == thread {
+			return
+		}
+	}
+	s.runnable = append(s.runnable, thread)
+}
+
+func (s *scheduler) deadlocked() bool {
+	for _, thread := range s.runnable {
+		if !thread.blocked || thread.blockedAt != s.progress {
+			return false
+		}
+	}
+	return true
+}
+
+// runScheduled runs the threads in time slices until the main thread stops.
+// When the machine was paused the main thread is still runnable.
+func (m *Machine) runScheduled(resumed bool) error {
+	s := m.scheduler
+	if !resumed {
+		s.runnable = append([]*Thread{m.mainThread}, s.runnable...)
+	}
+
+	for m.mainThread.isRunning && !m.hasExited() && !m.paused.Load() {
+		if s.deadlocked() {
+			ip := m.getRegister(m.mainThread, regIP)
+			m.mainThread.fault = &Fault{
+				IP:     ip,
+				Opcode: -1,
+				Reason: "all threads are blocked",
+				Err:    ErrDeadlock,
+				Source: m.sourceAt(ip),
+			}
+			m.mainThread.isRunning = false
+			break
+		}
+
+		index := s.rand.Intn(len(s.runnable))
+		thread := s.runnable[index]
+		slice := 1 + s.rand.Intn(s.quantum)
+
+		thread.yielded = false
+		thread.blocked = false
+		steps := thread.steps
+		m.execute(thread, slice)
+
+		executed := thread.steps - steps
+		if thread.blocked {
+			executed--
+		}
+		if executed > 0 || !thread.isRunning {
+			s.progress++
+		}
+		thread.blockedAt = s.progress
+
+		if !thread.isRunning {
+			m.finish(thread)
+			s.runnable = append(s.runnable[:index], s.runnable[index+1:]...)
+		}
+	}
+
+	if m.suspended(m.mainThread) {
+		return nil
+	}
+	m.mainThread.isRunning = false
+	if m.mainThread.fault != nil {
+		return m.mainThread.fault
+	}
+	return nil
+}
+
+// block makes thread try the syscall it is executing again the next time it
+// gets scheduled, its registers have to be left untouched.
+func (m *Machine) block(thread *Thread) {
+	ip := m.getRegister(thread, regIP)
+	m.setRegister(thread, regIP, ip-2)
+	thread.blocked = true
+	thread.yielded = true
+}
+
+func (m *Machine) handleYield(thread *Thread) {
+	if m.scheduler != nil {
+		thread.yielded = true
+	} else {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,496 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/datatype"
+	"fishy/pkg/opcode"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A snapshot file holds a paused machine:
+//
+//	magic   [4]byte "FSNP"
+//	version uint16
+//	state   gzip compressed gob of snapshotState
+//
+// The program it was loaded from is part of the state, its sections give the
+// memory regions and its debug info the source positions after a restore.
+
+var SnapshotMagic = [4]byte{'F', 'S', 'N', 'P'}
+
+const SnapshotVersion = 1
+
+type snapshotState struct {
+	Program []byte
+	Memory  []byte
+	Symbols map[uint64]datatype.DataType
+	// Paused is false for a machine that never ran
+	Paused bool
+	Steps  uint64
+
+	Heap           snapshotHeap
+	RequestedHeap  uint64
+	RequestedStack uint64
+
+	Threads   []snapshotThread
+	Sync      snapshotSync
+	Scheduler *snapshotScheduler
+	// Fds the guest had open, they are invalid after a restore
+	Fds []int
+}
+
+type snapshotHeap struct {
+	Start  uint64
+	End    uint64
+	Blocks map[uint64]uint64
+	Free   []snapshotSpan
+}
+
+type snapshotSpan struct {
+	Addr uint64
+	Size uint64
+}
+
+type snapshotThread struct {
+	Registers []uint64
+	Running   bool
+	State     ThreadState
+	Steps     uint64
+	Fault     *snapshotFault
+	Stopped   bool
+	Detached  bool
+	ExitValue uint64
+
+	Stack     uint64
+	StackBase uint64
+	StackTop  uint64
+
+	Yielded   bool
+	Blocked   bool
+	BlockedAt uint64
+	// handles of the sync objects the thread is in the middle of, 0 for none
+	Waiting   uint64
+	Sending   uint64
+	Receiving uint64
+	Ticket    uint64
+}
+
+type snapshotFault struct {
+	IP     uint64
+	Opcode opcode.Opcode
+	Reason string
+	Err    string
+	Source *bytecode.Position
+}
+
+type snapshotSync struct {
+	Next uint64
+	// Mutexes maps a handle to the index of its owner, -1 if it is unlocked
+	Mutexes map[uint64]int
+	// Conds maps a handle to the indices of the threads in its queue
+	Conds map[uint64][]int
+	Chans map[uint64]snapshotChan
+}
+
+type snapshotChan struct {
+	Capacity   int
+	Values     []uint64
+	Closed     bool
+	Unbuffered bool
+	Received   uint64
+	Receivers  int
+}
+
+type snapshotScheduler struct {
+	Seed     int64
+	Draws    uint64
+	Quantum  int
+	Runnable []int
+	Progress uint64
+}
+
+// faultErrors are the errors of a fault that survive a snapshot, any other
+// one only keeps its message.
+var faultErrors = []error{ErrStepLimit, ErrThreadStepLimit, ErrTimeout, ErrDeadlock}
+
+// Snapshot writes the state of the machine to w: its memory, heap, threads,
+// mutexes, condition variables and channels. Only a machine that was paused
+// or has not run yet can be saved, see Pause and Restore. What the host added
+// like syscalls, limits or a policy is not part of it.
+func (m *Machine) Snapshot(w io.Writer) error {
+	if m.running.Load() {
+		return errors.New("cannot snapshot a running machine, pause it and wait for Run to return")
+	}
+	if m.hasExited() || (!m.paused.Load() && m.ThreadState(m.mainThread) != THREAD_NEW) {
+		return errors.New("only a paused machine or one that has not run yet can be saved")
+	}
+
+	state := m.snapshotState()
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := gob.NewEncoder(zw).Encode(state); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	header := make([]byte, 6)
+	copy(header, SnapshotMagic[:])
+	binary.BigEndian.PutUint16(header[4:], SnapshotVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+func (m *Machine) snapshotState() *snapshotState {
+	m.threadsMu.RLock()
+	threads := make([]*Thread, len(m.threads))
+	for index, thread := range m.threads {
+		threads[index] = thread
+	}
+	m.threadsMu.RUnlock()
+
+	indices := make(map[*Thread]int, len(threads))
+	for index, thread := range threads {
+		indices[thread] = index
+	}
+
+	state := &snapshotState{
+		Program:        m.program,
+		Memory:         m.memory,
+		Symbols:        m.symbolTable,
+		Paused:         m.paused.Load(),
+		Steps:          m.limits.steps.Load(),
+		RequestedHeap:  m.requestedHeap,
+		RequestedStack: m.requestedStack,
+	}
+
+	m.heap.mu.Lock()
+	state.Heap = snapshotHeap{Start: m.heap.start, End: m.heap.end, Blocks: m.heap.blocks}
+	for _, s := range m.heap.free {
+		state.Heap.Free = append(state.Heap.Free, snapshotSpan{s.addr, s.size})
+	}
+	m.heap.mu.Unlock()
+
+	s := m.sync
+	s.mu.Lock()
+	state.Sync = snapshotSync{
+		Next:    s.next,
+		Mutexes: make(map[uint64]int),
+		Conds:   make(map[uint64][]int),
+		Chans:   make(map[uint64]snapshotChan),
+	}
+	condHandles := make(map[*guestCond]uint64)
+	chanHandles := make(map[*guestChan]uint64)
+	for handle, mutex := range s.mutexes {
+		owner := -1
+		if mutex.owner != nil {
+			owner = indices[mutex.owner]
+		}
+		state.Sync.Mutexes[handle] = owner
+	}
+	for handle, cond := range s.conds {
+		queue := []int{}
+		for _, thread := range cond.queue {
+			queue = append(queue, indices[thread])
+		}
+		state.Sync.Conds[handle] = queue
+		condHandles[cond] = handle
+	}
+	for handle, c := range s.chans {
+		// nothing runs, the values are put back in the same order
+		var values []uint64
+		for len(c.values) > 0 {
+			values = append(values, <-c.values)
+		}
+		for _, value := range values {
+			c.values <- value
+		}
+		state.Sync.Chans[handle] = snapshotChan{
+			Capacity:   cap(c.values),
+			Values:     values,
+			Closed:     c.closed,
+			Unbuffered: c.unbuffered,
+			Received:   c.received,
+			Receivers:  c.receivers,
+		}
+		chanHandles[c] = handle
+	}
+	s.mu.Unlock()
+
+	for _, thread := range threads {
+		t := snapshotThread{
+			Registers: thread.registers,
+			Running:   thread.isRunning,
+			State:     m.ThreadState(thread),
+			Steps:     thread.steps,
+			Stopped:   thread.stop.Load(),
+			Detached:  thread.detached.Load(),
+			ExitValue: thread.exitValue,
+			Stack:     thread.stack,
+			StackBase: thread.stackBase,
+			StackTop:  thread.stackTop,
+			Yielded:   thread.yielded,
+			Blocked:   thread.blocked,
+			BlockedAt: thread.blockedAt,
+			Waiting:   condHandles[thread.waiting],
+			Sending:   chanHandles[thread.sending],
+			Receiving: chanHandles[thread.receiving],
+			Ticket:    thread.ticket,
+		}
+		if fault := thread.fault; fault != nil {
+			t.Fault = &snapshotFault{IP: fault.IP, Opcode: fault.Opcode, Reason: fault.Reason, Source: fault.Source}
+			if fault.Err != nil {
+				t.Fault.Err = fault.Err.Error()
+			}
+		}
+		state.Threads = append(state.Threads, t)
+	}
+
+	if sched := m.scheduler; sched != nil {
+		state.Scheduler = &snapshotScheduler{
+			Seed:     sched.seed,
+			Draws:    sched.source.draws,
+			Quantum:  sched.quantum,
+			Progress: sched.progress,
+		}
+		for _, thread := range sched.runnable {
+			state.Scheduler.Runnable = append(state.Scheduler.Runnable, indices[thread])
+		}
+	}
+
+	m.fds.mu.Lock()
+	for fd := range m.fds.open {
+		state.Fds = append(state.Fds, fd)
+	}
+	for fd := range m.fds.invalid {
+		state.Fds = append(state.Fds, fd)
+	}
+	m.fds.mu.Unlock()
+
+	return state
+}
+
+// Restore loads a machine saved with Snapshot. Run carries it on where it was
+// paused, with the scheduling mode it had. File descriptors and sockets the
+// guest had open are not reopened, using them fails with EBADF.
+func Restore(snapshot []byte) (*Machine, error) {
+	if len(snapshot) < 6 || !bytes.Equal(snapshot[:4], SnapshotMagic[:]) {
+		return nil, errors.New("not a Fishy snapshot file")
+	}
+	if version := binary.BigEndian.Uint16(snapshot[4:6]); version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d (expected %d)", version, SnapshotVersion)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(snapshot[6:]))
+	if err != nil {
+		return nil, fmt.Errorf("snapshot is corrupted: %w", err)
+	}
+	state := &snapshotState{}
+	if err := gob.NewDecoder(bufio.NewReader(zr)).Decode(state); err != nil {
+		return nil, fmt.Errorf("snapshot is corrupted: %w", err)
+	}
+	if len(state.Threads) == 0 {
+		return nil, errors.New("snapshot is corrupted: it has no threads")
+	}
+
+	m, err := New(state.Program, len(state.Memory), false)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.restore(state); err != nil {
+		return nil, fmt.Errorf("snapshot is corrupted: %w", err)
+	}
+	return m, nil
+}
+
+func (m *Machine) restore(state *snapshotState) error {
+	copy(m.memory, state.Memory)
+	m.symbolTable = state.Symbols
+	if m.symbolTable == nil {
+		m.symbolTable = make(map[uint64]datatype.DataType)
+	}
+	m.limits.steps.Store(state.Steps)
+	m.requestedHeap, m.requestedStack = state.RequestedHeap, state.RequestedStack
+
+	if state.Heap.Start < m.imageSize || state.Heap.Start > state.Heap.End || state.Heap.End > uint64(len(m.memory)) {
+		return fmt.Errorf("heap 0x%X-0x%X is outside of memory", state.Heap.Start, state.Heap.End)
+	}
+	m.heap = &heap{start: state.Heap.Start, end: state.Heap.End, blocks: state.Heap.Blocks}
+	if m.heap.blocks == nil {
+		m.heap.blocks = make(map[uint64]uint64)
+	}
+	for addr, size := range m.heap.blocks {
+		if !m.heap.contains(addr, size) {
+			return fmt.Errorf("heap block 0x%X of %d bytes is outside of the heap", addr, size)
+		}
+	}
+	for _, s := range state.Heap.Free {
+		if !m.heap.contains(s.Addr, s.Size) {
+			return fmt.Errorf("free heap span 0x%X of %d bytes is outside of the heap", s.Addr, s.Size)
+		}
+		m.heap.free = append(m.heap.free, span{s.Addr, s.Size})
+	}
+
+	threads := make([]*Thread, len(state.Threads))
+	m.threads = make(map[int]*Thread, len(threads))
+	m.guards = nil
+	for index, t := range state.Threads {
+		if len(t.Registers) != len(m.mainThread.registers) {
+			return fmt.Errorf("thread %d has %d registers", index, len(t.Registers))
+		}
+		if t.StackBase > t.StackTop || t.StackTop > uint64(len(m.memory)) || (t.Stack != 0 && !m.heap.contains(t.Stack, t.StackTop-t.Stack)) {
+			return fmt.Errorf("stack 0x%X-0x%X of thread %d is outside of memory", t.StackBase, t.StackTop, index)
+		}
+
+		thread := newThread()
+		copy(thread.registers, t.Registers)
+		thread.isRunning = t.Running
+		thread.state.Store(int32(t.State))
+		thread.steps = t.Steps
+		thread.exitValue = t.ExitValue
+		thread.detached.Store(t.Detached)
+		thread.stack, thread.stackBase, thread.stackTop = t.Stack, t.StackBase, t.StackTop
+		thread.yielded, thread.blocked, thread.blockedAt = t.Yielded, t.Blocked, t.BlockedAt
+		thread.ticket = t.Ticket
+		if t.Stopped {
+			thread.stop.Store(true)
+			close(thread.stopped)
+		}
+		if t.State == THREAD_FINISHED || t.State == THREAD_FAULTED {
+			close(thread.done)
+		}
+		if t.Fault != nil {
+			thread.fault = &Fault{Thread: index, IP: t.Fault.IP, Opcode: t.Fault.Opcode, Reason: t.Fault.Reason, Source: t.Fault.Source}
+			if t.Fault.Err != "" {
+				thread.fault.Err = errors.New(t.Fault.Err)
+				for _, err := range faultErrors {
+					if err.Error() == t.Fault.Err {
+						thread.fault.Err = err
+					}
+				}
+			}
+		}
+		if thread.stack != 0 {
+			m.guards = append(m.guards, region{"stack guard", thread.stack, thread.stackBase, 0})
+		}
+
+		threads[index] = thread
+		m.threads[index] = thread
+	}
+	m.mainThread = threads[0]
+
+	thread := func(index int) (*Thread, error) {
+		if index < 0 || index >= len(threads) {
+			return nil, fmt.Errorf("unknown thread %d", index)
+		}
+		return threads[index], nil
+	}
+
+	s := newSyncObjects()
+	s.next = state.Sync.Next
+	for handle, owner := range state.Sync.Mutexes {
+		mutex := &guestMutex{}
+		if owner >= 0 {
+			t, err := thread(owner)
+			if err != nil {
+				return err
+			}
+			mutex.owner = t
+		}
+		s.mutexes[handle] = mutex
+	}
+	for handle, queue := range state.Sync.Conds {
+		cond := &guestCond{waiters: sync.NewCond(&s.mu)}
+		for _, index := range queue {
+			t, err := thread(index)
+			if err != nil {
+				return err
+			}
+			cond.queue = append(cond.queue, t)
+		}
+		s.conds[handle] = cond
+	}
+	for handle, sc := range state.Sync.Chans {
+		if sc.Capacity < 0 || len(sc.Values) > sc.Capacity || sc.Capacity > maxChanCapacity {
+			return fmt.Errorf("channel %d holds %d values with a capacity of %d", handle, len(sc.Values), sc.Capacity)
+		}
+		c := &guestChan{
+			values:     make(chan uint64, sc.Capacity),
+			done:       make(chan struct{}),
+			closed:     sc.Closed,
+			unbuffered: sc.Unbuffered,
+			received:   sc.Received,
+			receivers:  sc.Receivers,
+		}
+		for _, value := range sc.Values {
+			c.values <- value
+		}
+		if c.closed {
+			close(c.done)
+		}
+		s.chans[handle] = c
+	}
+	m.sync = s
+
+	for index, t := range state.Threads {
+		if t.Waiting != 0 {
+			if threads[index].waiting = s.conds[t.Waiting]; threads[index].waiting == nil {
+				return fmt.Errorf("thread %d waits for unknown condition variable %d", index, t.Waiting)
+			}
+		}
+		if t.Sending != 0 {
+			if threads[index].sending = s.chans[t.Sending]; threads[index].sending == nil {
+				return fmt.Errorf("thread %d sends to unknown channel %d", index, t.Sending)
+			}
+		}
+		if t.Receiving != 0 {
+			if threads[index].receiving = s.chans[t.Receiving]; threads[index].receiving == nil {
+				return fmt.Errorf("thread %d receives from unknown channel %d", index, t.Receiving)
+			}
+		}
+	}
+
+	if sched := state.Scheduler; sched != nil {
+		if sched.Quantum <= 0 || sched.Quantum > defaultQuantum {
+			return fmt.Errorf("invalid scheduler quantum %d", sched.Quantum)
+		}
+		// every time slice draws twice and runs an instruction, except for
+		// the last one of a thread. Intn rarely draws again.
+		if maxDraws := 3 * (state.Steps + uint64(len(state.Threads))); sched.Draws > maxDraws {
+			return fmt.Errorf("scheduler made %d draws in %d steps", sched.Draws, state.Steps)
+		}
+		m.scheduler = newScheduler(sched.Seed, sched.Draws)
+		m.scheduler.quantum = sched.Quantum
+		m.scheduler.progress = sched.Progress
+		for _, index := range sched.Runnable {
+			t, err := thread(index)
+			if err != nil {
+				return err
+			}
+			m.scheduler.runnable = append(m.scheduler.runnable, t)
+		}
+	}
+
+	m.fds.invalid = make(map[int]bool)
+	for _, fd := range state.Fds {
+		m.fds.invalid[fd] = true
+	}
+
+	if state.Paused {
+		m.Pause()
+	}
+	return nil
+}
@@ -1,91 +1,22 @@
 package vm
 
 import (
-	"fishy/pkg/ast"
 	"fishy/pkg/datatype"
-	"fishy/pkg/log"
 	"fishy/pkg/utils"
-	"strconv"
 )
 
-func (m *Machine) handlePushLit(thread *Thread) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	pos := m.position(thread)
-	rdt := datatype.DataType(m.decodeNumber("byte", pos))
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	pos = m.position(thread)
-	lit := m.decodeNumberBytes(rdt.String(), pos)
-	m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(rdt.Size()))
-	m.stackPush(thread, lit)
+func (m *Machine) handlePushLit(thread *Thread, in *instruction) {
+	m.stackPush(thread, in.dt.MakeBytes(in.lit))
 }
 
-func (m *Machine) handlePushReg(thread *Thread) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	pos := m.position(thread)
-	rdt := datatype.DataType(m.decodeNumber("byte", pos))
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	pos = m.position(thread)
-	reg := m.decodeRegister(pos)
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	m.stackPush(thread, rdt.MakeBytes(m.getRegister(thread, reg)))
+func (m *Machine) handlePushReg(thread *Thread, in *instruction) {
+	m.stackPush(thread, in.dt.MakeBytes(m.getRegister(thread, in.reg0)))
 }
 
-func (m *Machine) handlePushAof(thread *Thread) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	pos := m.position(thread)
-	rdt := datatype.DataType(m.decodeNumber("byte", pos))
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	value := m.decodeValue(thread, rdt)
-	addr := 0
-	switch v := value.(type) {
-	case *ast.NumberLiteral:
-		num, _ := strconv.ParseUint(v.Value, 10, 64)
-		addr += int(num)
-	case *ast.Register:
-		num := m.getRegister(thread, v.Value)
-		addr += int(num)
-	case *ast.RegisterOffsetNumber:
-		num := m.getRegister(thread, v.Left.Value)
-		addr += int(num)
-	case *ast.RegisterOffsetRegister:
-		num := m.getRegister(thread, v.Left.Value)
-		addr += int(num)
-	case *ast.LabelOffsetNumber:
-		num, _ := strconv.ParseUint(v.Left.(*ast.NumberLiteral).Value, 10, 64)
-		addr += int(num)
-	case *ast.LabelOffsetRegister:
-		num, _ := strconv.ParseUint(v.Left.(*ast.NumberLiteral).Value, 10, 64)
-		addr += int(num)
-	default:
-		log.Fatal("unknown value to get address of", "value", value)
-	}
-
-	dt := datatype.DataType(datatype.UNSET)
-	if adt, ok := m.symbolTable[uint64(addr)]; ok {
-		dt = adt
-	}
-	if rdt != datatype.UNSET {
-		dt = rdt
-	}
-
-	switch v := value.(type) {
-	case *ast.RegisterOffsetNumber:
-		addr = applyOffset(addr, v.Operator, v.Right.Value)
-	case *ast.RegisterOffsetRegister:
-		addr = applyOffset(addr, v.Operator, strconv.Itoa(int(m.getRegister(thread, v.Right.Value))))
-	case *ast.LabelOffsetNumber:
-		addr = applyOffset(addr, v.Operator, v.Right.Value)
-	case *ast.LabelOffsetRegister:
-		addr = applyOffset(addr, v.Operator, strconv.Itoa(int(m.getRegister(thread, v.Right.Value))))
-	}
+func (m *Machine) handlePushAof(thread *Thread, in *instruction) {
+	addr, dt := m.address(thread, &in.mem, in.dt)
 
+	m.checkAccess(uint64(addr), dt.Size(), PERM_READ)
 	switch dt {
 	case datatype.BYTE:
 		m.stackPush(thread, []byte{m.memory[addr]})
@@ -98,72 +29,16 @@ func (m *Machine) handlePushAof(thread *Thread) {
 	}
 }
 
-func (m *Machine) handlePopReg(thread *Thread) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	pos := m.position(thread)
-	rdt := datatype.DataType(m.decodeNumber("byte", pos))
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	pos = m.position(thread)
-	reg := m.decodeRegister(pos)
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	m.setRegister(thread, reg, m.stackPop(thread, rdt))
+func (m *Machine) handlePopReg(thread *Thread, in *instruction) {
+	m.setRegister(thread, in.reg0, m.stackPop(thread, in.dt))
 }
 
-func (m *Machine) handlePopAof(thread *Thread) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
-	pos := m.position(thread)
-	rdt := datatype.DataType(m.decodeNumber("byte", pos))
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	value := m.decodeValue(thread, rdt)
-	addr := 0
-	switch v := value.(type) {
-	case *ast.NumberLiteral:
-		num, _ := strconv.ParseUint(v.Value, 10, 64)
-		addr += int(num)
-	case *ast.Register:
-		num := m.getRegister(thread, v.Value)
-		addr += int(num)
-	case *ast.RegisterOffsetNumber:
-		num := m.getRegister(thread, v.Left.Value)
-		addr += int(num)
-	case *ast.RegisterOffsetRegister:
-		num := m.getRegister(thread, v.Left.Value)
-		addr += int(num)
-	case *ast.LabelOffsetNumber:
-		num, _ := strconv.ParseUint(v.Left.(*ast.NumberLiteral).Value, 10, 64)
-		addr += int(num)
-	case *ast.LabelOffsetRegister:
-		num, _ := strconv.ParseUint(v.Left.(*ast.NumberLiteral).Value, 10, 64)
-		addr += int(num)
-	default:
-		log.Fatal("unknown value to get address of", "value", value)
-	}
-
-	dt := datatype.DataType(datatype.UNSET)
-	if adt, ok := m.symbolTable[uint64(addr)]; ok {
-		dt = adt
-	}
-	if rdt != datatype.UNSET {
-		dt = rdt
-	}
+func (m *Machine) handlePopAof(thread *Thread, in *instruction) {
+	addr, dt := m.address(thread, &in.mem, in.dt)
 
-	switch v := value.(type) {
-	case *ast.RegisterOffsetNumber:
-		addr = applyOffset(addr, v.Operator, v.Right.Value)
-	case *ast.RegisterOffsetRegister:
-		addr = applyOffset(addr, v.Operator, strconv.Itoa(int(m.getRegister(thread, v.Right.Value))))
-	case *ast.LabelOffsetNumber:
-		addr = applyOffset(addr, v.Operator, v.Right.Value)
-	case *ast.LabelOffsetRegister:
-		addr = applyOffset(addr, v.Operator, strconv.Itoa(int(m.getRegister(thread, v.Right.Value))))
-	}
-
-	bytes := m.stackPopBytes(thread, rdt)
+	m.checkAccess(uint64(addr), dt.Size(), PERM_WRITE)
+	bytes := m.stackPopBytes(thread, in.dt)
+	m.traceWrite(thread, uint64(addr), dt.Size())
 
 	switch dt {
 	case datatype.BYTE:
@@ -176,3 +51,78 @@ func (m *Machine) handlePopAof(thread *Thread) {
 		copy(m.memory[addr:addr+dt.Size()], bytes[:])
 	}
 }
+
+// stackGuardSize is the number of inaccessible bytes below every stack, so an
+// overflow faults instead of running into the heap or another stack.
+const stackGuardSize = 256
+
+// defaultThreadStackSize is used when SYS_THREAD_SPAWN is not given a size.
+const defaultThreadStackSize = 16 * 1024
+
+// allocStack gives child a stack of size bytes from the heap. The block starts
+// with a guard and is returned by releaseStack when the child ends.
+func (m *Machine) allocStack(thread *Thread, child *Thread, size uint64) ErrorCode {
+	if !m.heap.fits(size) {
+		return ENOMEM
+	}
+	addr, code := m.heapAlloc(thread, stackGuardSize+size)
+	if code != 0 {
+		return code
+	}
+
+	child.stack = addr
+	child.stackBase = addr + stackGuardSize
+	child.stackTop = child.stackBase + alignUp(size)
+
+	m.stacksMu.Lock()
+	m.guards = append(m.guards, region{"stack guard", addr, child.stackBase, 0})
+	m.stacksMu.Unlock()
+
+	m.setRegister(child, regSP, child.stackTop)
+	m.setRegister(child, utils.RegisterToIndex("fp"), child.stackTop)
+	return 0
+}
+
+func (m *Machine) releaseStack(thread *Thread) {
+	if thread.stack == 0 {
+		return
+	}
+
+	m.stacksMu.Lock()
+	for i, guard := range m.guards {
+		if guard.start == thread.stack {
+			m.guards = append(m.guards[:i], m.guards[i+1:]...)
+			break
+		}
+	}
+	m.stacksMu.Unlock()
+
+	m.heap.mu.Lock()
+	m.heap.release(thread.stack)
+	m.heap.mu.Unlock()
+	thread.stack = 0
+}
+
+// isStack reports whether addr is a heap block holding a thread stack, the
+// guest must not free those.
+func (m *Machine) isStack(addr uint64) bool {
+	m.stacksMu.RLock()
+	defer m.stacksMu.RUnlock()
+	for _, guard := range m.guards {
+		if guard.start == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Machine) guardAt(addr uint64) (region, bool) {
+	m.stacksMu.RLock()
+	defer m.stacksMu.RUnlock()
+	for _, guard := range m.guards {
+		if addr >= guard.start && addr < guard.end {
+			return guard, true
+		}
+	}
+	return region{}, false
+}
@@ -2,19 +2,36 @@ package vm
 
 import (
 	"fishy/pkg/datatype"
+	"fishy/pkg/opcode"
 	"fishy/pkg/utils"
 )
 
-func (m *Machine) handleCallLit(thread *Thread) {
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
+// handleCall pushes the address of the next instruction and jumps to the
+// function. Function pointers in memory are qwords unless the instruction
+// says otherwise, like an entry of a dispatch table.
+func (m *Machine) handleCall(thread *Thread, in *instruction) {
+	switch in.op {
+	case opcode.CALL_LIT:
+		m.call(thread, in.lit)
+	case opcode.CALL_REG:
+		m.call(thread, m.getRegister(thread, in.reg0))
+	case opcode.CALL_AOF:
+		dt := in.dt
+		if dt == datatype.UNSET {
+			dt = datatype.QWORD
+		}
+		functionAddress, _ := m.readAof(thread, in, dt)
+		m.call(thread, functionAddress)
+	}
+}
 
-	functionAddress := m.readLiteral(thread, datatype.QWORD)
-	bytes := utils.Bytes8(m.getRegister(thread, utils.RegisterToIndex("ip")))
+func (m *Machine) call(thread *Thread, functionAddress uint64) {
+	bytes := utils.Bytes8(m.getRegister(thread, regIP))
 	m.stackPush(thread, bytes)
-	m.setRegister(thread, utils.RegisterToIndex("ip"), functionAddress)
+	m.setRegister(thread, regIP, functionAddress)
 }
 
 func (m *Machine) handleRet(thread *Thread) {
 	returnAddress := m.stackPop(thread, datatype.QWORD)
-	m.setRegister(thread, utils.RegisterToIndex("ip"), returnAddress)
+	m.setRegister(thread, regIP, returnAddress)
 }
@@ -0,0 +1,213 @@
+package vm
+
+import (
+	"sync"
+)
+
+// Guest mutexes, condition variables and channels are referred to by handles. Their state
+// is guarded by a single lock that every blocked thread waits on, so stopping
+// the machine can wake all of them at once.
+type syncObjects struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	mutexes map[uint64]*guestMutex
+	conds   map[uint64]*guestCond
+	chans   map[uint64]*guestChan
+	next    uint64
+}
+
+type guestMutex struct {
+	owner *Thread
+}
+
+type guestCond struct {
+	waiters *sync.Cond
+	// queue holds the waiting threads when the deterministic scheduler runs
+	// them, signals wake them in the order they started to wait.
+	queue []*Thread
+}
+
+func newSyncObjects() *syncObjects {
+	s := &syncObjects{
+		mutexes: make(map[uint64]*guestMutex),
+		conds:   make(map[uint64]*guestCond),
+		chans:   make(map[uint64]*guestChan),
+		next:    1,
+	}
+	s.changed = sync.NewCond(&s.mu)
+	return s
+}
+
+// stopped reports whether blocked threads should give up waiting.
+func (m *Machine) stopped() bool {
+	return m.hasExited() || m.limits.timedOut.Load()
+}
+
+// interrupt is called when the machine stops, threads blocked in a syscall
+// fail it with EINTR.
+func (m *Machine) interrupt() {
+	m.quitOnce.Do(func() { close(m.quit) })
+	m.wakeWaiters()
+}
+
+// wakeWaiters is called when the machine or a thread stops so threads blocked
+// on a mutex or a condition variable notice it.
+func (m *Machine) wakeWaiters() {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changed.Broadcast()
+	for _, cond := range s.conds {
+		cond.waiters.Broadcast()
+	}
+}
+
+func (m *Machine) mutexCreate() uint64 {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handle := s.next
+	s.next++
+	s.mutexes[handle] = &guestMutex{}
+	return handle
+}
+
+func (m *Machine) mutexLock(thread *Thread, handle uint64) ErrorCode {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mutex, ok := s.mutexes[handle]
+	if !ok {
+		return EINVAL
+	}
+	if mutex.owner == thread {
+		return EDEADLK
+	}
+	return m.acquire(thread, mutex)
+}
+
+// acquire waits for the mutex to be released, s.mu has to be held.
+func (m *Machine) acquire(thread *Thread, mutex *guestMutex) ErrorCode {
+	for mutex.owner != nil {
+		if m.scheduler != nil || m.pausing(thread) {
+			m.block(thread)
+			return 0
+		}
+		if m.stopped() || thread.stop.Load() {
+			return EINTR
+		}
+		m.sync.changed.Wait()
+	}
+	mutex.owner = thread
+	return 0
+}
+
+func (m *Machine) mutexUnlock(thread *Thread, handle uint64) ErrorCode {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mutex, ok := s.mutexes[handle]
+	if !ok {
+		return EINVAL
+	}
+	if mutex.owner != thread {
+		return EPERM
+	}
+	mutex.owner = nil
+	s.changed.Broadcast()
+	return 0
+}
+
+func (m *Machine) condCreate() uint64 {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handle := s.next
+	s.next++
+	s.conds[handle] = &guestCond{waiters: sync.NewCond(&s.mu)}
+	return handle
+}
+
+// condWait releases the mutex, waits for a signal and takes the mutex again.
+// Like pthread_cond_wait it may return without a signal, so the guest has to
+// check its condition in a loop.
+func (m *Machine) condWait(thread *Thread, condHandle uint64, mutexHandle uint64) ErrorCode {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cond, ok := s.conds[condHandle]
+	if !ok {
+		return EINVAL
+	}
+	mutex, ok := s.mutexes[mutexHandle]
+	if !ok {
+		return EINVAL
+	}
+	if thread.waiting == cond {
+		return m.resumeWait(thread, cond, mutex)
+	}
+	if mutex.owner != thread {
+		return EPERM
+	}
+
+	mutex.owner = nil
+	s.changed.Broadcast()
+	if m.scheduler != nil {
+		cond.queue = append(cond.queue, thread)
+		thread.waiting = cond
+		m.block(thread)
+		return 0
+	}
+	if !m.stopped() && !thread.stop.Load() && !m.pausing(thread) {
+		cond.waiters.Wait()
+	}
+	if m.pausing(thread) {
+		// the retry wakes up without a signal once it has the mutex again
+		thread.waiting = cond
+		m.block(thread)
+		return 0
+	}
+	return m.acquire(thread, mutex)
+}
+
+// resumeWait is the retry of a blocked condWait under the deterministic
+// scheduler or after a pause. The thread keeps blocking until it was
+// signaled and got the mutex back.
+func (m *Machine) resumeWait(thread *Thread, cond *guestCond, mutex *guestMutex) ErrorCode {
+	for _, waiter := range cond.queue {
+		if waiter == thread {
+			m.block(thread)
+			return 0
+		}
+	}
+
+	code := m.acquire(thread, mutex)
+	if !thread.blocked {
+		thread.waiting = nil
+	}
+	return code
+}
+
+func (m *Machine) condSignal(handle uint64, broadcast bool) ErrorCode {
+	s := m.sync
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cond, ok := s.conds[handle]
+	if !ok {
+		return EINVAL
+	}
+	if broadcast {
+		cond.waiters.Broadcast()
+		cond.queue = nil
+	} else {
+		cond.waiters.Signal()
+		if len(cond.queue) > 0 {
+			cond.queue = cond.queue[1:]
+		}
+	}
+	return 0
+}
@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fishy/pkg/utils"
+	"fmt"
+	"math"
 	"net"
-	"os"
 	"strconv"
 	"syscall"
 	"time"
@@ -27,6 +28,59 @@ type SyscallIndex int
 
 type SyscallFunction func(m *Machine, thread *Thread)
 
+var syscallNames = map[SyscallIndex]string{
+	SYS_EXIT:            "SYS_EXIT",
+	SYS_OPEN:            "SYS_OPEN",
+	SYS_READ:            "SYS_READ",
+	SYS_WRITE:           "SYS_WRITE",
+	SYS_CLOSE:           "SYS_CLOSE",
+	SYS_STRERR:          "SYS_STRERR",
+	SYS_INT_TO_STR:      "SYS_INT_TO_STR",
+	SYS_STR_TO_INT:      "SYS_STR_TO_INT",
+	SYS_CLOCK:           "SYS_CLOCK",
+	SYS_NET_LISTEN_TCP:  "SYS_NET_LISTEN_TCP",
+	SYS_NET_CONNECT_TCP: "SYS_NET_CONNECT_TCP",
+	SYS_NET_ACCEPT:      "SYS_NET_ACCEPT",
+	SYS_NET_GETPEERNAME: "SYS_NET_GETPEERNAME",
+	SYS_NET_IP_TO_STR:   "SYS_NET_IP_TO_STR",
+	SYS_THREAD_SPAWN:    "SYS_THREAD_SPAWN",
+	SYS_THREAD_START:    "SYS_THREAD_START",
+	SYS_THREAD_STOP:     "SYS_THREAD_STOP",
+	SYS_THREAD_JOIN:     "SYS_THREAD_JOIN",
+
+	SYS_FLOAT_TO_STR: "SYS_FLOAT_TO_STR",
+	SYS_STR_TO_FLOAT: "SYS_STR_TO_FLOAT",
+
+	SYS_ALLOC:   "SYS_ALLOC",
+	SYS_FREE:    "SYS_FREE",
+	SYS_REALLOC: "SYS_REALLOC",
+
+	SYS_MUTEX_CREATE:   "SYS_MUTEX_CREATE",
+	SYS_MUTEX_LOCK:     "SYS_MUTEX_LOCK",
+	SYS_MUTEX_UNLOCK:   "SYS_MUTEX_UNLOCK",
+	SYS_COND_CREATE:    "SYS_COND_CREATE",
+	SYS_COND_WAIT:      "SYS_COND_WAIT",
+	SYS_COND_SIGNAL:    "SYS_COND_SIGNAL",
+	SYS_COND_BROADCAST: "SYS_COND_BROADCAST",
+	SYS_THREAD_SELF:    "SYS_THREAD_SELF",
+	SYS_THREAD_STATUS:  "SYS_THREAD_STATUS",
+	SYS_THREAD_DETACH:  "SYS_THREAD_DETACH",
+	SYS_THREAD_EXIT:    "SYS_THREAD_EXIT",
+	SYS_CHAN_CREATE:    "SYS_CHAN_CREATE",
+	SYS_CHAN_SEND:      "SYS_CHAN_SEND",
+	SYS_CHAN_RECV:      "SYS_CHAN_RECV",
+	SYS_CHAN_TRY_SEND:  "SYS_CHAN_TRY_SEND",
+	SYS_CHAN_TRY_RECV:  "SYS_CHAN_TRY_RECV",
+	SYS_CHAN_CLOSE:     "SYS_CHAN_CLOSE",
+}
+
+func (s SyscallIndex) String() string {
+	if name, ok := syscallNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("SYS_%d", int(s))
+}
+
 const (
 	SYS_EXIT SyscallIndex = iota + 1
 	SYS_OPEN
@@ -48,13 +102,42 @@ const (
 	SYS_THREAD_START
 	SYS_THREAD_STOP
 	SYS_THREAD_JOIN
+
+	SYS_FLOAT_TO_STR
+	SYS_STR_TO_FLOAT
+
+	SYS_ALLOC
+	SYS_FREE
+	SYS_REALLOC
+
+	SYS_MUTEX_CREATE
+	SYS_MUTEX_LOCK
+	SYS_MUTEX_UNLOCK
+	SYS_COND_CREATE
+	SYS_COND_WAIT
+	SYS_COND_SIGNAL
+	SYS_COND_BROADCAST
+
+	SYS_THREAD_SELF
+	SYS_THREAD_STATUS
+	SYS_THREAD_DETACH
+	SYS_THREAD_EXIT
+
+	SYS_CHAN_CREATE
+	SYS_CHAN_SEND
+	SYS_CHAN_RECV
+	SYS_CHAN_TRY_SEND
+	SYS_CHAN_TRY_RECV
+	SYS_CHAN_CLOSE
 )
 
-func (m *Machine) handleSyscall(thread *Thread) {
-	var Syscalls = map[SyscallIndex]SyscallFunction{
+// builtinSyscalls returns a fresh table of the syscalls every machine starts
+// with, hosts can add to or replace them with RegisterSyscall.
+func builtinSyscalls() map[SyscallIndex]SyscallFunction {
+	return map[SyscallIndex]SyscallFunction{
 		SYS_EXIT: func(m *Machine, thread *Thread) {
 			status := m.getRegister(thread, utils.RegisterToIndex("x0"))
-			os.Exit(int(status))
+			m.Exit(int(status))
 		},
 		SYS_OPEN: func(m *Machine, thread *Thread) {
 			addr := m.getRegister(thread, utils.RegisterToIndex("x0"))
@@ -75,12 +158,21 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			if m.denyRead(thread, addr, length) {
+				return
+			}
+
 			path := m.memory[addr : addr+length]
+			if !m.allowPath(string(path)) {
+				m.deny(thread)
+				return
+			}
 
 			fd, err := syscall.Open(string(path), int(mode), uint32(perm))
 			if err != nil {
 				m.SetErrorCodeRegister(thread, MatchString(err.Error()))
 			}
+			m.trackFd(fd, true)
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(fd))
 		},
@@ -89,8 +181,20 @@ func (m *Machine) handleSyscall(thread *Thread) {
 			addr := m.getRegister(thread, utils.RegisterToIndex("x1"))
 			length := m.getRegister(thread, utils.RegisterToIndex("x2"))
 
+			if m.invalidFd(thread, fd) {
+				return
+			}
+			if !m.allowFd(int(fd)) {
+				m.deny(thread)
+				return
+			}
+
+			if m.denyWrite(thread, addr, length) {
+				return
+			}
+
 			buffer := make([]byte, length)
-			n, err := syscall.Read(int(fd), buffer)
+			n, err := m.readFd(int(fd), buffer)
 			if err != nil {
 				m.SetErrorCodeRegister(thread, MatchString(err.Error()))
 				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
@@ -124,9 +228,21 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			if m.invalidFd(thread, fd) {
+				return
+			}
+			if !m.allowFd(int(fd)) {
+				m.deny(thread)
+				return
+			}
+
+			if m.denyRead(thread, addr, length) {
+				return
+			}
+
 			buffer := m.memory[start:end]
 
-			n, err := syscall.Write(int(fd), buffer)
+			n, err := m.writeFd(int(fd), buffer)
 			if err != nil {
 				m.SetErrorCodeRegister(thread, MatchString(err.Error()))
 			}
@@ -135,16 +251,29 @@ func (m *Machine) handleSyscall(thread *Thread) {
 		},
 		SYS_CLOSE: func(m *Machine, thread *Thread) {
 			fd := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			if m.invalidFd(thread, fd) {
+				return
+			}
+			if !m.allowFd(int(fd)) {
+				m.deny(thread)
+				return
+			}
+			if m.closeGuestStdio(fd) {
+				m.setRegister(thread, utils.RegisterToIndex("x0"), 0)
+				return
+			}
+
 			err := syscall.Close(int(fd))
 			n := 0
 			if err != nil {
 				n = -1
 				m.SetErrorCodeRegister(thread, MatchString(err.Error()))
 			}
+			m.trackFd(int(fd), false)
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
 		},
 		SYS_STRERR: func(m *Machine, thread *Thread) {
-			er := m.getRegister(thread, utils.RegisterToIndex("er"))
+			er := m.getRegister(thread, regER)
 			addr := m.getRegister(thread, utils.RegisterToIndex("x0"))
 			length := m.getRegister(thread, utils.RegisterToIndex("x1"))
 
@@ -164,28 +293,40 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			if m.denyWrite(thread, addr, length) {
+				return
+			}
+
 			copy(m.memory[addr:addr+length], message[:])
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(len(message)))
 		},
 		SYS_THREAD_SPAWN: func(m *Machine, thread *Thread) {
 			startAddr := m.getRegister(thread, utils.RegisterToIndex("x0"))
-			stackOffset := m.getRegister(thread, utils.RegisterToIndex("x1"))
+			stackSize := m.getRegister(thread, utils.RegisterToIndex("x1"))
+			argument := m.getRegister(thread, utils.RegisterToIndex("x2"))
+			if stackSize == 0 {
+				stackSize = defaultThreadStackSize
+			}
 
 			n := -1
-			currentPos := m.getRegister(m.mainThread, utils.RegisterToIndex("sp"))
+			child := newThread()
+			if code := m.allocStack(thread, child, stackSize); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
 
-			newThread := m.CreateThread()
-			threadIndex, ok := m.GetThreadIndex(newThread)
+			m.addThread(child)
+			threadIndex, ok := m.GetThreadIndex(child)
 			if !ok {
 				m.SetErrorCodeRegister(thread, MatchString("failed to spawn thread"))
 				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
 				return
 			}
 
-			m.setRegister(newThread, utils.RegisterToIndex("sp"), currentPos-stackOffset)
-			m.setRegister(newThread, utils.RegisterToIndex("fp"), currentPos-stackOffset)
-			m.setRegister(newThread, utils.RegisterToIndex("ip"), startAddr)
+			m.setRegister(child, regIP, startAddr)
+			m.setRegister(child, utils.RegisterToIndex("x0"), argument)
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(threadIndex))
 		},
@@ -193,17 +334,16 @@ func (m *Machine) handleSyscall(thread *Thread) {
 			threadIndex := m.getRegister(thread, utils.RegisterToIndex("x0"))
 
 			n := -1
-			if workingThread, ok := m.threads[int(threadIndex)]; ok {
-				m.wg.Add(1)
-				go func() {
-					m.RunThread(workingThread)
-					defer func() {
-						m.wg.Done()
-					}()
-				}()
-			} else {
+			workingThread, ok := m.GetThread(int(threadIndex))
+			if !ok {
 				m.SetErrorCodeRegister(thread, MatchString("failed to get thread"))
 				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+			if code := m.threadStart(workingThread); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
 			}
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
@@ -212,24 +352,102 @@ func (m *Machine) handleSyscall(thread *Thread) {
 			threadIndex := m.getRegister(thread, utils.RegisterToIndex("x0"))
 
 			n := -1
-			if workingThread, ok := m.threads[int(threadIndex)]; ok {
-				workingThread.isRunning = false
-			} else {
+			workingThread, ok := m.GetThread(int(threadIndex))
+			if !ok {
 				m.SetErrorCodeRegister(thread, MatchString("failed to get thread"))
 				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
 			}
 
+			m.stopThread(workingThread)
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
 		},
 		SYS_THREAD_JOIN: func(m *Machine, thread *Thread) {
 			threadIndex := m.getRegister(thread, utils.RegisterToIndex("x0"))
 
 			n := -1
-			if workingThread, ok := m.threads[int(threadIndex)]; ok {
-				<-workingThread.done
-			} else {
+			workingThread, ok := m.GetThread(int(threadIndex))
+			if !ok {
+				m.SetErrorCodeRegister(thread, MatchString("failed to get thread"))
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			value, code := m.threadJoin(thread, workingThread)
+			if code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+			if thread.blocked {
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), value)
+		},
+		SYS_THREAD_SELF: func(m *Machine, thread *Thread) {
+			threadIndex, _ := m.GetThreadIndex(thread)
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(threadIndex))
+		},
+		SYS_THREAD_STATUS: func(m *Machine, thread *Thread) {
+			threadIndex := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			workingThread, ok := m.GetThread(int(threadIndex))
+			if !ok {
 				m.SetErrorCodeRegister(thread, MatchString("failed to get thread"))
 				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(m.ThreadState(workingThread)))
+		},
+		SYS_THREAD_DETACH: func(m *Machine, thread *Thread) {
+			threadIndex := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			workingThread, ok := m.GetThread(int(threadIndex))
+			if !ok {
+				m.SetErrorCodeRegister(thread, MatchString("failed to get thread"))
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+			if code := m.threadDetach(workingThread); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_THREAD_EXIT: func(m *Machine, thread *Thread) {
+			// x0 already holds the exit value
+			m.stopThread(thread)
+		},
+		SYS_CHAN_CREATE: func(m *Machine, thread *Thread) {
+			capacity := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if capacity > maxChanCapacity {
+				m.SetErrorCodeRegister(thread, EINVAL)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), m.chanCreate(capacity))
+		},
+		SYS_CHAN_SEND:     chanSendSyscall(false),
+		SYS_CHAN_RECV:     chanRecvSyscall(false),
+		SYS_CHAN_TRY_SEND: chanSendSyscall(true),
+		SYS_CHAN_TRY_RECV: chanRecvSyscall(true),
+		SYS_CHAN_CLOSE: func(m *Machine, thread *Thread) {
+			handle := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if code := m.chanClose(handle); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
 			}
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
@@ -254,6 +472,10 @@ func (m *Machine) handleSyscall(thread *Thread) {
 			}
 
 			str := strconv.Itoa(int(number))
+			if m.denyWrite(thread, addr, length) {
+				return
+			}
+
 			copy(m.memory[addr:addr+length], str[:])
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(len(str)))
@@ -278,6 +500,10 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			if m.denyRead(thread, numberAddr, numberLength) {
+				return
+			}
+
 			numberBuffer := string(m.memory[numberAddr : numberAddr+numberLength])
 			number, err := strconv.ParseUint(numberBuffer, 10, 64)
 			if err != nil {
@@ -288,16 +514,199 @@ func (m *Machine) handleSyscall(thread *Thread) {
 
 			numberBytes := utils.Bytes8(number)
 
+			if m.denyWrite(thread, returnAddr, 8) {
+				return
+			}
+
+			copy(m.memory[returnAddr:returnAddr+8], numberBytes[:])
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_FLOAT_TO_STR: func(m *Machine, thread *Thread) {
+			number := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			addr := m.getRegister(thread, utils.RegisterToIndex("x1"))
+			length := m.getRegister(thread, utils.RegisterToIndex("x2"))
+
+			n := -1
+
+			if addr >= uint64(len(m.memory)) || addr+length > uint64(len(m.memory)) {
+				m.SetErrorCodeRegister(thread, EADDROUTOFBOUNDS)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			if length == 0 {
+				m.SetErrorCodeRegister(thread, EINVALIDLENGTH)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			str := strconv.FormatFloat(math.Float64frombits(number), 'g', -1, 64)
+			if m.denyWrite(thread, addr, length) {
+				return
+			}
+
+			copy(m.memory[addr:addr+length], str[:])
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(len(str)))
+		},
+		SYS_STR_TO_FLOAT: func(m *Machine, thread *Thread) {
+			numberAddr := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			numberLength := m.getRegister(thread, utils.RegisterToIndex("x1"))
+			returnAddr := m.getRegister(thread, utils.RegisterToIndex("x2"))
+
+			n := -1
+
+			if numberAddr >= uint64(len(m.memory)) || numberAddr+numberLength > uint64(len(m.memory)) ||
+				returnAddr >= uint64(len(m.memory)) || returnAddr+8 > uint64(len(m.memory)) {
+				m.SetErrorCodeRegister(thread, EADDROUTOFBOUNDS)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			if numberLength == 0 {
+				m.SetErrorCodeRegister(thread, EINVALIDLENGTH)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			if m.denyRead(thread, numberAddr, numberLength) {
+				return
+			}
+
+			numberBuffer := string(m.memory[numberAddr : numberAddr+numberLength])
+			number, err := strconv.ParseFloat(numberBuffer, 64)
+			if err != nil {
+				m.SetErrorCodeRegister(thread, MatchString(err.Error()))
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			numberBytes := utils.Bytes8(math.Float64bits(number))
+
+			if m.denyWrite(thread, returnAddr, 8) {
+				return
+			}
+
 			copy(m.memory[returnAddr:returnAddr+8], numberBytes[:])
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
 		},
+		SYS_ALLOC: func(m *Machine, thread *Thread) {
+			size := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			addr, code := m.heapAlloc(thread, size)
+			if code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), addr)
+		},
+		SYS_FREE: func(m *Machine, thread *Thread) {
+			addr := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if code := m.heapFree(addr); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_REALLOC: func(m *Machine, thread *Thread) {
+			addr := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			size := m.getRegister(thread, utils.RegisterToIndex("x1"))
+
+			newAddr, code := m.heapRealloc(thread, addr, size)
+			if code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), newAddr)
+		},
+		SYS_MUTEX_CREATE: func(m *Machine, thread *Thread) {
+			m.setRegister(thread, utils.RegisterToIndex("x0"), m.mutexCreate())
+		},
+		SYS_MUTEX_LOCK: func(m *Machine, thread *Thread) {
+			handle := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if code := m.mutexLock(thread, handle); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+			if thread.blocked {
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_MUTEX_UNLOCK: func(m *Machine, thread *Thread) {
+			handle := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if code := m.mutexUnlock(thread, handle); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_COND_CREATE: func(m *Machine, thread *Thread) {
+			m.setRegister(thread, utils.RegisterToIndex("x0"), m.condCreate())
+		},
+		SYS_COND_WAIT: func(m *Machine, thread *Thread) {
+			cond := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			mutex := m.getRegister(thread, utils.RegisterToIndex("x1"))
+
+			n := -1
+			if code := m.condWait(thread, cond, mutex); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+			if thread.blocked {
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_COND_SIGNAL: func(m *Machine, thread *Thread) {
+			cond := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if code := m.condSignal(cond, false); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
+		SYS_COND_BROADCAST: func(m *Machine, thread *Thread) {
+			cond := m.getRegister(thread, utils.RegisterToIndex("x0"))
+
+			n := -1
+			if code := m.condSignal(cond, true); code != 0 {
+				m.SetErrorCodeRegister(thread, code)
+				m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(n))
+				return
+			}
+
+			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
+		},
 		SYS_CLOCK: func(m *Machine, thread *Thread) {
 			millis := time.Now().UnixMilli()
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(millis))
 		},
 		SYS_NET_LISTEN_TCP: func(m *Machine, thread *Thread) {
 			listenOptsAddr := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			if m.denyRead(thread, listenOptsAddr, 7) {
+				return
+			}
 			listenOptsData := m.memory[listenOptsAddr : listenOptsAddr+7]
 
 			n := -1
@@ -321,6 +730,11 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				network = "tcp6"
 			}
 
+			if !m.allowAddress(address, int(listenOpts.Port)) {
+				m.deny(thread)
+				return
+			}
+
 			ln, err := net.ListenTCP(network, &net.TCPAddr{
 				IP:   address,
 				Port: int(listenOpts.Port),
@@ -337,10 +751,14 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			m.trackFd(int(file.Fd()), true)
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(file.Fd()))
 		},
 		SYS_NET_CONNECT_TCP: func(m *Machine, thread *Thread) {
 			connectOptsAddr := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			if m.denyRead(thread, connectOptsAddr, 7) {
+				return
+			}
 			connectOptsData := m.memory[connectOptsAddr : connectOptsAddr+7]
 
 			n := -1
@@ -364,6 +782,11 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				network = "tcp6"
 			}
 
+			if !m.allowAddress(address, int(connectOpts.Port)) {
+				m.deny(thread)
+				return
+			}
+
 			dial, err := net.DialTCP(network, nil, &net.TCPAddr{
 				IP:   address,
 				Port: int(connectOpts.Port),
@@ -380,15 +803,24 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			m.trackFd(int(file.Fd()), true)
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(file.Fd()))
 		},
 		SYS_NET_ACCEPT: func(m *Machine, thread *Thread) {
 			fd := m.getRegister(thread, utils.RegisterToIndex("x0"))
+			if m.invalidFd(thread, fd) {
+				return
+			}
+			if !m.allowFd(int(fd)) {
+				m.deny(thread)
+				return
+			}
 
 			conn, _, err := syscall.Accept(int(fd))
 			if err != nil {
 				m.SetErrorCodeRegister(thread, MatchString(err.Error()))
 			}
+			m.trackFd(conn, true)
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(conn))
 		},
@@ -396,6 +828,14 @@ func (m *Machine) handleSyscall(thread *Thread) {
 			fd := m.getRegister(thread, utils.RegisterToIndex("x0"))
 			returnAddr := m.getRegister(thread, utils.RegisterToIndex("x1"))
 
+			if m.invalidFd(thread, fd) {
+				return
+			}
+			if !m.allowFd(int(fd)) {
+				m.deny(thread)
+				return
+			}
+
 			n := -1
 			sa, err := syscall.Getpeername(int(fd))
 			if err != nil {
@@ -411,6 +851,10 @@ func (m *Machine) handleSyscall(thread *Thread) {
 			result[4] = byte(sockAddr.Port >> 8)
 			result[5] = byte(sockAddr.Port & 0xff)
 
+			if m.denyWrite(thread, returnAddr, uint64(len(result))) {
+				return
+			}
+
 			copy(m.memory[int(returnAddr):], result)
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(0))
@@ -436,22 +880,36 @@ func (m *Machine) handleSyscall(thread *Thread) {
 				return
 			}
 
+			if m.denyRead(thread, ipAddr, 4) {
+				return
+			}
+
 			ipBuffer := m.memory[ipAddr : ipAddr+4]
 
 			ip := net.IP(ipBuffer).String()
 
+			if m.denyWrite(thread, returnAddr, length) {
+				return
+			}
+
 			copy(m.memory[returnAddr:returnAddr+length], ip[:])
 
 			m.setRegister(thread, utils.RegisterToIndex("x0"), uint64(len(ip)))
 		},
 	}
+}
 
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 2)
-
+func (m *Machine) handleSyscall(thread *Thread) {
 	index := m.getRegister(thread, utils.RegisterToIndex("x15"))
 	sc := SyscallIndex(index)
 
-	if call, ok := Syscalls[sc]; ok {
+	m.syscallsMu.RLock()
+	call, ok := m.syscalls[sc]
+	m.syscallsMu.RUnlock()
+
+	if ok && !m.allowSyscall(sc) {
+		m.deny(thread)
+	} else if ok {
 		call(m, thread)
 	} else {
 		m.SetErrorCodeRegister(thread, UNKNOWN_SYSCAlL)
@@ -459,5 +917,5 @@ func (m *Machine) handleSyscall(thread *Thread) {
 }
 
 func (m *Machine) SetErrorCodeRegister(thread *Thread, code ErrorCode) {
-	m.setRegister(thread, utils.RegisterToIndex("er"), uint64(code))
+	m.setRegister(thread, regER, uint64(code))
 }
@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"fishy/pkg/utils"
+)
+
+// ThreadState is what SYS_THREAD_STATUS reports about a thread.
+type ThreadState int32
+
+const (
+	THREAD_NEW ThreadState = iota
+	THREAD_RUNNING
+	THREAD_FINISHED
+	THREAD_FAULTED
+)
+
+func (s ThreadState) String() string {
+	switch s {
+	case THREAD_NEW:
+		return "new"
+	case THREAD_RUNNING:
+		return "running"
+	case THREAD_FINISHED:
+		return "finished"
+	case THREAD_FAULTED:
+		return "faulted"
+	default:
+		return "unknown"
+	}
+}
+
+// ThreadState can be called from any goroutine while the machine runs.
+func (m *Machine) ThreadState(thread *Thread) ThreadState {
+	return ThreadState(thread.state.Load())
+}
+
+// finish marks a thread that stopped running as done and frees its stack. The
+// value left in x0 becomes its exit value.
+func (m *Machine) finish(thread *Thread) {
+	thread.isRunning = false
+	m.releaseStack(thread)
+	if thread.fault != nil {
+		thread.state.Store(int32(THREAD_FAULTED))
+	} else {
+		thread.exitValue = m.getRegister(thread, utils.RegisterToIndex("x0"))
+		thread.state.Store(int32(THREAD_FINISHED))
+	}
+	close(thread.done)
+}
+
+// finished reports whether the thread ran and stopped.
+func (t *Thread) finished() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopThread makes the thread stop before its next instruction. Threads
+// blocked in a syscall are woken up and fail it with EINTR.
+func (m *Machine) stopThread(thread *Thread) {
+	if thread.stop.CompareAndSwap(false, true) {
+		close(thread.stopped)
+	}
+	m.wakeWaiters()
+}
+
+func (m *Machine) threadStart(target *Thread) ErrorCode {
+	if !target.state.CompareAndSwap(int32(THREAD_NEW), int32(THREAD_RUNNING)) {
+		return EINVAL
+	}
+
+	if m.scheduler != nil {
+		m.scheduler.start(target)
+		return 0
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.RunThread(target)
+	}()
+	return 0
+}
+
+// threadJoin waits for target to finish and returns its exit value. Detached
+// threads and threads that were never started cannot be joined and a thread
+// that faulted has no exit value.
+func (m *Machine) threadJoin(thread *Thread, target *Thread) (uint64, ErrorCode) {
+	if target == thread {
+		return 0, EDEADLK
+	}
+	if target.detached.Load() || ThreadState(target.state.Load()) == THREAD_NEW {
+		return 0, EINVAL
+	}
+
+	if m.scheduler != nil && !target.finished() {
+		m.block(thread)
+		return 0, 0
+	}
+	select {
+	case <-target.done:
+	case <-m.pauseChan(thread):
+		m.block(thread)
+		return 0, 0
+	}
+
+	if target.fault != nil {
+		return 0, ETHREADFAULT
+	}
+	return target.exitValue, 0
+}
+
+func (m *Machine) threadDetach(target *Thread) ErrorCode {
+	if !target.detached.CompareAndSwap(false, true) {
+		return EINVAL
+	}
+	return 0
+}
@@ -0,0 +1,190 @@
+package vm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fishy/pkg/datatype"
+	"fishy/pkg/disasm"
+	"fishy/pkg/opcode"
+	"fishy/pkg/utils"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// TraceRecord is one executed instruction. Registers holds the new value of
+// every register the instruction wrote, except for ip moving past it, and
+// Memory the bytes it changed. An instruction that faulted is recorded with
+// the reason and whatever it changed before.
+type TraceRecord struct {
+	Thread    int               `json:"thread"`
+	Step      uint64            `json:"step"`
+	IP        uint64            `json:"ip"`
+	Mnemonic  string            `json:"mnemonic"`
+	DataType  string            `json:"type,omitempty"`
+	Operands  []string          `json:"operands,omitempty"`
+	Registers map[string]uint64 `json:"registers,omitempty"`
+	Memory    []TraceWrite      `json:"memory,omitempty"`
+	Fault     string            `json:"fault,omitempty"`
+}
+
+// TraceWrite is a run of changed bytes, hex encoded.
+type TraceWrite struct {
+	Addr  uint64 `json:"addr"`
+	Bytes string `json:"bytes"`
+}
+
+// tracer keeps the first error writing to it, the records after it are
+// dropped.
+type tracer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// traceState collects what the current instruction of a thread changes.
+// old keeps the first value of every byte written so bytes that end up with
+// the value they had are left out.
+type traceState struct {
+	index     int
+	decoder   *disasm.Decoder
+	active    bool
+	record    TraceRecord
+	registers uint32
+	old       map[uint64]byte
+}
+
+// SetTrace writes a TraceRecord for every instruction the threads execute to
+// w, one JSON object per line. Records of different threads are interleaved
+// in the order the instructions finished. Run fails if writing to w fails.
+func (m *Machine) SetTrace(w io.Writer) {
+	m.tracer = &tracer{enc: json.NewEncoder(w)}
+}
+
+func (m *Machine) traceBegin(thread *Thread, ip uint64) {
+	state := thread.trace
+	if state == nil {
+		state = &traceState{
+			decoder: disasm.NewDecoder(m.memory, m.labelNames()),
+			old:     make(map[uint64]byte),
+		}
+		state.index, _ = m.GetThreadIndex(thread)
+		thread.trace = state
+	}
+
+	state.active = true
+	state.registers = 0
+	state.record = TraceRecord{Thread: state.index, Step: thread.steps, IP: ip}
+
+	decoded, err := state.decoder.Decode(ip)
+	if err != nil {
+		// the opcode alone, if there is one at ip
+		if ip+2 <= uint64(len(m.memory)) {
+			state.record.Mnemonic = opcode.Opcode(binary.BigEndian.Uint16(m.memory[ip:])).String()
+		}
+		return
+	}
+	state.record.Mnemonic = decoded.Instruction.Name
+	if decoded.Instruction.DataType != datatype.UNSET {
+		state.record.DataType = strings.ToLower(decoded.Instruction.DataType.String())
+	}
+	for _, arg := range decoded.Instruction.Args {
+		state.record.Operands = append(state.record.Operands, disasm.FormatValue(arg))
+	}
+}
+
+// traceWrite has to be called before size bytes at addr are written.
+func (m *Machine) traceWrite(thread *Thread, addr uint64, size int) {
+	if thread == nil || thread.trace == nil || !thread.trace.active {
+		return
+	}
+	for i := addr; i < addr+uint64(size); i++ {
+		if _, ok := thread.trace.old[i]; !ok {
+			thread.trace.old[i] = m.memory[i]
+		}
+	}
+}
+
+// traceEnd writes the record of the current instruction. A syscall that
+// blocked is not recorded, it runs again once the thread is resumed.
+func (m *Machine) traceEnd(thread *Thread, fault string) {
+	state := thread.trace
+	if state == nil || !state.active {
+		return
+	}
+	state.active = false
+	defer clear(state.old)
+	if thread.blocked {
+		return
+	}
+
+	record := state.record
+	record.Fault = fault
+	for i := range thread.registers {
+		if state.registers&(1<<i) != 0 {
+			if record.Registers == nil {
+				record.Registers = make(map[string]uint64)
+			}
+			record.Registers[utils.IndexToRegister(i)] = thread.registers[i]
+		}
+	}
+
+	if len(state.old) > 0 {
+		record.Memory = m.traceChanges(state.old)
+	}
+
+	m.tracer.mu.Lock()
+	defer m.tracer.mu.Unlock()
+	if m.tracer.err == nil {
+		m.tracer.err = m.tracer.enc.Encode(record)
+	}
+}
+
+// traceError adds the first error writing the trace to err, the result of
+// Run.
+func (m *Machine) traceError(err error) error {
+	if m.tracer == nil {
+		return err
+	}
+	m.tracer.mu.Lock()
+	defer m.tracer.mu.Unlock()
+	if m.tracer.err == nil {
+		return err
+	}
+	return errors.Join(err, fmt.Errorf("writing the trace failed: %w", m.tracer.err))
+}
+
+// traceChanges groups the bytes that differ from old into runs. Other threads
+// may be changing the same memory with atomic instructions, so it is read
+// under their lock.
+func (m *Machine) traceChanges(old map[uint64]byte) []TraceWrite {
+	m.atomicMu.Lock()
+	defer m.atomicMu.Unlock()
+
+	addrs := make([]uint64, 0, len(old))
+	for addr, value := range old {
+		if m.memory[addr] != value {
+			addrs = append(addrs, addr)
+		}
+	}
+	slices.Sort(addrs)
+
+	var writes []TraceWrite
+	for i := 0; i < len(addrs); {
+		j := i + 1
+		for j < len(addrs) && addrs[j] == addrs[j-1]+1 {
+			j++
+		}
+		start := addrs[i]
+		writes = append(writes, TraceWrite{
+			Addr:  start,
+			Bytes: hex.EncodeToString(m.memory[start : start+uint64(j-i)]),
+		})
+		i = j
+	}
+	return writes
+}
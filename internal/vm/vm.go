@@ -2,75 +2,198 @@ package vm
 
 import (
 	"encoding/binary"
-	"fishy/pkg/ast"
+	"fishy/pkg/bytecode"
 	"fishy/pkg/datatype"
-	"fishy/pkg/log"
 	"fishy/pkg/opcode"
 	"fishy/pkg/utils"
 	"fmt"
-	"strconv"
-	"strings"
+	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Thread struct {
 	registers []uint64
 	isRunning bool
+	stepping  bool
+	steps     uint64
+	fault     *Fault
 	done      chan bool
+
+	// the stack is [stackBase, stackTop), stack is the heap block it was
+	// allocated in or 0 for the main thread
+	stack     uint64
+	stackBase uint64
+	stackTop  uint64
+
+	state     atomic.Int32
+	stop      atomic.Bool
+	stopped   chan struct{}
+	detached  atomic.Bool
+	exitValue uint64
+
+	// used by the deterministic scheduler, see scheduler.go
+	yielded   bool
+	blocked   bool
+	blockedAt uint64
+	waiting   *guestCond
+	sending   *guestChan
+	receiving *guestChan
+	ticket    uint64
+
+	// the thread whose syscall runs this one with Call. Callbacks are not
+	// in the thread table and are never paused
+	caller *Thread
+
+	// only set while tracing, profiling and covering, see trace.go,
+	// profile.go and coverage.go
+	trace    *traceState
+	profile  *threadProfile
+	coverage map[uint64]*coverCount
 }
 
 type Machine struct {
 	threads     map[int]*Thread
+	threadsMu   sync.RWMutex
 	mainThread  *Thread
 	memory      []byte
 	symbolTable map[uint64]datatype.DataType
+	debugInfo   *bytecode.DebugInfo
+	imageSize   uint64
+	entry       uint64
 	wg          *sync.WaitGroup
 	debug       bool
+	debugger    *debugger
+	syscalls    map[SyscallIndex]SyscallFunction
+	syscallsMu  sync.RWMutex
+	stdin       io.Reader
+	stdout      io.Writer
+	stderr      io.Writer
+	exitMu      sync.Mutex
+	exited      atomic.Bool
+	quit        chan struct{}
+	quitOnce    sync.Once
+	exitCode    int
+	sandbox     *sandbox
+	limits      limits
+	regions     []region
+	heap        *heap
+	stacksMu    sync.RWMutex
+	guards      []region
+	atomicMu    sync.Mutex
+	sync        *syncObjects
+	scheduler   *scheduler
+	tracer      *tracer
+	profiling   bool
+	covering    bool
+	code        *codeCache
+	program     []byte
+	fds         fdTable
+	running     atomic.Bool
+	paused      atomic.Bool
+	pauseMu     sync.Mutex
+	pauseCh     chan struct{}
+
+	requestedHeap  uint64
+	requestedStack uint64
 }
 
-func New(bytecode []byte, memorySize int, debug bool) *Machine {
+func New(program []byte, memorySize int, debug bool) (*Machine, error) {
+	file, err := bytecode.Decode(program)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.ImageSize() > uint64(memorySize) {
+		return nil, fmt.Errorf("program needs %d bytes of memory but only %d are available", file.ImageSize(), memorySize)
+	}
+
+	symbolTable, err := file.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	debugInfo, err := file.DebugInfo()
+	if err != nil {
+		return nil, err
+	}
+
 	m := &Machine{
 		threads:     make(map[int]*Thread),
-		memory:      bytecode,
-		symbolTable: make(map[uint64]datatype.DataType),
+		memory:      make([]byte, memorySize),
+		symbolTable: symbolTable,
+		debugInfo:   debugInfo,
+		imageSize:   file.ImageSize(),
+		entry:       file.Entry,
 		wg:          &sync.WaitGroup{},
 		debug:       debug,
+		debugger: &debugger{
+			breakpoints: make(map[uint64]bool),
+		},
+		syscalls: builtinSyscalls(),
+		sync:     newSyncObjects(),
+		quit:     make(chan struct{}),
+		program:  program,
+		pauseCh:  make(chan struct{}),
 	}
 
-	thread := m.CreateThread()
-	m.mainThread = m.threads[0]
-
-	m.parserHeaderStart()
-	m.parseHeaderSymbolTable()
+	copy(m.memory, file.Image())
+	m.setRegions(file)
+	m.newCodeCache(file)
 
-	// fmt.Println(memorySize)
-	// fmt.Println(len(m.memory))
-	// fmt.Println(memorySize - len(m.memory))
+	thread := m.CreateThread()
+	m.mainThread = thread
 
-	m.memory = append(m.memory, make([]byte, memorySize-len(m.memory))...)
+	// by default the heap and the stack split the free memory in half
+	if err := m.setLayout(0, 0); err != nil {
+		return nil, err
+	}
 
-	m.setRegister(thread, utils.RegisterToIndex("sp"), uint64(len(m.memory)))
+	m.setRegister(thread, regIP, file.Entry)
+	m.setRegister(thread, regSP, uint64(len(m.memory)))
 	m.setRegister(thread, utils.RegisterToIndex("fp"), uint64(len(m.memory)))
 
-	return m
+	return m, nil
 }
 
 func (m *Machine) CreateThread() *Thread {
-	thread := &Thread{
+	thread := newThread()
+	m.addThread(thread)
+	return thread
+}
+
+func newThread() *Thread {
+	return &Thread{
 		registers: make([]uint64, 21),
 		isRunning: true,
 		done:      make(chan bool),
+		stopped:   make(chan struct{}),
 	}
+}
+
+func (m *Machine) addThread(thread *Thread) {
+	m.threadsMu.Lock()
+	defer m.threadsMu.Unlock()
 	m.threads[len(m.threads)] = thread
-	return thread
 }
 
 func (m *Machine) GetThread(index int) (*Thread, bool) {
+	m.threadsMu.RLock()
+	defer m.threadsMu.RUnlock()
 	thread, ok := m.threads[index]
 	return thread, ok
 }
 
+// GetThreadIndex returns the index of thread, a callback run with Call has the
+// index of its caller.
 func (m *Machine) GetThreadIndex(thread *Thread) (int, bool) {
+	for thread.caller != nil {
+		thread = thread.caller
+	}
+
+	m.threadsMu.RLock()
+	defer m.threadsMu.RUnlock()
 	for key, value := range m.threads {
 		if thread == value {
 			return key, true
@@ -79,267 +202,261 @@ func (m *Machine) GetThreadIndex(thread *Thread) (int, bool) {
 	return -1, false
 }
 
-func (m *Machine) RunThread(thread *Thread) {
+func (m *Machine) RunThread(thread *Thread) error {
 	defer func() {
-		thread.isRunning = false
-		close(thread.done)
+		// a paused thread carries on from here when the machine runs again
+		if !m.suspended(thread) {
+			m.finish(thread)
+		}
 	}()
 
-	for thread.isRunning {
-		pos := m.position(thread)
-		instruction := m.decodeNumber("word", pos)
-		op := opcode.Opcode(instruction)
+	// a thread that blocked when it was paused tries the syscall again
+	thread.yielded, thread.blocked = false, false
+	for {
+		if err := m.execute(thread, 0); err != nil || !thread.yielded {
+			return err
+		}
+		if m.suspended(thread) {
+			return nil
+		}
+		// only the deterministic scheduler yields. Nothing else runs while
+		// the host waits for this thread, so a blocked syscall never returns.
+		if thread.blocked {
+			ip := m.getRegister(thread, regIP)
+			thread.fault = &Fault{
+				IP:     ip,
+				Opcode: opcode.SYSCALL,
+				Reason: "blocked while called from the host",
+				Err:    ErrDeadlock,
+				Source: m.sourceAt(ip),
+			}
+			thread.fault.Thread, _ = m.GetThreadIndex(thread)
+			return thread.fault
+		}
+		thread.yielded = false
+	}
+}
+
+// execute runs the instructions of thread until it stops. When n is not 0 it
+// returns after n instructions at the latest, or as soon as the thread yields.
+func (m *Machine) execute(thread *Thread, n int) (err error) {
+	ip := uint64(0)
+	op := opcode.Opcode(-1)
+
+	defer func() {
+		if r := recover(); r != nil {
+			fault := m.recoverFault(thread, ip, op, r)
+			if m.tracer != nil {
+				m.traceEnd(thread, fault.Reason)
+			}
+			// the faulting instruction was reached, count it as executed
+			if m.covering && op != opcode.Opcode(-1) {
+				m.coverInstruction(thread, ip, op)
+			}
+			thread.fault = fault
+			thread.isRunning = false
+			err = fault
+		}
+	}()
+
+	// the deterministic scheduler only pauses between time slices
+	pausable := m.scheduler == nil && thread.caller == nil
+
+	for i := 0; thread.isRunning && !thread.yielded && !m.hasExited(); i++ {
+		if n > 0 && i >= n {
+			break
+		}
+		if thread.stop.Load() {
+			thread.isRunning = false
+			break
+		}
+		if pausable && m.paused.Load() {
+			break
+		}
+
+		ip = m.getRegister(thread, regIP)
+		op = opcode.Opcode(-1)
+		if ip == callReturn {
+			thread.isRunning = false
+			return nil
+		}
+		if ip+2 > uint64(len(m.memory)) {
+			m.fault("instruction pointer out of bounds")
+		}
+		// a cached instruction is part of the text section, so it may run
+		in := m.cached(ip)
+		if in == nil {
+			m.checkAccess(ip, 2, PERM_EXEC)
+			op = opcode.Opcode(binary.BigEndian.Uint16(m.memory[ip:]))
+		} else {
+			op = in.op
+		}
+
+		m.checkLimits(thread)
+
+		if m.debug {
+			m.checkBreak(thread, op)
+		}
+		if m.tracer != nil {
+			m.traceBegin(thread, ip)
+		}
+		var started time.Time
+		if m.profiling {
+			started = m.profileBegin(thread, ip, op)
+		}
+
+		if in == nil {
+			in = m.fetch(ip)
+		}
+		// every handler sees ip at the next instruction, hlt stays put
+		if op != opcode.HLT {
+			thread.registers[regIP] = ip + in.size
+		}
 
 		switch op {
-		case opcode.NOP:
-			m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
+		case opcode.NOP, opcode.BRK:
 		case opcode.HLT:
 			thread.isRunning = false
-			return
-		case opcode.BRK:
-			if m.debug {
-				panic("todo")
-			} else {
-				m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-			}
 		case opcode.SYSCALL:
 			m.handleSyscall(thread)
+		case opcode.YIELD:
+			m.handleYield(thread)
 		case opcode.MOV_REG_REG:
-			m.handleMovRegReg(thread)
+			m.handleMovRegReg(thread, in)
 		case opcode.MOV_REG_LIT:
-			m.handleMovRegLit(thread)
+			m.handleMovRegLit(thread, in)
 		case opcode.MOV_REG_ADR:
-			m.handleMovRegAdr(thread)
+			m.handleMovRegAdr(thread, in)
 		case opcode.MOV_REG_AOF:
-			m.handleMovRegAof(thread)
+			m.handleMovRegAof(thread, in)
 		case opcode.MOV_AOF_REG:
-			m.handleMovAofReg(thread)
+			m.handleMovAofReg(thread, in)
 		case opcode.MOV_AOF_LIT:
-			m.handleMovAofLit(thread)
+			m.handleMovAofLit(thread, in)
 		case opcode.ADD_REG_LIT, opcode.ADD_REG_REG, opcode.ADD_REG_AOF,
 			opcode.SUB_REG_LIT, opcode.SUB_REG_REG, opcode.SUB_REG_AOF,
 			opcode.MUL_REG_LIT, opcode.MUL_REG_REG, opcode.MUL_REG_AOF,
-			opcode.DIV_REG_LIT, opcode.DIV_REG_REG, opcode.DIV_REG_AOF:
-			m.handleArithmetic(thread, op)
+			opcode.DIV_REG_LIT, opcode.DIV_REG_REG, opcode.DIV_REG_AOF,
+			opcode.IMUL_REG_LIT, opcode.IMUL_REG_REG, opcode.IMUL_REG_AOF,
+			opcode.IDIV_REG_LIT, opcode.IDIV_REG_REG, opcode.IDIV_REG_AOF,
+			opcode.MOD_REG_LIT, opcode.MOD_REG_REG, opcode.MOD_REG_AOF,
+			opcode.NEG_REG, opcode.NEG_REG_LIT, opcode.NEG_REG_REG, opcode.NEG_REG_AOF,
+			opcode.INC_REG, opcode.INC_REG_LIT, opcode.INC_REG_REG, opcode.INC_REG_AOF,
+			opcode.DEC_REG, opcode.DEC_REG_LIT, opcode.DEC_REG_REG, opcode.DEC_REG_AOF:
+			m.handleArithmetic(thread, in)
 		case opcode.AND_REG_LIT, opcode.AND_REG_REG,
 			opcode.OR_REG_LIT, opcode.OR_REG_REG,
 			opcode.XOR_REG_LIT, opcode.XOR_REG_REG,
 			opcode.SHL_REG_LIT, opcode.SHL_REG_REG,
-			opcode.SHR_REG_LIT, opcode.SHR_REG_REG:
-			m.handleBitwise(thread, op)
+			opcode.SHR_REG_LIT, opcode.SHR_REG_REG,
+			opcode.SAR_REG_LIT, opcode.SAR_REG_REG,
+			opcode.NOT_REG, opcode.NOT_REG_LIT, opcode.NOT_REG_REG, opcode.NOT_REG_AOF:
+			m.handleBitwise(thread, in)
+		case opcode.FADD_REG_LIT, opcode.FADD_REG_REG, opcode.FADD_REG_AOF,
+			opcode.FSUB_REG_LIT, opcode.FSUB_REG_REG, opcode.FSUB_REG_AOF,
+			opcode.FMUL_REG_LIT, opcode.FMUL_REG_REG, opcode.FMUL_REG_AOF,
+			opcode.FDIV_REG_LIT, opcode.FDIV_REG_REG, opcode.FDIV_REG_AOF,
+			opcode.FCMP_REG_LIT, opcode.FCMP_REG_REG, opcode.FCMP_REG_AOF,
+			opcode.ITOF_REG, opcode.ITOF_REG_LIT, opcode.ITOF_REG_REG, opcode.ITOF_REG_AOF,
+			opcode.FTOI_REG, opcode.FTOI_REG_LIT, opcode.FTOI_REG_REG, opcode.FTOI_REG_AOF:
+			m.handleFloat(thread, in)
 		case opcode.CMP_REG_LIT, opcode.CMP_REG_REG:
-			m.handleCompare(thread, op)
+			m.handleCompare(thread, in)
 		case opcode.JMP_LIT, opcode.JMP_REG,
 			opcode.JEQ_LIT, opcode.JEQ_REG,
 			opcode.JNE_LIT, opcode.JNE_REG,
 			opcode.JLT_LIT, opcode.JLT_REG,
 			opcode.JGT_LIT, opcode.JGT_REG,
 			opcode.JLE_LIT, opcode.JLE_REG,
-			opcode.JGE_LIT, opcode.JGE_REG:
-			m.handleJump(thread, op)
+			opcode.JGE_LIT, opcode.JGE_REG,
+			opcode.JL_LIT, opcode.JL_REG,
+			opcode.JG_LIT, opcode.JG_REG,
+			opcode.JNG_LIT, opcode.JNG_REG,
+			opcode.JNL_LIT, opcode.JNL_REG,
+			opcode.JO_LIT, opcode.JO_REG,
+			opcode.JNO_LIT, opcode.JNO_REG,
+			opcode.JS_LIT, opcode.JS_REG,
+			opcode.JNS_LIT, opcode.JNS_REG:
+			m.handleJump(thread, in)
 		case opcode.PUSH_LIT:
-			m.handlePushLit(thread)
+			m.handlePushLit(thread, in)
 		case opcode.PUSH_REG:
-			m.handlePushReg(thread)
+			m.handlePushReg(thread, in)
 		case opcode.PUSH_AOF:
-			m.handlePushAof(thread)
+			m.handlePushAof(thread, in)
 		case opcode.POP_REG:
-			m.handlePopReg(thread)
+			m.handlePopReg(thread, in)
 		case opcode.POP_AOF:
-			m.handlePopAof(thread)
-		case opcode.CALL_LIT:
-			m.handleCallLit(thread)
+			m.handlePopAof(thread, in)
+		case opcode.CALL_LIT, opcode.CALL_REG, opcode.CALL_AOF:
+			m.handleCall(thread, in)
+		case opcode.XCHG_REG_REG, opcode.XCHG_REG_AOF, opcode.XADD_REG_AOF, opcode.CAS_REG_REG_AOF:
+			m.handleAtomic(thread, in)
 		case opcode.RET:
 			m.handleRet(thread)
 		default:
-			log.Fatal("unhandled instruction", "op", op.String())
+			m.fault("unhandled instruction")
 		}
-	}
-}
 
-func (m *Machine) Run() {
-	m.RunThread(m.mainThread)
-
-}
-
-func (m *Machine) decodeNumber(dataType string, index int) int {
-	dataType = strings.ToLower(dataType)
-	switch dataType {
-	case "byte":
-		return int(m.memory[index])
-	case "word":
-		bytes := m.memory[index : index+2]
-		return int(binary.BigEndian.Uint16(bytes))
-	case "dword":
-		bytes := m.memory[index : index+4]
-		return int(binary.BigEndian.Uint32(bytes))
-	case "qword", "unset":
-		bytes := m.memory[index : index+8]
-		return int(binary.BigEndian.Uint64(bytes))
-	default:
-		log.Fatal("unknown data type", "type", dataType)
-	}
-	return -1
-}
-
-func (m *Machine) decodeNumberBytes(dataType string, index int) []byte {
-	dataType = strings.ToLower(dataType)
-	switch dataType {
-	case "byte":
-		return []byte{m.memory[index]}
-	case "word":
-		return m.memory[index : index+2]
-	case "dword":
-		return m.memory[index : index+4]
-	case "qword", "unset":
-		return m.memory[index : index+8]
-	default:
-		log.Fatal("unknown data type", "type", dataType)
-	}
-	return nil
-}
-
-func (m *Machine) decodeRegister(index int) int {
-	v := m.memory[index]
-	return int(v)
-}
-
-func (m *Machine) decodeValue(thread *Thread, dataType datatype.DataType) ast.Value {
-	pos := m.position(thread)
-	indexValue := m.memory[pos]
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-	switch indexValue {
-	case 2:
-		pos := m.position(thread)
-		reg := m.decodeRegister(pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-		return &ast.Register{Value: reg}
-	case 0, 1, 3, 4:
-		pos := m.position(thread)
-		num := m.decodeNumber(dataType.String(), pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(dataType.Size()))
-		return &ast.NumberLiteral{Value: strconv.Itoa(num)}
-	case 5:
-		pos := m.position(thread)
-		reg := m.decodeRegister(pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		pos = m.position(thread)
-		op := ast.Operator(m.decodeNumber("byte", pos))
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		pos = m.position(thread)
-		offset := m.decodeNumber(dataType.String(), pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(dataType.Size()))
-
-		return &ast.RegisterOffsetNumber{
-			Left:     ast.Register{Value: reg},
-			Operator: op,
-			Right:    ast.NumberLiteral{Value: strconv.Itoa(offset)},
-		}
-	case 6:
-		pos := m.position(thread)
-		reg0 := m.decodeRegister(pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		pos = m.position(thread)
-		op := ast.Operator(m.decodeNumber("byte", pos))
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		pos = m.position(thread)
-		reg1 := m.decodeRegister(pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		return &ast.RegisterOffsetRegister{
-			Left:     ast.Register{Value: reg0},
-			Operator: op,
-			Right:    ast.Register{Value: reg1},
+		if m.tracer != nil {
+			m.traceEnd(thread, "")
 		}
-	case 7:
-		pos := m.position(thread)
-		addr := m.decodeNumber(dataType.String(), pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(dataType.Size()))
-
-		pos = m.position(thread)
-		op := ast.Operator(m.decodeNumber("byte", pos))
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		pos = m.position(thread)
-		offset := m.decodeNumber(dataType.String(), pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(dataType.Size()))
-
-		return &ast.LabelOffsetNumber{
-			Left:     &ast.NumberLiteral{Value: strconv.Itoa(addr)},
-			Operator: op,
-			Right:    ast.NumberLiteral{Value: strconv.Itoa(offset)},
+		if m.profiling {
+			m.profileEnd(thread, ip, op, started)
 		}
-	case 8:
-		pos := m.position(thread)
-		addr := m.decodeNumber(dataType.String(), pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(dataType.Size()))
-
-		pos = m.position(thread)
-		op := ast.Operator(m.decodeNumber("byte", pos))
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		pos = m.position(thread)
-		reg := m.decodeRegister(pos)
-		m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-
-		return &ast.LabelOffsetRegister{
-			Left:     &ast.NumberLiteral{Value: strconv.Itoa(addr)},
-			Operator: op,
-			Right:    ast.Register{Value: reg},
+		if m.covering {
+			m.coverInstruction(thread, ip, op)
 		}
-	default:
-		log.Fatal("unknown value index", "index", indexValue)
 	}
-	return nil
-}
 
-func (m *Machine) parserHeaderStart() {
-	start := m.readLiteral(m.mainThread, datatype.QWORD)
-	m.memory = m.memory[8:]
-	m.setRegister(m.mainThread, utils.RegisterToIndex("ip"), uint64(start))
+	return nil
 }
 
-func (m *Machine) parseHeaderSymbolTable() {
-	size := int(binary.BigEndian.Uint64(m.memory[:8]))
-	start := binary.BigEndian.Uint64(m.memory[8:16]) - 8
-	end := binary.BigEndian.Uint64(m.memory[16:24]) - 8
+// Run executes the main thread. If it finishes cleanly, the fault of the
+// lowest numbered thread that crashed in the meantime is returned instead.
+// A paused machine carries on where it stopped.
+func (m *Machine) Run() (err error) {
+	m.running.Store(true)
+	defer m.running.Store(false)
+	defer func() { err = m.traceError(err) }()
+
+	stop := m.startTimeout()
+	defer stop()
+
+	resumed := m.unpause()
+	m.mainThread.state.Store(int32(THREAD_RUNNING))
+	if resumed {
+		m.resumeThreads()
+	}
+	if m.scheduler != nil {
+		if err := m.runScheduled(resumed); err != nil {
+			return err
+		}
+	} else if err := m.RunThread(m.mainThread); err != nil {
+		return err
+	}
 
-	keyValues := m.memory[start:end]
+	if m.suspended(m.mainThread) {
+		m.wg.Wait()
+		return ErrPaused
+	}
 
-	for i := 0; i < len(keyValues); i += size + 1 {
-		if i+size >= len(keyValues) {
-			log.Fatal(fmt.Sprintf("symbol table is not multiple of %d", size+1), "length", len(keyValues))
+	for i := 1; ; i++ {
+		thread, ok := m.GetThread(i)
+		if !ok {
+			return nil
 		}
-
-		var key uint64
-		switch size {
-		case 2:
-			key = uint64(binary.BigEndian.Uint16(keyValues[i : i+size]))
-		case 4:
-			key = uint64(binary.BigEndian.Uint32(keyValues[i : i+size]))
-		default:
-			key = binary.BigEndian.Uint64(keyValues[i : i+size])
+		if m.ThreadState(thread) == THREAD_FAULTED {
+			return thread.fault
 		}
-		value := keyValues[i+size]
-
-		m.symbolTable[key] = datatype.DataType(value)
 	}
-
-	m.memory = m.memory[end:]
-}
-
-func (m *Machine) position(thread *Thread) int {
-	index := utils.RegisterToIndex("ip")
-	return int(m.getRegister(thread, index))
 }
 
 func (m *Machine) setRegister(thread *Thread, index int, value uint64) {
 	thread.registers[index] = value
+	if thread.trace != nil {
+		thread.trace.registers |= 1 << index
+	}
 }
 
 func (m *Machine) getRegister(thread *Thread, index int) uint64 {
@@ -348,39 +465,47 @@ func (m *Machine) getRegister(thread *Thread, index int) uint64 {
 
 func (m *Machine) incRegister(thread *Thread, index int, amount uint64) {
 	thread.registers[index] += amount
-}
-
-func (m *Machine) readRegister(thread *Thread) int {
-	pos := m.position(thread)
-	reg := m.decodeRegister(pos)
-	m.incRegister(thread, utils.RegisterToIndex("ip"), 1)
-	return reg
-}
-
-func (m *Machine) readLiteral(thread *Thread, dataType datatype.DataType) uint64 {
-	pos := m.position(thread)
-	lit := m.decodeNumber(dataType.String(), pos)
-	m.incRegister(thread, utils.RegisterToIndex("ip"), uint64(dataType.Size()))
-	return uint64(lit)
+	if thread.trace != nil && index != regIP {
+		thread.trace.registers |= 1 << index
+	}
 }
 
 func (m *Machine) stackPush(thread *Thread, v []byte) {
-	spIndex := utils.RegisterToIndex("sp")
+	spIndex := regSP
 	spValue := m.getRegister(thread, spIndex)
 
 	// byteArray := utils.Bytes8(v)
 
+	if spValue < thread.stackBase+uint64(len(v)) {
+		m.fault("stack overflow")
+	}
+	if spValue > thread.stackTop {
+		m.fault("stack underflow")
+	}
 	memIndex := int(spValue) - len(v)
 
+	m.traceWrite(thread, uint64(memIndex), len(v))
 	copy(m.memory[memIndex:memIndex+len(v)], v)
 
 	m.setRegister(thread, spIndex, spValue-uint64(len(v)))
 }
 
+// checkStackPop faults unless the size bytes at sp are on the stack of
+// thread.
+func (m *Machine) checkStackPop(thread *Thread, sp uint64, size int) {
+	if sp < thread.stackBase {
+		m.fault("stack overflow")
+	}
+	if sp > thread.stackTop || thread.stackTop-sp < uint64(size) {
+		m.fault("stack underflow")
+	}
+}
+
 func (m *Machine) stackPopBytes(thread *Thread, dataType datatype.DataType) []byte {
-	spIndex := utils.RegisterToIndex("sp")
+	spIndex := regSP
 	spValue := m.getRegister(thread, spIndex)
 
+	m.checkStackPop(thread, spValue, dataType.Size())
 	memIndex := int(spValue)
 
 	var value []byte
@@ -394,7 +519,7 @@ func (m *Machine) stackPopBytes(thread *Thread, dataType datatype.DataType) []by
 	case datatype.QWORD, datatype.UNSET:
 		value = m.memory[memIndex : memIndex+dataType.Size()]
 	default:
-		log.Fatal("unknown data type", "type", dataType)
+		m.fault("unknown data type %s", dataType)
 	}
 
 	m.setRegister(thread, spIndex, spValue+uint64(dataType.Size()))
@@ -403,9 +528,10 @@ func (m *Machine) stackPopBytes(thread *Thread, dataType datatype.DataType) []by
 }
 
 func (m *Machine) stackPop(thread *Thread, dataType datatype.DataType) uint64 {
-	spIndex := utils.RegisterToIndex("sp")
+	spIndex := regSP
 	spValue := m.getRegister(thread, spIndex)
 
+	m.checkStackPop(thread, spValue, dataType.Size())
 	memIndex := int(spValue)
 
 	var value uint64
@@ -419,7 +545,7 @@ func (m *Machine) stackPop(thread *Thread, dataType datatype.DataType) uint64 {
 	case datatype.QWORD, datatype.UNSET:
 		value = binary.BigEndian.Uint64(m.memory[memIndex : memIndex+dataType.Size()])
 	default:
-		log.Fatal("unknown data type", "type", dataType)
+		m.fault("unknown data type %s", dataType)
 	}
 
 	m.setRegister(thread, spIndex, spValue+uint64(dataType.Size()))
@@ -428,7 +554,8 @@ func (m *Machine) stackPop(thread *Thread, dataType datatype.DataType) uint64 {
 }
 
 func (m *Machine) DumpRegisters(index int) {
-	for i, register := range m.threads[index].registers {
+	thread, _ := m.GetThread(index)
+	for i, register := range thread.registers {
 		name := utils.IndexToRegister(i)
 		fmt.Printf("%-3s: 0x%016X\n", name, register)
 	}
@@ -449,7 +576,7 @@ func (m *Machine) DumpMemory(start int, end int) {
 		}
 		lineBytes := bytecode[startIndex:endIndex]
 
-		fmt.Printf("0x%04X: ", startIndex)
+		fmt.Printf("0x%04X: ", start+startIndex)
 
 		for _, b := range lineBytes {
 			fmt.Printf("%02X ", b)
@@ -6,23 +6,28 @@ import (
 	"fishy/pkg/token"
 )
 
+// Labels, instructions and sequences keep the offset they start at in the
+// source they were parsed from in Start.
 type Statement interface {
 	String() string
 }
 
 type Label struct {
-	Name string
+	Name  string
+	Start int
 }
 
 type Instruction struct {
 	Name     string
 	DataType datatype.DataType
 	Args     []Value
+	Start    int
 }
 
 type Sequence struct {
 	Name   string
 	Values []Value
+	Start  int
 }
 
 type RegisterOffsetNumber struct {
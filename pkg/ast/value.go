@@ -9,6 +9,12 @@ type NumberLiteral struct {
 	Value string
 }
 
+// FloatLiteral is encoded as single precision for dword operands and as double
+// precision for everything else.
+type FloatLiteral struct {
+	Value string
+}
+
 type StringLiteral struct {
 	Value string
 }
@@ -26,12 +32,14 @@ type Identifier struct {
 }
 
 func (n *NumberLiteral) String() string { return "NUMBER" }
+func (f *FloatLiteral) String() string  { return "FLOAT" }
 func (s *StringLiteral) String() string { return "STRING" }
 func (r *Register) String() string      { return "REGISTER" }
 func (a *AddressOf) String() string     { return "ADDRESS_OF" }
 func (i *Identifier) String() string    { return "IDENTIFIER" }
 
 func (n *NumberLiteral) Index() int          { return 0 }
+func (f *FloatLiteral) Index() int           { return 0 }
 func (s *StringLiteral) Index() int          { return 1 }
 func (r *Register) Index() int               { return 2 }
 func (a *AddressOf) Index() int              { return 3 }
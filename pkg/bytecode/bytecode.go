@@ -0,0 +1,277 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fishy/pkg/datatype"
+	"fishy/pkg/utils"
+	"fmt"
+	"hash/crc32"
+)
+
+// A Fishy Bytecode file starts with a fixed header followed by a table of
+// section descriptors and then the contents of every section:
+//
+//	magic         [4]byte "FISH"
+//	version       uint16
+//	section count uint16
+//	entry         uint64
+//	checksum      uint32 (CRC-32 of everything after this field)
+//	sections      [section count]descriptor
+//	contents      ...
+//
+// Every descriptor is:
+//
+//	kind      uint8
+//	entsize   uint8  (width of a symbol address, only used by the symbol table)
+//	addr      uint64 (load address in memory)
+//	size      uint64 (size in memory)
+//	offset    uint64 (offset of the contents in the file)
+//	length    uint64 (length of the contents in the file)
+//
+// The bss section has a size but no contents so it takes up no space in the file.
+// The symbol table and the optional debug info section (see DebugInfo) are not
+// loaded into memory.
+
+var Magic = [4]byte{'F', 'I', 'S', 'H'}
+
+const Version = 1
+
+// MaxImageSize is the most memory the loadable sections of a file may span.
+// Decode rejects anything larger so a corrupted size cannot make Image
+// allocate without limit.
+const MaxImageSize = 1 << 30
+
+const (
+	headerSize     = 20
+	descriptorSize = 34
+)
+
+type SectionKind uint8
+
+const (
+	SECTION_TEXT SectionKind = iota
+	SECTION_DATA
+	SECTION_BSS
+	SECTION_SYMBOLS
+	SECTION_RODATA
+	SECTION_DEBUG
+)
+
+func (s SectionKind) String() string {
+	switch s {
+	case SECTION_TEXT:
+		return "text"
+	case SECTION_DATA:
+		return "data"
+	case SECTION_BSS:
+		return "bss"
+	case SECTION_SYMBOLS:
+		return "symbols"
+	case SECTION_RODATA:
+		return "rodata"
+	case SECTION_DEBUG:
+		return "debug"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// Loadable reports whether the section is part of the memory image.
+func (s SectionKind) Loadable() bool {
+	switch s {
+	case SECTION_TEXT, SECTION_RODATA, SECTION_DATA, SECTION_BSS:
+		return true
+	default:
+		return false
+	}
+}
+
+type Section struct {
+	Kind    SectionKind
+	EntSize uint8
+	Addr    uint64
+	Size    uint64
+	Data    []byte
+}
+
+type File struct {
+	Version  uint16
+	Entry    uint64
+	Sections []*Section
+}
+
+func (f *File) Section(kind SectionKind) *Section {
+	for _, section := range f.Sections {
+		if section.Kind == kind {
+			return section
+		}
+	}
+	return nil
+}
+
+// ImageSize is the amount of memory needed to hold every loadable section.
+func (f *File) ImageSize() uint64 {
+	size := uint64(0)
+	for _, section := range f.Sections {
+		if section.Kind.Loadable() && section.Addr+section.Size > size {
+			size = section.Addr + section.Size
+		}
+	}
+	return size
+}
+
+// Image lays out every loadable section at its address, bss is zero filled.
+func (f *File) Image() []byte {
+	image := make([]byte, f.ImageSize())
+	for _, section := range f.Sections {
+		if section.Kind.Loadable() {
+			copy(image[section.Addr:section.Addr+section.Size], section.Data)
+		}
+	}
+	return image
+}
+
+// Symbols decodes the symbol table section into address to data type pairs.
+func (f *File) Symbols() (map[uint64]datatype.DataType, error) {
+	symbols := make(map[uint64]datatype.DataType)
+
+	section := f.Section(SECTION_SYMBOLS)
+	if section == nil {
+		return symbols, nil
+	}
+
+	size := int(section.EntSize)
+	switch size {
+	case 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("invalid symbol table entry size %d", size)
+	}
+
+	keyValues := section.Data
+	if len(keyValues)%(size+1) != 0 {
+		return nil, fmt.Errorf("symbol table is not multiple of %d", size+1)
+	}
+
+	for i := 0; i < len(keyValues); i += size + 1 {
+		var key uint64
+		switch size {
+		case 2:
+			key = uint64(binary.BigEndian.Uint16(keyValues[i : i+size]))
+		case 4:
+			key = uint64(binary.BigEndian.Uint32(keyValues[i : i+size]))
+		default:
+			key = binary.BigEndian.Uint64(keyValues[i : i+size])
+		}
+		symbols[key] = datatype.DataType(keyValues[i+size])
+	}
+
+	return symbols, nil
+}
+
+func (f *File) Encode() []byte {
+	body := []byte{}
+	offset := uint64(headerSize + descriptorSize*len(f.Sections))
+
+	for _, section := range f.Sections {
+		body = append(body, byte(section.Kind), section.EntSize)
+		body = append(body, utils.Bytes8(section.Addr)...)
+		body = append(body, utils.Bytes8(section.Size)...)
+		body = append(body, utils.Bytes8(offset)...)
+		body = append(body, utils.Bytes8(uint64(len(section.Data)))...)
+		offset += uint64(len(section.Data))
+	}
+	for _, section := range f.Sections {
+		body = append(body, section.Data...)
+	}
+
+	out := append([]byte{}, Magic[:]...)
+	out = append(out, utils.Bytes2(f.Version)...)
+	out = append(out, utils.Bytes2(uint16(len(f.Sections)))...)
+	out = append(out, utils.Bytes8(f.Entry)...)
+	out = append(out, utils.Bytes4(crc32.ChecksumIEEE(body))...)
+	out = append(out, body...)
+	return out
+}
+
+func Decode(b []byte) (*File, error) {
+	if len(b) < headerSize || !bytes.Equal(b[0:4], Magic[:]) {
+		return nil, fmt.Errorf("not a Fishy Bytecode file")
+	}
+
+	f := &File{
+		Version: binary.BigEndian.Uint16(b[4:6]),
+		Entry:   binary.BigEndian.Uint64(b[8:16]),
+	}
+	if f.Version != Version {
+		return nil, fmt.Errorf("unsupported bytecode version %d (expected %d), rebuild the program", f.Version, Version)
+	}
+
+	checksum := binary.BigEndian.Uint32(b[16:20])
+	if crc32.ChecksumIEEE(b[headerSize:]) != checksum {
+		return nil, fmt.Errorf("bytecode checksum mismatch, the file is corrupted")
+	}
+
+	count := int(binary.BigEndian.Uint16(b[6:8]))
+	if len(b) < headerSize+count*descriptorSize {
+		return nil, fmt.Errorf("section table is truncated")
+	}
+
+	for i := 0; i < count; i++ {
+		d := b[headerSize+i*descriptorSize : headerSize+(i+1)*descriptorSize]
+		section := &Section{
+			Kind:    SectionKind(d[0]),
+			EntSize: d[1],
+			Addr:    binary.BigEndian.Uint64(d[2:10]),
+			Size:    binary.BigEndian.Uint64(d[10:18]),
+		}
+		offset := binary.BigEndian.Uint64(d[18:26])
+		length := binary.BigEndian.Uint64(d[26:34])
+
+		if offset > uint64(len(b)) || length > uint64(len(b))-offset {
+			return nil, fmt.Errorf("%s section is out of bounds", section.Kind)
+		}
+		if section.Kind.Loadable() && length > section.Size {
+			return nil, fmt.Errorf("%s section contents are larger than its size", section.Kind)
+		}
+		if section.Kind.Loadable() && section.Addr+section.Size < section.Addr {
+			return nil, fmt.Errorf("%s section at 0x%X does not fit in memory", section.Kind, section.Addr)
+		}
+		section.Data = b[offset : offset+length]
+
+		f.Sections = append(f.Sections, section)
+	}
+
+	if err := f.checkOverlap(); err != nil {
+		return nil, err
+	}
+	if f.Section(SECTION_TEXT) == nil {
+		return nil, fmt.Errorf("bytecode has no text section")
+	}
+	if size := f.ImageSize(); size > MaxImageSize {
+		return nil, fmt.Errorf("program needs %d bytes of memory, more than the %d a program may use", size, MaxImageSize)
+	}
+	if f.Entry >= f.ImageSize() {
+		return nil, fmt.Errorf("entry point 0x%X is outside of the program", f.Entry)
+	}
+
+	return f, nil
+}
+
+// checkOverlap makes sure no two loadable sections share memory.
+func (f *File) checkOverlap() error {
+	for i, a := range f.Sections {
+		if !a.Kind.Loadable() || a.Size == 0 {
+			continue
+		}
+		for _, b := range f.Sections[i+1:] {
+			if !b.Kind.Loadable() || b.Size == 0 {
+				continue
+			}
+			if a.Addr < b.Addr+b.Size && b.Addr < a.Addr+a.Size {
+				return fmt.Errorf("%s section overlaps the %s section", a.Kind, b.Kind)
+			}
+		}
+	}
+	return nil
+}
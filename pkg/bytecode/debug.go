@@ -0,0 +1,169 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fishy/pkg/utils"
+	"fmt"
+	"sort"
+)
+
+// The debug info section maps the address of every instruction back to the
+// source it was compiled from and keeps the label names:
+//
+//	file count  uint32
+//	files       [file count](length uint16, name)
+//	label count uint32
+//	labels      [label count](addr uint64, length uint16, name)
+//	line count  uint32
+//	lines       [line count](addr uint64, file uint32, line uint32, column uint32)
+//
+// Lines are sorted by address, file is an index into files.
+
+// Position is a place in a source file, Line and Column start at 1.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// LineEntry is the position of the instruction at Addr.
+type LineEntry struct {
+	Addr     uint64
+	Position Position
+}
+
+type DebugInfo struct {
+	Labels map[string]uint64
+	Lines  []LineEntry
+}
+
+// Lookup returns the position of the instruction addr belongs to.
+func (d *DebugInfo) Lookup(addr uint64) (Position, bool) {
+	i := sort.Search(len(d.Lines), func(i int) bool { return d.Lines[i].Addr > addr })
+	if i == 0 {
+		return Position{}, false
+	}
+	return d.Lines[i-1].Position, true
+}
+
+func (d *DebugInfo) Encode() []byte {
+	files := []string{}
+	fileIndex := make(map[string]uint32)
+	for _, line := range d.Lines {
+		if _, ok := fileIndex[line.Position.File]; !ok {
+			fileIndex[line.Position.File] = uint32(len(files))
+			files = append(files, line.Position.File)
+		}
+	}
+
+	names := make([]string, 0, len(d.Labels))
+	for name := range d.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := utils.Bytes4(uint32(len(files)))
+	for _, file := range files {
+		out = append(out, utils.Bytes2(uint16(len(file)))...)
+		out = append(out, file...)
+	}
+	out = append(out, utils.Bytes4(uint32(len(names)))...)
+	for _, name := range names {
+		out = append(out, utils.Bytes8(d.Labels[name])...)
+		out = append(out, utils.Bytes2(uint16(len(name)))...)
+		out = append(out, name...)
+	}
+	out = append(out, utils.Bytes4(uint32(len(d.Lines)))...)
+	for _, line := range d.Lines {
+		out = append(out, utils.Bytes8(line.Addr)...)
+		out = append(out, utils.Bytes4(fileIndex[line.Position.File])...)
+		out = append(out, utils.Bytes4(uint32(line.Position.Line))...)
+		out = append(out, utils.Bytes4(uint32(line.Position.Column))...)
+	}
+	return out
+}
+
+func DecodeDebugInfo(b []byte) (*DebugInfo, error) {
+	r := &debugReader{b: b}
+	d := &DebugInfo{Labels: make(map[string]uint64)}
+
+	var files []string
+	count := int(r.uint32())
+	for i := 0; i < count && r.err == nil; i++ {
+		files = append(files, r.string())
+	}
+	labels := int(r.uint32())
+	for i := 0; i < labels && r.err == nil; i++ {
+		addr := r.uint64()
+		d.Labels[r.string()] = addr
+	}
+	lines := int(r.uint32())
+	for i := 0; i < lines && r.err == nil; i++ {
+		addr := r.uint64()
+		file := r.uint32()
+		line := r.uint32()
+		column := r.uint32()
+		if r.err != nil {
+			break
+		}
+		if int(file) >= len(files) {
+			return nil, fmt.Errorf("debug info line refers to unknown file %d", file)
+		}
+		d.Lines = append(d.Lines, LineEntry{
+			Addr:     addr,
+			Position: Position{File: files[file], Line: int(line), Column: int(column)},
+		})
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !sort.SliceIsSorted(d.Lines, func(i, j int) bool { return d.Lines[i].Addr < d.Lines[j].Addr }) {
+		return nil, fmt.Errorf("debug info lines are not sorted by address")
+	}
+	return d, nil
+}
+
+// DebugInfo decodes the debug info section, it is nil if the program was
+// built without one.
+func (f *File) DebugInfo() (*DebugInfo, error) {
+	section := f.Section(SECTION_DEBUG)
+	if section == nil {
+		return nil, nil
+	}
+	return DecodeDebugInfo(section.Data)
+}
+
+// debugReader remembers the first read past the end so the decoder only has
+// to check once.
+type debugReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *debugReader) next(n int) []byte {
+	if r.err != nil || n > len(r.b)-r.pos {
+		r.err = fmt.Errorf("debug info section is truncated")
+		return make([]byte, n)
+	}
+	r.pos += n
+	return r.b[r.pos-n : r.pos]
+}
+
+func (r *debugReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.next(4))
+}
+
+func (r *debugReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.next(8))
+}
+
+func (r *debugReader) string() string {
+	length := int(binary.BigEndian.Uint16(r.next(2)))
+	return string(r.next(length))
+}
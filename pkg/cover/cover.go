@@ -0,0 +1,272 @@
+// Package cover reads, writes and reports the coverage `fishy run --coverage`
+// collects.
+//
+// A coverage file lists every instruction of the text section, one per line
+// with tab separated fields:
+//
+//	mode: count
+//	0x0018	main.fi:9:5	mov x1, 0	1
+//	0x0024	main.fi:11:5	jne .loop	10	9	1
+//
+// The fields are the address, the source position ("-" without debug info),
+// the disassembled instruction and how often it ran. Conditional jumps add how
+// often they were taken and not taken.
+package cover
+
+import (
+	"bufio"
+	"fishy/pkg/bytecode"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Instruction is the coverage of one instruction.
+type Instruction struct {
+	Addr        uint64
+	Position    bytecode.Position
+	Instruction string
+	Count       uint64
+	Branch      bool
+	Taken       uint64
+	NotTaken    uint64
+}
+
+type Profile struct {
+	Instructions []Instruction
+}
+
+func (p *Profile) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "mode: count")
+	for _, instruction := range p.Instructions {
+		position := "-"
+		if instruction.Position.File != "" {
+			position = instruction.Position.String()
+		}
+		fmt.Fprintf(bw, "0x%04X\t%s\t%s\t%d", instruction.Addr, position, instruction.Instruction, instruction.Count)
+		if instruction.Branch {
+			fmt.Fprintf(bw, "\t%d\t%d", instruction.Taken, instruction.NotTaken)
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+func Parse(r io.Reader) (*Profile, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || scanner.Text() != "mode: count" {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("not a coverage file, expected \"mode: count\"")
+	}
+
+	p := &Profile{}
+	for n := 2; scanner.Scan(); n++ {
+		if scanner.Text() == "" {
+			continue
+		}
+		instruction, err := parseInstruction(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		p.Instructions = append(p.Instructions, instruction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func parseInstruction(line string) (Instruction, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 4 && len(fields) != 6 {
+		return Instruction{}, fmt.Errorf("expected 4 or 6 fields, got %d", len(fields))
+	}
+
+	var numbers []uint64
+	for _, field := range append([]string{fields[0]}, fields[3:]...) {
+		number, err := strconv.ParseUint(field, 0, 64)
+		if err != nil {
+			return Instruction{}, fmt.Errorf("invalid number %q", field)
+		}
+		numbers = append(numbers, number)
+	}
+
+	instruction := Instruction{Addr: numbers[0], Instruction: fields[2], Count: numbers[1]}
+	if fields[1] != "-" {
+		position, err := parsePosition(fields[1])
+		if err != nil {
+			return Instruction{}, err
+		}
+		instruction.Position = position
+	}
+	if len(numbers) == 4 {
+		instruction.Branch = true
+		instruction.Taken = numbers[2]
+		instruction.NotTaken = numbers[3]
+	}
+	return instruction, nil
+}
+
+// parsePosition splits file:line:col from the right, the file may contain
+// colons itself.
+func parsePosition(s string) (bytecode.Position, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 {
+		return bytecode.Position{}, fmt.Errorf("invalid position %q", s)
+	}
+	line, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return bytecode.Position{}, fmt.Errorf("invalid position %q", s)
+	}
+	column, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return bytecode.Position{}, fmt.Errorf("invalid position %q", s)
+	}
+	return bytecode.Position{File: strings.Join(parts[:len(parts)-2], ":"), Line: line, Column: column}, nil
+}
+
+// missed describes what did not run of the instruction, it is empty when
+// the instruction and both ways of a conditional jump ran.
+func (i Instruction) missed() string {
+	switch {
+	case i.Count == 0:
+		return "not executed"
+	case !i.Branch:
+		return ""
+	case i.Taken == 0:
+		return "never taken"
+	case i.NotTaken == 0:
+		return "always taken"
+	}
+	return ""
+}
+
+// branchesCovered counts the ways a conditional jump went, 0 to 2.
+func (i Instruction) branchesCovered() int {
+	covered := 0
+	if i.Taken > 0 {
+		covered++
+	}
+	if i.NotTaken > 0 {
+		covered++
+	}
+	return covered
+}
+
+// Line is the coverage of the instructions compiled from one source line,
+// there can be several when it called a macro. Every conditional jump has two
+// branches, taken and not taken.
+type Line struct {
+	Number          int
+	Instructions    int
+	Executed        int
+	Count           uint64
+	Branches        int
+	BranchesCovered int
+	// Missed describes the instructions that did not run completely.
+	Missed []string
+}
+
+// Covered is true when every instruction and branch of the line ran.
+func (l Line) Covered() bool {
+	return l.Executed == l.Instructions && l.BranchesCovered == l.Branches
+}
+
+type File struct {
+	Name  string
+	Lines []Line
+}
+
+// Files groups the instructions by source file and line, sorted by name.
+// Instructions without a position are left out.
+func (p *Profile) Files() []File {
+	lines := make(map[string]map[int]*Line)
+	for _, instruction := range p.Instructions {
+		file := instruction.Position.File
+		if file == "" {
+			continue
+		}
+		if lines[file] == nil {
+			lines[file] = make(map[int]*Line)
+		}
+		line, ok := lines[file][instruction.Position.Line]
+		if !ok {
+			line = &Line{Number: instruction.Position.Line}
+			lines[file][instruction.Position.Line] = line
+		}
+
+		line.Instructions++
+		if instruction.Count > 0 {
+			line.Executed++
+		}
+		line.Count = max(line.Count, instruction.Count)
+		if instruction.Branch {
+			line.Branches += 2
+			line.BranchesCovered += instruction.branchesCovered()
+		}
+		if missed := instruction.missed(); missed != "" {
+			line.Missed = append(line.Missed, instruction.Instruction+" "+missed)
+		}
+	}
+
+	var files []File
+	for name, byNumber := range lines {
+		file := File{Name: name}
+		for _, line := range byNumber {
+			file.Lines = append(file.Lines, *line)
+		}
+		slices.SortFunc(file.Lines, func(a, b Line) int { return a.Number - b.Number })
+		files = append(files, file)
+	}
+	slices.SortFunc(files, func(a, b File) int { return strings.Compare(a.Name, b.Name) })
+	return files
+}
+
+// Summary counts what a file or the whole profile covered.
+type Summary struct {
+	Lines, LinesCovered               int
+	Instructions, InstructionsCovered int
+	Branches, BranchesCovered         int
+}
+
+func (f File) Summary() Summary {
+	var s Summary
+	for _, line := range f.Lines {
+		s.Lines++
+		if line.Covered() {
+			s.LinesCovered++
+		}
+		s.Instructions += line.Instructions
+		s.InstructionsCovered += line.Executed
+		s.Branches += line.Branches
+		s.BranchesCovered += line.BranchesCovered
+	}
+	return s
+}
+
+// Summary also counts the instructions without a position, which have no
+// lines.
+func (p *Profile) Summary() Summary {
+	var s Summary
+	for _, file := range p.Files() {
+		fileSummary := file.Summary()
+		s.Lines += fileSummary.Lines
+		s.LinesCovered += fileSummary.LinesCovered
+	}
+	for _, instruction := range p.Instructions {
+		s.Instructions++
+		if instruction.Count > 0 {
+			s.InstructionsCovered++
+		}
+		if instruction.Branch {
+			s.Branches += 2
+			s.BranchesCovered += instruction.branchesCovered()
+		}
+	}
+	return s
+}
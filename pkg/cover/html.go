@@ -0,0 +1,121 @@
+package cover
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+)
+
+type htmlFile struct {
+	Name    string
+	Summary string
+	Missing bool
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string
+	Title  string
+}
+
+var htmlTemplate = template.Must(template.New("cover").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>fishy coverage</title>
+<style>
+body { font-family: sans-serif; background: #fff; color: #222; }
+h2 { font-size: 1em; margin-top: 2em; }
+pre { font-family: monospace; line-height: 1.3; }
+.number { color: #999; display: inline-block; width: 4em; text-align: right; margin-right: 1em; }
+.covered { background: #cfc; }
+.partial { background: #ffc; }
+.missed { background: #fcc; }
+</style>
+</head>
+<body>
+<p>{{.Summary}}</p>
+<p><span class="covered">covered</span> <span class="partial">partially covered</span> <span class="missed">not covered</span></p>
+{{range .Files}}
+<h2>{{.Name}}: {{.Summary}}</h2>
+{{if .Missing}}<p>source not available, only the lines with instructions are shown</p>{{end}}
+<pre>{{range .Lines}}<span class="{{.Class}}" title="{{.Title}}"><span class="number">{{.Number}}</span>{{.Text}}</span>
+{{end}}</pre>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes a page showing the source of every file with its lines
+// colored by coverage. The sources are read from the paths in the positions,
+// relative to the working directory.
+func (p *Profile) WriteHTML(w io.Writer) error {
+	var files []htmlFile
+	for _, file := range p.Files() {
+		files = append(files, newHTMLFile(file))
+	}
+
+	return htmlTemplate.Execute(w, struct {
+		Summary string
+		Files   []htmlFile
+	}{
+		Summary: summaryText(p.Summary()),
+		Files:   files,
+	})
+}
+
+func newHTMLFile(file File) htmlFile {
+	result := htmlFile{Name: file.Name, Summary: summaryText(file.Summary())}
+
+	lines := make(map[int]Line)
+	for _, line := range file.Lines {
+		lines[line.Number] = line
+	}
+
+	var text []string
+	if data, err := os.ReadFile(file.Name); err == nil {
+		text = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	} else {
+		result.Missing = true
+		text = make([]string, file.Lines[len(file.Lines)-1].Number)
+	}
+
+	for i, s := range text {
+		line, ok := lines[i+1]
+		if result.Missing && !ok {
+			continue
+		}
+		h := htmlLine{Number: i + 1, Text: s}
+		if ok {
+			h.Class, h.Title = lineClass(line)
+		}
+		result.Lines = append(result.Lines, h)
+	}
+	return result
+}
+
+func lineClass(line Line) (string, string) {
+	title := fmt.Sprintf("executed %d times", line.Count)
+	if len(line.Missed) > 0 {
+		title += ", " + strings.Join(line.Missed, ", ")
+	}
+	switch {
+	case line.Executed == 0:
+		return "missed", "not executed"
+	case line.Covered():
+		return "covered", title
+	default:
+		return "partial", title
+	}
+}
+
+func summaryText(s Summary) string {
+	return fmt.Sprintf("lines %s, instructions %s, branches %s",
+		ratio(s.LinesCovered, s.Lines),
+		ratio(s.InstructionsCovered, s.Instructions),
+		ratio(s.BranchesCovered, s.Branches))
+}
@@ -0,0 +1,67 @@
+package cover
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteReport writes how much of every file was covered, followed by the
+// lines that did not run completely.
+func (p *Profile) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\tlines\tinstructions\tbranches")
+	files := p.Files()
+	for _, file := range files {
+		writeSummary(tw, file.Name, file.Summary())
+	}
+	writeSummary(tw, "total", p.Summary())
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var missed []string
+	for _, file := range files {
+		for _, line := range file.Lines {
+			if line.Executed == 0 {
+				missed = append(missed, fmt.Sprintf("%s:%d: not executed", file.Name, line.Number))
+				continue
+			}
+			for _, m := range line.Missed {
+				missed = append(missed, fmt.Sprintf("%s:%d: %s", file.Name, line.Number, m))
+			}
+		}
+	}
+	for _, instruction := range p.Instructions {
+		if instruction.Position.File == "" {
+			if m := instruction.missed(); m != "" {
+				missed = append(missed, fmt.Sprintf("0x%04X: %s %s", instruction.Addr, instruction.Instruction, m))
+			}
+		}
+	}
+
+	if len(missed) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "missed:")
+	}
+	for _, m := range missed {
+		if _, err := fmt.Fprintf(w, "  %s\n", m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSummary(w io.Writer, name string, s Summary) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name,
+		ratio(s.LinesCovered, s.Lines),
+		ratio(s.InstructionsCovered, s.Instructions),
+		ratio(s.BranchesCovered, s.Branches))
+}
+
+func ratio(covered int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d/%d %.1f%%", covered, total, float64(covered)*100/float64(total))
+}
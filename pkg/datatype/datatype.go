@@ -79,9 +79,9 @@ func (d DataType) ReadBytes(byteArray []byte, index int) uint64 {
 	case DWORD:
 		return uint64(binary.BigEndian.Uint32(byteArray[index : index+d.Size()]))
 	case QWORD:
-		return uint64(binary.BigEndian.Uint32(byteArray[index : index+d.Size()]))
+		return binary.BigEndian.Uint64(byteArray[index : index+d.Size()])
 	case UNSET:
-		return uint64(binary.BigEndian.Uint32(byteArray[index : index+d.Size()]))
+		return binary.BigEndian.Uint64(byteArray[index : index+d.Size()])
 	default:
 		log.Fatal("invalid data type", "type", int(d))
 		return 0
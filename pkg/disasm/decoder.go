@@ -0,0 +1,513 @@
+package disasm
+
+import (
+	"encoding/binary"
+	"fishy/pkg/ast"
+	"fishy/pkg/datatype"
+	"fishy/pkg/opcode"
+	"fishy/pkg/utils"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type form int
+
+const (
+	formNone form = iota
+	formReg
+	formLit
+	formAof
+	formRegReg
+	formRegLit
+	formRegFloat
+	formRegAdr
+	formRegAof
+	formRegRegAof
+	formAofReg
+	formAofLit
+	formCmpRegLit
+	formCmpRegReg
+	formAdr
+	formJumpReg
+)
+
+type opcodeInfo struct {
+	name string
+	form form
+}
+
+var opcodes = map[opcode.Opcode]opcodeInfo{
+	opcode.NOP:     {"nop", formNone},
+	opcode.HLT:     {"hlt", formNone},
+	opcode.BRK:     {"brk", formNone},
+	opcode.SYSCALL: {"syscall", formNone},
+	opcode.YIELD:   {"yield", formNone},
+
+	opcode.MOV_REG_REG: {"mov", formRegReg},
+	opcode.MOV_REG_LIT: {"mov", formRegLit},
+	opcode.MOV_REG_ADR: {"mov", formRegAdr},
+	opcode.MOV_REG_AOF: {"mov", formRegAof},
+	opcode.MOV_AOF_REG: {"mov", formAofReg},
+	opcode.MOV_AOF_LIT: {"mov", formAofLit},
+
+	opcode.ADD_REG_LIT: {"add", formRegLit},
+	opcode.ADD_REG_REG: {"add", formRegReg},
+	opcode.ADD_REG_AOF: {"add", formRegAof},
+	opcode.SUB_REG_LIT: {"sub", formRegLit},
+	opcode.SUB_REG_REG: {"sub", formRegReg},
+	opcode.SUB_REG_AOF: {"sub", formRegAof},
+	opcode.MUL_REG_LIT: {"mul", formRegLit},
+	opcode.MUL_REG_REG: {"mul", formRegReg},
+	opcode.MUL_REG_AOF: {"mul", formRegAof},
+	opcode.DIV_REG_LIT: {"div", formRegLit},
+	opcode.DIV_REG_REG: {"div", formRegReg},
+	opcode.DIV_REG_AOF: {"div", formRegAof},
+
+	opcode.AND_REG_LIT: {"and", formRegLit},
+	opcode.AND_REG_REG: {"and", formRegReg},
+	opcode.OR_REG_LIT:  {"or", formRegLit},
+	opcode.OR_REG_REG:  {"or", formRegReg},
+	opcode.XOR_REG_LIT: {"xor", formRegLit},
+	opcode.XOR_REG_REG: {"xor", formRegReg},
+	opcode.SHL_REG_LIT: {"shl", formRegLit},
+	opcode.SHL_REG_REG: {"shl", formRegReg},
+	opcode.SHR_REG_LIT: {"shr", formRegLit},
+	opcode.SHR_REG_REG: {"shr", formRegReg},
+
+	opcode.CMP_REG_LIT: {"cmp", formCmpRegLit},
+	opcode.CMP_REG_REG: {"cmp", formCmpRegReg},
+
+	opcode.JMP_LIT: {"jmp", formAdr},
+	opcode.JMP_REG: {"jmp", formJumpReg},
+	opcode.JEQ_LIT: {"jeq", formAdr},
+	opcode.JEQ_REG: {"jeq", formJumpReg},
+	opcode.JNE_LIT: {"jne", formAdr},
+	opcode.JNE_REG: {"jne", formJumpReg},
+	opcode.JLT_LIT: {"jlt", formAdr},
+	opcode.JLT_REG: {"jlt", formJumpReg},
+	opcode.JGT_LIT: {"jgt", formAdr},
+	opcode.JGT_REG: {"jgt", formJumpReg},
+	opcode.JLE_LIT: {"jle", formAdr},
+	opcode.JLE_REG: {"jle", formJumpReg},
+	opcode.JGE_LIT: {"jge", formAdr},
+	opcode.JGE_REG: {"jge", formJumpReg},
+
+	opcode.PUSH_LIT: {"push", formLit},
+	opcode.PUSH_REG: {"push", formReg},
+	opcode.PUSH_AOF: {"push", formAof},
+	opcode.POP_REG:  {"pop", formReg},
+	opcode.POP_AOF:  {"pop", formAof},
+
+	opcode.CALL_LIT: {"call", formAdr},
+	opcode.CALL_REG: {"call", formJumpReg},
+	opcode.CALL_AOF: {"call", formAof},
+
+	opcode.XCHG_REG_REG:    {"xchg", formRegReg},
+	opcode.XCHG_REG_AOF:    {"xchg", formRegAof},
+	opcode.XADD_REG_AOF:    {"xadd", formRegAof},
+	opcode.CAS_REG_REG_AOF: {"cas", formRegRegAof},
+	opcode.RET:             {"ret", formNone},
+
+	opcode.IMUL_REG_LIT: {"imul", formRegLit},
+	opcode.IMUL_REG_REG: {"imul", formRegReg},
+	opcode.IMUL_REG_AOF: {"imul", formRegAof},
+	opcode.IDIV_REG_LIT: {"idiv", formRegLit},
+	opcode.IDIV_REG_REG: {"idiv", formRegReg},
+	opcode.IDIV_REG_AOF: {"idiv", formRegAof},
+	opcode.SAR_REG_LIT:  {"sar", formRegLit},
+	opcode.SAR_REG_REG:  {"sar", formRegReg},
+
+	opcode.JL_LIT:  {"jl", formAdr},
+	opcode.JL_REG:  {"jl", formJumpReg},
+	opcode.JG_LIT:  {"jg", formAdr},
+	opcode.JG_REG:  {"jg", formJumpReg},
+	opcode.JNG_LIT: {"jng", formAdr},
+	opcode.JNG_REG: {"jng", formJumpReg},
+	opcode.JNL_LIT: {"jnl", formAdr},
+	opcode.JNL_REG: {"jnl", formJumpReg},
+
+	opcode.MOD_REG_LIT: {"mod", formRegLit},
+	opcode.MOD_REG_REG: {"mod", formRegReg},
+	opcode.MOD_REG_AOF: {"mod", formRegAof},
+	opcode.NEG_REG:     {"neg", formReg},
+	opcode.NEG_REG_LIT: {"neg", formRegLit},
+	opcode.NEG_REG_REG: {"neg", formRegReg},
+	opcode.NEG_REG_AOF: {"neg", formRegAof},
+	opcode.NOT_REG:     {"not", formReg},
+	opcode.NOT_REG_LIT: {"not", formRegLit},
+	opcode.NOT_REG_REG: {"not", formRegReg},
+	opcode.NOT_REG_AOF: {"not", formRegAof},
+	opcode.INC_REG:     {"inc", formReg},
+	opcode.INC_REG_LIT: {"inc", formRegLit},
+	opcode.INC_REG_REG: {"inc", formRegReg},
+	opcode.INC_REG_AOF: {"inc", formRegAof},
+	opcode.DEC_REG:     {"dec", formReg},
+	opcode.DEC_REG_LIT: {"dec", formRegLit},
+	opcode.DEC_REG_REG: {"dec", formRegReg},
+	opcode.DEC_REG_AOF: {"dec", formRegAof},
+
+	opcode.JO_LIT:  {"jo", formAdr},
+	opcode.JO_REG:  {"jo", formJumpReg},
+	opcode.JNO_LIT: {"jno", formAdr},
+	opcode.JNO_REG: {"jno", formJumpReg},
+	opcode.JS_LIT:  {"js", formAdr},
+	opcode.JS_REG:  {"js", formJumpReg},
+	opcode.JNS_LIT: {"jns", formAdr},
+	opcode.JNS_REG: {"jns", formJumpReg},
+
+	opcode.FADD_REG_LIT: {"fadd", formRegFloat},
+	opcode.FADD_REG_REG: {"fadd", formRegReg},
+	opcode.FADD_REG_AOF: {"fadd", formRegAof},
+	opcode.FSUB_REG_LIT: {"fsub", formRegFloat},
+	opcode.FSUB_REG_REG: {"fsub", formRegReg},
+	opcode.FSUB_REG_AOF: {"fsub", formRegAof},
+	opcode.FMUL_REG_LIT: {"fmul", formRegFloat},
+	opcode.FMUL_REG_REG: {"fmul", formRegReg},
+	opcode.FMUL_REG_AOF: {"fmul", formRegAof},
+	opcode.FDIV_REG_LIT: {"fdiv", formRegFloat},
+	opcode.FDIV_REG_REG: {"fdiv", formRegReg},
+	opcode.FDIV_REG_AOF: {"fdiv", formRegAof},
+	opcode.FCMP_REG_LIT: {"fcmp", formRegFloat},
+	opcode.FCMP_REG_REG: {"fcmp", formRegReg},
+	opcode.FCMP_REG_AOF: {"fcmp", formRegAof},
+	opcode.ITOF_REG:     {"itof", formReg},
+	opcode.ITOF_REG_LIT: {"itof", formRegLit},
+	opcode.ITOF_REG_REG: {"itof", formRegReg},
+	opcode.ITOF_REG_AOF: {"itof", formRegAof},
+	opcode.FTOI_REG:     {"ftoi", formReg},
+	opcode.FTOI_REG_LIT: {"ftoi", formRegFloat},
+	opcode.FTOI_REG_REG: {"ftoi", formRegReg},
+	opcode.FTOI_REG_AOF: {"ftoi", formRegAof},
+}
+
+// Instruction is a single decoded instruction. Addresses that match a known
+// label are decoded as identifiers, everything else as number literals.
+type Instruction struct {
+	Addr        uint64
+	Bytes       []byte
+	Opcode      opcode.Opcode
+	Instruction *ast.Instruction
+}
+
+type Decoder struct {
+	code   []byte
+	labels map[uint64]string
+	pos    int
+}
+
+func NewDecoder(code []byte, labels map[uint64]string) *Decoder {
+	if labels == nil {
+		labels = make(map[uint64]string)
+	}
+	return &Decoder{
+		code:   code,
+		labels: labels,
+	}
+}
+
+func (d *Decoder) Decode(addr uint64) (*Instruction, error) {
+	if addr >= uint64(len(d.code)) {
+		return nil, fmt.Errorf("address 0x%04X is out of bounds", addr)
+	}
+	d.pos = int(addr)
+
+	raw, err := d.readBytes(2)
+	if err != nil {
+		return nil, err
+	}
+	op := opcode.Opcode(binary.BigEndian.Uint16(raw))
+
+	info, ok := opcodes[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown opcode %s at 0x%04X", op.String(), addr)
+	}
+
+	instruction := &ast.Instruction{
+		Name:     info.name,
+		DataType: datatype.UNSET,
+	}
+
+	switch info.form {
+	case formNone:
+	case formReg, formLit, formAof, formRegReg, formRegLit, formRegFloat, formRegAdr, formRegAof, formRegRegAof, formAofReg, formAofLit:
+		dt, err := d.readDataType()
+		if err != nil {
+			return nil, err
+		}
+		instruction.DataType = dt
+		instruction.Args, err = d.readOperands(info.form, dt)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		instruction.Args, err = d.readOperands(info.form, datatype.QWORD)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Instruction{
+		Addr:        addr,
+		Bytes:       d.code[addr:d.pos],
+		Opcode:      op,
+		Instruction: instruction,
+	}, nil
+}
+
+func (d *Decoder) readOperands(f form, dt datatype.DataType) ([]ast.Value, error) {
+	var readers []func(datatype.DataType) (ast.Value, error)
+
+	switch f {
+	case formReg, formJumpReg:
+		readers = append(readers, d.readRegister)
+	case formLit:
+		readers = append(readers, d.readLiteral)
+	case formAof:
+		readers = append(readers, d.readAddressOf)
+	case formRegReg, formCmpRegReg:
+		readers = append(readers, d.readRegister, d.readRegister)
+	case formRegLit, formCmpRegLit:
+		readers = append(readers, d.readRegister, d.readLiteral)
+	case formRegFloat:
+		readers = append(readers, d.readRegister, d.readFloat)
+	case formRegAdr:
+		readers = append(readers, d.readRegister, d.readAddress)
+	case formRegAof:
+		readers = append(readers, d.readRegister, d.readAddressOf)
+	case formRegRegAof:
+		readers = append(readers, d.readRegister, d.readRegister, d.readAddressOf)
+	case formAofReg:
+		readers = append(readers, d.readAddressOf, d.readRegister)
+	case formAofLit:
+		readers = append(readers, d.readAddressOf, d.readLiteral)
+	case formAdr:
+		readers = append(readers, d.readAddress)
+	}
+
+	args := []ast.Value{}
+	for _, reader := range readers {
+		arg, err := reader(dt)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if d.pos+n > len(d.code) {
+		return nil, fmt.Errorf("unexpected end of bytecode at 0x%04X", d.pos)
+	}
+	b := d.code[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (d *Decoder) readDataType() (datatype.DataType, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	dt := datatype.DataType(b)
+	switch dt {
+	case datatype.BYTE, datatype.WORD, datatype.DWORD, datatype.QWORD, datatype.UNSET:
+		return dt, nil
+	default:
+		return 0, fmt.Errorf("invalid data type 0x%02X at 0x%04X", b, d.pos-1)
+	}
+}
+
+func (d *Decoder) readNumber(dt datatype.DataType) (uint64, error) {
+	if _, err := d.readBytes(dt.Size()); err != nil {
+		return 0, err
+	}
+	return dt.ReadBytes(d.code, d.pos-dt.Size()), nil
+}
+
+func (d *Decoder) readOperator() (ast.Operator, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	op := ast.Operator(b)
+	if op > ast.DIVIDE {
+		return 0, fmt.Errorf("invalid operator 0x%02X at 0x%04X", b, d.pos-1)
+	}
+	return op, nil
+}
+
+func (d *Decoder) readRegister(datatype.DataType) (ast.Value, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if int(b) >= len(utils.Registers) {
+		return nil, fmt.Errorf("invalid register 0x%02X at 0x%04X", b, d.pos-1)
+	}
+	return &ast.Register{Value: int(b)}, nil
+}
+
+func (d *Decoder) readLiteral(dt datatype.DataType) (ast.Value, error) {
+	num, err := d.readNumber(dt)
+	if err != nil {
+		return nil, err
+	}
+	if dt.Size() == 8 {
+		// the lexer only accepts qword immediates that fit in an int64
+		return &ast.NumberLiteral{Value: strconv.FormatInt(int64(num), 10)}, nil
+	}
+	return &ast.NumberLiteral{Value: strconv.FormatUint(num, 10)}, nil
+}
+
+// readFloat decodes the literal of a float instruction. Values the lexer has no
+// syntax for, like NaN and the infinities, are left as their raw bits.
+func (d *Decoder) readFloat(dt datatype.DataType) (ast.Value, error) {
+	num, err := d.readNumber(dt)
+	if err != nil {
+		return nil, err
+	}
+
+	f, bitSize := math.Float64frombits(num), 64
+	if dt == datatype.DWORD {
+		f, bitSize = float64(math.Float32frombits(uint32(num))), 32
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return &ast.NumberLiteral{Value: strconv.FormatUint(num, 10)}, nil
+	}
+
+	value := strconv.FormatFloat(f, 'g', -1, bitSize)
+	if !strings.ContainsAny(value, ".e") {
+		value += ".0"
+	}
+	return &ast.FloatLiteral{Value: value}, nil
+}
+
+func (d *Decoder) readAddress(dt datatype.DataType) (ast.Value, error) {
+	addr, err := d.readNumber(dt)
+	if err != nil {
+		return nil, err
+	}
+	return d.addressValue(addr), nil
+}
+
+func (d *Decoder) addressValue(addr uint64) ast.Value {
+	if name, ok := d.labels[addr]; ok {
+		return &ast.Identifier{Value: name}
+	}
+	return &ast.NumberLiteral{Value: strconv.FormatUint(addr, 10)}
+}
+
+// readAddressOf decodes the value index encoding understood by
+// Machine.decodeValue.
+func (d *Decoder) readAddressOf(dt datatype.DataType) (ast.Value, error) {
+	index, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch index {
+	case 2:
+		reg, err := d.readRegister(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: reg}, nil
+	case 0, 1, 3:
+		value, err := d.readLiteral(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: value}, nil
+	case 4:
+		value, err := d.readAddress(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: value}, nil
+	case 5:
+		reg, err := d.readRegister(dt)
+		if err != nil {
+			return nil, err
+		}
+		op, err := d.readOperator()
+		if err != nil {
+			return nil, err
+		}
+		offset, err := d.readLiteral(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: &ast.RegisterOffsetNumber{
+			Left:     *reg.(*ast.Register),
+			Operator: op,
+			Right:    *offset.(*ast.NumberLiteral),
+		}}, nil
+	case 6:
+		reg0, err := d.readRegister(dt)
+		if err != nil {
+			return nil, err
+		}
+		op, err := d.readOperator()
+		if err != nil {
+			return nil, err
+		}
+		reg1, err := d.readRegister(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: &ast.RegisterOffsetRegister{
+			Left:     *reg0.(*ast.Register),
+			Operator: op,
+			Right:    *reg1.(*ast.Register),
+		}}, nil
+	case 7:
+		addr, err := d.readAddress(dt)
+		if err != nil {
+			return nil, err
+		}
+		op, err := d.readOperator()
+		if err != nil {
+			return nil, err
+		}
+		offset, err := d.readLiteral(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: &ast.LabelOffsetNumber{
+			Left:     addr,
+			Operator: op,
+			Right:    *offset.(*ast.NumberLiteral),
+		}}, nil
+	case 8:
+		addr, err := d.readAddress(dt)
+		if err != nil {
+			return nil, err
+		}
+		op, err := d.readOperator()
+		if err != nil {
+			return nil, err
+		}
+		reg, err := d.readRegister(dt)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.AddressOf{Value: &ast.LabelOffsetRegister{
+			Left:     addr,
+			Operator: op,
+			Right:    *reg.(*ast.Register),
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unknown value index %d at 0x%04X", index, d.pos-1)
+	}
+}
@@ -0,0 +1,268 @@
+package disasm
+
+import (
+	"fishy/pkg/ast"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/datatype"
+	"fishy/pkg/utils"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Program is a Fishy Bytecode file with its sections laid out in the memory
+// image that the VM loads at address 0.
+type Program struct {
+	File      *bytecode.File
+	Entry     uint64
+	Symbols   map[uint64]datatype.DataType
+	DebugInfo *bytecode.DebugInfo
+	Code      []byte
+}
+
+func ParseProgram(b []byte) (*Program, error) {
+	file, err := bytecode.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := file.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	debugInfo, err := file.DebugInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Program{
+		File:      file,
+		Entry:     file.Entry,
+		Symbols:   symbols,
+		DebugInfo: debugInfo,
+		Code:      file.Image(),
+	}, nil
+}
+
+// Labels names every labelled address. The names come from the debug info
+// section if the program has one, see DebugLabelNames, and are made up by
+// LabelNames otherwise.
+func (p *Program) Labels() map[uint64]string {
+	if p.DebugInfo != nil {
+		labels := DebugLabelNames(p.DebugInfo)
+		if _, ok := labels[p.Entry]; !ok {
+			labels[p.Entry] = "_start"
+		}
+		return labels
+	}
+	return LabelNames(p.Symbols, p.Entry)
+}
+
+// DebugLabelNames names addresses by the labels kept in the debug info. When
+// several labels share an address the first in alphabetical order wins.
+func DebugLabelNames(debugInfo *bytecode.DebugInfo) map[uint64]string {
+	labels := make(map[uint64]string)
+	for name, addr := range debugInfo.Labels {
+		if existing, ok := labels[addr]; !ok || name < existing {
+			labels[addr] = name
+		}
+	}
+	return labels
+}
+
+// LabelNames names the addresses of a symbol table for a program without
+// debug info, which does not keep the original label names. The entry point
+// is called _start and the rest are named after their address.
+func LabelNames(symbols map[uint64]datatype.DataType, entry uint64) map[uint64]string {
+	labels := make(map[uint64]string)
+	for addr := range symbols {
+		labels[addr] = fmt.Sprintf("label_%04x", addr)
+	}
+	labels[entry] = "_start"
+	return labels
+}
+
+func Disassemble(w io.Writer, p *Program, showAddresses bool) error {
+	labels := p.Labels()
+	decoder := NewDecoder(p.Code, labels)
+
+	fmt.Fprintf(w, ".entry %s\n", labels[p.Entry])
+
+	for _, kind := range []bytecode.SectionKind{bytecode.SECTION_TEXT, bytecode.SECTION_RODATA, bytecode.SECTION_DATA, bytecode.SECTION_BSS} {
+		section := p.File.Section(kind)
+		if section == nil || section.Size == 0 {
+			continue
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, ".section %s\n", kind)
+
+		for _, r := range splitAtLabels(section.Addr, section.Addr+section.Size, labels) {
+			if name, ok := labels[r.start]; ok {
+				fmt.Fprintf(w, "%s:\n", name)
+			}
+
+			switch kind {
+			case bytecode.SECTION_TEXT:
+				addr := r.start
+				for addr < r.end {
+					instruction, err := decoder.Decode(addr)
+					if err != nil || addr+uint64(len(instruction.Bytes)) > r.end {
+						break
+					}
+
+					line := "    " + FormatInstruction(instruction.Instruction)
+					if showAddresses {
+						line = fmt.Sprintf("%-48s ; 0x%04X", line, instruction.Addr)
+					}
+					fmt.Fprintln(w, line)
+
+					addr += uint64(len(instruction.Bytes))
+				}
+				// anything that does not decode is kept as raw bytes
+				writeData(w, p, addr, r.end, showAddresses)
+			case bytecode.SECTION_RODATA, bytecode.SECTION_DATA:
+				writeData(w, p, r.start, r.end, showAddresses)
+			case bytecode.SECTION_BSS:
+				writeReserve(w, p, r.start, r.end, showAddresses)
+			}
+		}
+	}
+
+	return nil
+}
+
+type region struct {
+	start uint64
+	end   uint64
+}
+
+func splitAtLabels(start uint64, end uint64, labels map[uint64]string) []region {
+	starts := []uint64{start}
+	for addr := range labels {
+		if addr > start && addr < end {
+			starts = append(starts, addr)
+		}
+	}
+	slices.Sort(starts)
+
+	regions := []region{}
+	for i, s := range starts {
+		e := end
+		if i+1 < len(starts) {
+			e = starts[i+1]
+		}
+		regions = append(regions, region{start: s, end: e})
+	}
+	return regions
+}
+
+// labelDataType picks the widest data type the region can be written with.
+func (p *Program) labelDataType(start uint64, end uint64) datatype.DataType {
+	if dt, ok := p.Symbols[start]; ok && dt != datatype.UNSET && (end-start)%uint64(dt.Size()) == 0 {
+		return dt
+	}
+	return datatype.BYTE
+}
+
+func writeData(w io.Writer, p *Program, start uint64, end uint64, showAddresses bool) {
+	dt := p.labelDataType(start, end)
+	size := uint64(dt.Size())
+	sequence := map[datatype.DataType]string{
+		datatype.BYTE:  "db",
+		datatype.WORD:  "dw",
+		datatype.DWORD: "dd",
+		datatype.QWORD: "dq",
+	}[dt]
+
+	for addr := start; addr < end; addr += 16 {
+		lineEnd := min(addr+16, end)
+
+		values := []string{}
+		for i := addr; i < lineEnd; i += size {
+			value := dt.ReadBytes(p.Code, int(i))
+			if dt == datatype.QWORD {
+				// the lexer only accepts qword immediates that fit in an int64
+				values = append(values, fmt.Sprintf("%d", int64(value)))
+			} else {
+				values = append(values, fmt.Sprintf("0x%0*x", size*2, value))
+			}
+		}
+
+		line := "    " + sequence + " " + strings.Join(values, ", ")
+		if showAddresses {
+			line = fmt.Sprintf("%-48s ; 0x%04X", line, addr)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func writeReserve(w io.Writer, p *Program, start uint64, end uint64, showAddresses bool) {
+	if start == end {
+		return
+	}
+
+	dt := p.labelDataType(start, end)
+	sequence := map[datatype.DataType]string{
+		datatype.BYTE:  "resb",
+		datatype.WORD:  "resw",
+		datatype.DWORD: "resd",
+		datatype.QWORD: "resq",
+	}[dt]
+
+	line := fmt.Sprintf("    %s %d", sequence, (end-start)/uint64(dt.Size()))
+	if showAddresses {
+		line = fmt.Sprintf("%-48s ; 0x%04X", line, start)
+	}
+	fmt.Fprintln(w, line)
+}
+
+func FormatInstruction(instruction *ast.Instruction) string {
+	var sb strings.Builder
+	sb.WriteString(instruction.Name)
+
+	if instruction.DataType != datatype.UNSET {
+		sb.WriteString(" ")
+		sb.WriteString(strings.ToLower(instruction.DataType.String()))
+	}
+
+	args := []string{}
+	for _, arg := range instruction.Args {
+		args = append(args, FormatValue(arg))
+	}
+	if len(args) > 0 {
+		sb.WriteString(" ")
+		sb.WriteString(strings.Join(args, ", "))
+	}
+
+	return sb.String()
+}
+
+func FormatValue(value ast.Value) string {
+	switch v := value.(type) {
+	case *ast.NumberLiteral:
+		return v.Value
+	case *ast.FloatLiteral:
+		return v.Value
+	case *ast.StringLiteral:
+		return fmt.Sprintf("%q", v.Value)
+	case *ast.Register:
+		return utils.IndexToRegister(v.Value)
+	case *ast.Identifier:
+		return v.Value
+	case *ast.AddressOf:
+		return fmt.Sprintf("[%s]", FormatValue(v.Value))
+	case *ast.RegisterOffsetNumber:
+		return fmt.Sprintf("%s %s %s", FormatValue(&v.Left), v.Operator.String(), FormatValue(&v.Right))
+	case *ast.RegisterOffsetRegister:
+		return fmt.Sprintf("%s %s %s", FormatValue(&v.Left), v.Operator.String(), FormatValue(&v.Right))
+	case *ast.LabelOffsetNumber:
+		return fmt.Sprintf("%s %s %s", FormatValue(v.Left), v.Operator.String(), FormatValue(&v.Right))
+	case *ast.LabelOffsetRegister:
+		return fmt.Sprintf("%s %s %s", FormatValue(v.Left), v.Operator.String(), FormatValue(&v.Right))
+	}
+
+	return value.String()
+}
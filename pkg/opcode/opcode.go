@@ -67,6 +67,87 @@ const (
 
 	CALL_LIT
 	RET
+
+	IMUL_REG_LIT
+	IMUL_REG_REG
+	IMUL_REG_AOF
+	IDIV_REG_LIT
+	IDIV_REG_REG
+	IDIV_REG_AOF
+	SAR_REG_LIT
+	SAR_REG_REG
+
+	JL_LIT
+	JL_REG
+	JG_LIT
+	JG_REG
+	JNG_LIT
+	JNG_REG
+	JNL_LIT
+	JNL_REG
+
+	MOD_REG_LIT
+	MOD_REG_REG
+	MOD_REG_AOF
+
+	NEG_REG
+	NEG_REG_LIT
+	NEG_REG_REG
+	NEG_REG_AOF
+	NOT_REG
+	NOT_REG_LIT
+	NOT_REG_REG
+	NOT_REG_AOF
+	INC_REG
+	INC_REG_LIT
+	INC_REG_REG
+	INC_REG_AOF
+	DEC_REG
+	DEC_REG_LIT
+	DEC_REG_REG
+	DEC_REG_AOF
+
+	JO_LIT
+	JO_REG
+	JNO_LIT
+	JNO_REG
+	JS_LIT
+	JS_REG
+	JNS_LIT
+	JNS_REG
+
+	FADD_REG_LIT
+	FADD_REG_REG
+	FADD_REG_AOF
+	FSUB_REG_LIT
+	FSUB_REG_REG
+	FSUB_REG_AOF
+	FMUL_REG_LIT
+	FMUL_REG_REG
+	FMUL_REG_AOF
+	FDIV_REG_LIT
+	FDIV_REG_REG
+	FDIV_REG_AOF
+	FCMP_REG_LIT
+	FCMP_REG_REG
+	FCMP_REG_AOF
+	ITOF_REG
+	ITOF_REG_LIT
+	ITOF_REG_REG
+	ITOF_REG_AOF
+	FTOI_REG
+	FTOI_REG_LIT
+	FTOI_REG_REG
+	FTOI_REG_AOF
+
+	CALL_REG
+	CALL_AOF
+
+	XCHG_REG_REG
+	XCHG_REG_AOF
+	XADD_REG_AOF
+	CAS_REG_REG_AOF
+	YIELD
 )
 
 func (o Opcode) String() string {
@@ -95,18 +176,26 @@ func (o Opcode) String() string {
 		return "ADD_REG_LIT"
 	case ADD_REG_REG:
 		return "ADD_REG_REG"
+	case ADD_REG_AOF:
+		return "ADD_REG_AOF"
 	case SUB_REG_LIT:
 		return "SUB_REG_LIT"
 	case SUB_REG_REG:
 		return "SUB_REG_REG"
+	case SUB_REG_AOF:
+		return "SUB_REG_AOF"
 	case MUL_REG_LIT:
 		return "MUL_REG_LIT"
 	case MUL_REG_REG:
 		return "MUL_REG_REG"
+	case MUL_REG_AOF:
+		return "MUL_REG_AOF"
 	case DIV_REG_LIT:
 		return "DIV_REG_LIT"
 	case DIV_REG_REG:
 		return "DIV_REG_REG"
+	case DIV_REG_AOF:
+		return "DIV_REG_AOF"
 	case AND_REG_LIT:
 		return "AND_REG_LIT"
 	case AND_REG_REG:
@@ -173,6 +262,152 @@ func (o Opcode) String() string {
 		return "CALL_LIT"
 	case RET:
 		return "RET"
+	case IMUL_REG_LIT:
+		return "IMUL_REG_LIT"
+	case IMUL_REG_REG:
+		return "IMUL_REG_REG"
+	case IMUL_REG_AOF:
+		return "IMUL_REG_AOF"
+	case IDIV_REG_LIT:
+		return "IDIV_REG_LIT"
+	case IDIV_REG_REG:
+		return "IDIV_REG_REG"
+	case IDIV_REG_AOF:
+		return "IDIV_REG_AOF"
+	case SAR_REG_LIT:
+		return "SAR_REG_LIT"
+	case SAR_REG_REG:
+		return "SAR_REG_REG"
+	case JL_LIT:
+		return "JL_LIT"
+	case JL_REG:
+		return "JL_REG"
+	case JG_LIT:
+		return "JG_LIT"
+	case JG_REG:
+		return "JG_REG"
+	case JNG_LIT:
+		return "JNG_LIT"
+	case JNG_REG:
+		return "JNG_REG"
+	case JNL_LIT:
+		return "JNL_LIT"
+	case JNL_REG:
+		return "JNL_REG"
+	case MOD_REG_LIT:
+		return "MOD_REG_LIT"
+	case MOD_REG_REG:
+		return "MOD_REG_REG"
+	case MOD_REG_AOF:
+		return "MOD_REG_AOF"
+	case NEG_REG:
+		return "NEG_REG"
+	case NEG_REG_LIT:
+		return "NEG_REG_LIT"
+	case NEG_REG_REG:
+		return "NEG_REG_REG"
+	case NEG_REG_AOF:
+		return "NEG_REG_AOF"
+	case NOT_REG:
+		return "NOT_REG"
+	case NOT_REG_LIT:
+		return "NOT_REG_LIT"
+	case NOT_REG_REG:
+		return "NOT_REG_REG"
+	case NOT_REG_AOF:
+		return "NOT_REG_AOF"
+	case INC_REG:
+		return "INC_REG"
+	case INC_REG_LIT:
+		return "INC_REG_LIT"
+	case INC_REG_REG:
+		return "INC_REG_REG"
+	case INC_REG_AOF:
+		return "INC_REG_AOF"
+	case DEC_REG:
+		return "DEC_REG"
+	case DEC_REG_LIT:
+		return "DEC_REG_LIT"
+	case DEC_REG_REG:
+		return "DEC_REG_REG"
+	case DEC_REG_AOF:
+		return "DEC_REG_AOF"
+	case JO_LIT:
+		return "JO_LIT"
+	case JO_REG:
+		return "JO_REG"
+	case JNO_LIT:
+		return "JNO_LIT"
+	case JNO_REG:
+		return "JNO_REG"
+	case JS_LIT:
+		return "JS_LIT"
+	case JS_REG:
+		return "JS_REG"
+	case JNS_LIT:
+		return "JNS_LIT"
+	case JNS_REG:
+		return "JNS_REG"
+	case FADD_REG_LIT:
+		return "FADD_REG_LIT"
+	case FADD_REG_REG:
+		return "FADD_REG_REG"
+	case FADD_REG_AOF:
+		return "FADD_REG_AOF"
+	case FSUB_REG_LIT:
+		return "FSUB_REG_LIT"
+	case FSUB_REG_REG:
+		return "FSUB_REG_REG"
+	case FSUB_REG_AOF:
+		return "FSUB_REG_AOF"
+	case FMUL_REG_LIT:
+		return "FMUL_REG_LIT"
+	case FMUL_REG_REG:
+		return "FMUL_REG_REG"
+	case FMUL_REG_AOF:
+		return "FMUL_REG_AOF"
+	case FDIV_REG_LIT:
+		return "FDIV_REG_LIT"
+	case FDIV_REG_REG:
+		return "FDIV_REG_REG"
+	case FDIV_REG_AOF:
+		return "FDIV_REG_AOF"
+	case FCMP_REG_LIT:
+		return "FCMP_REG_LIT"
+	case FCMP_REG_REG:
+		return "FCMP_REG_REG"
+	case FCMP_REG_AOF:
+		return "FCMP_REG_AOF"
+	case ITOF_REG:
+		return "ITOF_REG"
+	case ITOF_REG_LIT:
+		return "ITOF_REG_LIT"
+	case ITOF_REG_REG:
+		return "ITOF_REG_REG"
+	case ITOF_REG_AOF:
+		return "ITOF_REG_AOF"
+	case FTOI_REG:
+		return "FTOI_REG"
+	case FTOI_REG_LIT:
+		return "FTOI_REG_LIT"
+	case FTOI_REG_REG:
+		return "FTOI_REG_REG"
+	case FTOI_REG_AOF:
+		return "FTOI_REG_AOF"
+	case CALL_REG:
+		return "CALL_REG"
+	case CALL_AOF:
+		return "CALL_AOF"
+	case XCHG_REG_REG:
+		return "XCHG_REG_REG"
+	case XCHG_REG_AOF:
+		return "XCHG_REG_AOF"
+	case XADD_REG_AOF:
+		return "XADD_REG_AOF"
+	case CAS_REG_REG_AOF:
+		return "CAS_REG_REG_AOF"
+	case YIELD:
+		return "YIELD"
 	default:
 		return fmt.Sprintf("0x%04X", int(o))
 	}
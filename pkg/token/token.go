@@ -9,6 +9,7 @@ const (
 	LABEL         TokenKind = "LABEL"
 	IDENTIFIER    TokenKind = "IDENTIFIER"
 	IMMEDIATE     TokenKind = "IMMEDIATE"
+	FLOAT         TokenKind = "FLOAT"
 	REGISTER      TokenKind = "REGISTER"
 	STRING        TokenKind = "STRING"
 	DATA_TYPE     TokenKind = "DATA_TYPE"
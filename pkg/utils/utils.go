@@ -8,13 +8,19 @@ var Instructions = []string{
 	"hlt",
 	"brk",
 	"syscall",
+	"yield",
 	"mov",
-	"add", "sub", "mul", "div",
-	"and", "or", "xor", "shl", "shr",
+	"add", "sub", "mul", "div", "imul", "idiv", "mod",
+	"neg", "inc", "dec",
+	"fadd", "fsub", "fmul", "fdiv", "fcmp", "itof", "ftoi",
+	"and", "or", "xor", "not", "shl", "shr", "sar",
 	"cmp",
 	"jmp", "jeq", "jne", "jlt", "jgt", "jle", "jge", "jz",
+	"jl", "jg", "jng", "jnl",
+	"jnz", "jc", "jnc", "jo", "jno", "js", "jns",
 	"push", "pop",
 	"call", "ret",
+	"xchg", "xadd", "cas",
 }
 
 var Sequences = []string{
@@ -0,0 +1,244 @@
+// Package vm embeds the FishyVM in Go programs.
+//
+// A host loads Fishy Bytecode from memory, can hand the guest its own
+// stdin/stdout/stderr and extends it with syscalls implemented in Go:
+//
+//	m, err := vm.New(program, vm.Options{Stdout: &out})
+//	m.RegisterSyscall(100, func(m *vm.Machine, thread *vm.Thread) {
+//		x0 := m.RegisterValue(thread, utils.RegisterToIndex("x0"))
+//		m.SetRegisterValue(thread, utils.RegisterToIndex("x0"), x0*2)
+//	})
+//	err = m.Run()
+package vm
+
+import (
+	"fishy/internal/vm"
+	"io"
+	"time"
+)
+
+type (
+	Machine         = vm.Machine
+	Thread          = vm.Thread
+	Fault           = vm.Fault
+	SyscallIndex    = vm.SyscallIndex
+	SyscallFunction = vm.SyscallFunction
+	ErrorCode       = vm.ErrorCode
+	Policy          = vm.Policy
+	PolicySyscall   = vm.PolicySyscall
+	Permission      = vm.Permission
+	ProtectionError = vm.ProtectionError
+	ThreadState     = vm.ThreadState
+	TraceRecord     = vm.TraceRecord
+	TraceWrite      = vm.TraceWrite
+	Profile         = vm.Profile
+	ProfileSample   = vm.ProfileSample
+	LabelCost       = vm.LabelCost
+)
+
+const (
+	SYS_EXIT            = vm.SYS_EXIT
+	SYS_OPEN            = vm.SYS_OPEN
+	SYS_READ            = vm.SYS_READ
+	SYS_WRITE           = vm.SYS_WRITE
+	SYS_CLOSE           = vm.SYS_CLOSE
+	SYS_STRERR          = vm.SYS_STRERR
+	SYS_INT_TO_STR      = vm.SYS_INT_TO_STR
+	SYS_STR_TO_INT      = vm.SYS_STR_TO_INT
+	SYS_CLOCK           = vm.SYS_CLOCK
+	SYS_NET_LISTEN_TCP  = vm.SYS_NET_LISTEN_TCP
+	SYS_NET_CONNECT_TCP = vm.SYS_NET_CONNECT_TCP
+	SYS_NET_ACCEPT      = vm.SYS_NET_ACCEPT
+	SYS_NET_GETPEERNAME = vm.SYS_NET_GETPEERNAME
+	SYS_NET_IP_TO_STR   = vm.SYS_NET_IP_TO_STR
+	SYS_THREAD_SPAWN    = vm.SYS_THREAD_SPAWN
+	SYS_THREAD_START    = vm.SYS_THREAD_START
+	SYS_THREAD_STOP     = vm.SYS_THREAD_STOP
+	SYS_THREAD_JOIN     = vm.SYS_THREAD_JOIN
+	SYS_FLOAT_TO_STR    = vm.SYS_FLOAT_TO_STR
+	SYS_STR_TO_FLOAT    = vm.SYS_STR_TO_FLOAT
+	SYS_ALLOC           = vm.SYS_ALLOC
+	SYS_FREE            = vm.SYS_FREE
+	SYS_REALLOC         = vm.SYS_REALLOC
+	SYS_MUTEX_CREATE    = vm.SYS_MUTEX_CREATE
+	SYS_MUTEX_LOCK      = vm.SYS_MUTEX_LOCK
+	SYS_MUTEX_UNLOCK    = vm.SYS_MUTEX_UNLOCK
+	SYS_COND_CREATE     = vm.SYS_COND_CREATE
+	SYS_COND_WAIT       = vm.SYS_COND_WAIT
+	SYS_COND_SIGNAL     = vm.SYS_COND_SIGNAL
+	SYS_COND_BROADCAST  = vm.SYS_COND_BROADCAST
+	SYS_THREAD_SELF     = vm.SYS_THREAD_SELF
+	SYS_THREAD_STATUS   = vm.SYS_THREAD_STATUS
+	SYS_THREAD_DETACH   = vm.SYS_THREAD_DETACH
+	SYS_THREAD_EXIT     = vm.SYS_THREAD_EXIT
+	SYS_CHAN_CREATE     = vm.SYS_CHAN_CREATE
+	SYS_CHAN_SEND       = vm.SYS_CHAN_SEND
+	SYS_CHAN_RECV       = vm.SYS_CHAN_RECV
+	SYS_CHAN_TRY_SEND   = vm.SYS_CHAN_TRY_SEND
+	SYS_CHAN_TRY_RECV   = vm.SYS_CHAN_TRY_RECV
+	SYS_CHAN_CLOSE      = vm.SYS_CHAN_CLOSE
+
+	EPERM        = vm.EPERM
+	EBADF        = vm.EBADF
+	EFAULT       = vm.EFAULT
+	EINVAL       = vm.EINVAL
+	ENOMEM       = vm.ENOMEM
+	EDOUBLEFREE  = vm.EDOUBLEFREE
+	EDEADLK      = vm.EDEADLK
+	ETHREADFAULT = vm.ETHREADFAULT
+	EAGAIN       = vm.EAGAIN
+	EPIPE        = vm.EPIPE
+	EINTR        = vm.EINTR
+
+	THREAD_NEW      = vm.THREAD_NEW
+	THREAD_RUNNING  = vm.THREAD_RUNNING
+	THREAD_FINISHED = vm.THREAD_FINISHED
+	THREAD_FAULTED  = vm.THREAD_FAULTED
+
+	PERM_READ  = vm.PERM_READ
+	PERM_WRITE = vm.PERM_WRITE
+	PERM_EXEC  = vm.PERM_EXEC
+)
+
+var (
+	LoadPolicy    = vm.LoadPolicy
+	DefaultPolicy = vm.DefaultPolicy
+
+	ErrStepLimit       = vm.ErrStepLimit
+	ErrThreadStepLimit = vm.ErrThreadStepLimit
+	ErrTimeout         = vm.ErrTimeout
+	ErrDeadlock        = vm.ErrDeadlock
+	ErrPaused          = vm.ErrPaused
+)
+
+const DefaultMemorySize = 1024 * 1024
+
+type Options struct {
+	// MemorySize defaults to DefaultMemorySize.
+	MemorySize int
+	// HeapSize is the part of the memory after the program that SYS_ALLOC
+	// hands out, StackSize the stack of the main thread. When one of them is
+	// zero it gets what the other leaves, both zero split it in half. The
+	// stacks of spawned threads are allocated from the heap.
+	HeapSize  uint64
+	StackSize uint64
+	// Stdin, Stdout and Stderr replace file descriptors 0, 1 and 2 of the
+	// guest. When nil the host process' descriptors are used.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Syscalls are registered on top of the builtin ones.
+	Syscalls map[SyscallIndex]SyscallFunction
+	// Policy sandboxes the guest when set. Host syscalls have to be listed
+	// in it as well.
+	Policy *Policy
+	// MaxSteps, MaxThreadSteps and Timeout stop the guest with a Fault
+	// wrapping ErrStepLimit, ErrThreadStepLimit or ErrTimeout. Zero means
+	// no limit.
+	MaxSteps       uint64
+	MaxThreadSteps uint64
+	Timeout        time.Duration
+	// Deterministic runs all guest threads on one goroutine, interleaved in
+	// an order that only depends on Seed.
+	Deterministic bool
+	Seed          int64
+	// Trace receives a TraceRecord as a line of JSON for every executed
+	// instruction.
+	Trace io.Writer
+	// Profile counts instructions and syscall time per ip, see
+	// Machine.Profile.
+	Profile bool
+	// Coverage counts executed instructions and conditional jumps, see
+	// Machine.Coverage.
+	Coverage bool
+	// PauseAfter pauses the machine once it executed this many instructions,
+	// Run returns ErrPaused and Machine.Snapshot can save it.
+	PauseAfter uint64
+}
+
+// New loads a Fishy Bytecode program. The machine is ready to Run.
+func New(program []byte, opts Options) (*Machine, error) {
+	memorySize := opts.MemorySize
+	if memorySize == 0 {
+		memorySize = DefaultMemorySize
+	}
+
+	m, err := vm.New(program, memorySize, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.HeapSize != 0 {
+		if err := m.SetHeapSize(opts.HeapSize); err != nil {
+			return nil, err
+		}
+	}
+	if opts.StackSize != 0 {
+		if err := m.SetStackSize(opts.StackSize); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Deterministic {
+		m.SetDeterministic(opts.Seed)
+	}
+	configure(m, opts)
+	return m, nil
+}
+
+// Restore loads a machine saved with Machine.Snapshot, Run carries it on. The
+// memory layout and the scheduling mode are the ones of the snapshot, so
+// MemorySize, HeapSize, StackSize, Deterministic and Seed are ignored.
+func Restore(snapshot []byte, opts Options) (*Machine, error) {
+	m, err := vm.Restore(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	configure(m, opts)
+	return m, nil
+}
+
+// configure applies the options that do not change the state of the guest.
+func configure(m *Machine, opts Options) {
+	if opts.Stdin != nil {
+		m.SetStdin(opts.Stdin)
+	}
+	if opts.Stdout != nil {
+		m.SetStdout(opts.Stdout)
+	}
+	if opts.Stderr != nil {
+		m.SetStderr(opts.Stderr)
+	}
+	if opts.Policy != nil {
+		m.SetPolicy(opts.Policy)
+	}
+	m.SetStepLimit(opts.MaxSteps)
+	m.SetThreadStepLimit(opts.MaxThreadSteps)
+	m.SetTimeout(opts.Timeout)
+	m.PauseAfter(opts.PauseAfter)
+	if opts.Trace != nil {
+		m.SetTrace(opts.Trace)
+	}
+	if opts.Profile {
+		m.EnableProfile()
+	}
+	if opts.Coverage {
+		m.EnableCoverage()
+	}
+	for index, fn := range opts.Syscalls {
+		m.RegisterSyscall(index, fn)
+	}
+}
+
+// Run loads and runs a program to completion. The exit status is the one the
+// program passed to SYS_EXIT, or 0 if it halted.
+func Run(program []byte, opts Options) (int, error) {
+	m, err := New(program, opts)
+	if err != nil {
+		return -1, err
+	}
+	if err := m.Run(); err != nil {
+		return -1, err
+	}
+	status, _ := m.ExitCode()
+	return status, nil
+}
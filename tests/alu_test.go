@@ -0,0 +1,33 @@
+package lexer_test
+
+import "testing"
+
+func TestUnaryAndRemainder(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "mod literal", body: "mov x0, 1234\n    mod x0, 10", expected: 4},
+		{name: "mod register", body: "mov x0, 17\n    mov x1, 5\n    mod x0, x1", expected: 2},
+		{name: "mod memory", body: "mov x0, 100\n    mod x0, [value]", expected: 16},
+		{name: "neg in place", body: "mov x0, -9\n    neg x0", expected: 9},
+		{name: "neg register", body: "mov x1, -3\n    neg x0, x1", expected: 3},
+		{name: "not", body: "mov x0, 0\n    not x0\n    and x0, 0xFF", expected: 255},
+		{name: "not literal", body: "not x0, -8", expected: 7},
+		{name: "inc", body: "mov x0, 41\n    inc x0", expected: 42},
+		{name: "inc memory", body: "inc x0, [value]", expected: 22},
+		{name: "dec", body: "mov x0, 1\n    dec x0\n    dec x0\n    neg x0", expected: 1},
+		{name: "dec literal", body: "dec byte x0, 10", expected: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := ".entry _start\n.section text\n_start:\n    " + tt.body +
+				"\n    mov x15, 1\n    syscall\n.section data\nvalue:\n    dq 21\n"
+			if status := runStatus(t, source); status != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package lexer_test
+
+import (
+	"fishy/pkg/vm"
+	"testing"
+	"time"
+)
+
+// spawnTwo runs worker on two threads and exits with [counter] once both
+// finished.
+const spawnTwo = `
+.entry _start
+.section data
+counter: dq 0
+ready:   dq 0
+lock:    dq 0
+cond:    dq 0
+.section text
+_start:
+    mov x15, 24
+    syscall
+    mov [lock], x0
+    mov x15, 27
+    syscall
+    mov [cond], x0
+
+    mov x0, worker
+    mov x1, 1024
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+
+    mov x0, worker
+    mov x1, 2048
+    mov x15, 15
+    syscall
+    mov x6, x0
+    mov x15, 16
+    syscall
+
+    mov x0, x5
+    mov x15, 18
+    syscall
+    mov x0, x6
+    mov x15, 18
+    syscall
+
+    mov x0, [counter]
+    mov x15, 1
+    syscall
+`
+
+func TestAtomics(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "xchg registers", body: "mov x0, 1\n    mov x1, 2\n    xchg x0, x1\n    mul x0, 10\n    add x0, x1", expected: 21},
+		{name: "xchg memory", body: "mov x0, 5\n    xchg x0, [value]\n    mov x1, [value]\n    mul x1, 10\n    add x0, x1", expected: 57},
+		{name: "xadd", body: "mov x0, 3\n    xadd x0, [value]\n    mov x1, [value]\n    mul x1, 10\n    add x0, x1", expected: 107},
+		{name: "cas success", body: "mov x0, 7\n    mov x1, 9\n    cas x0, x1, [value]\n    mov x0, 0\n    jne done\n    mov x0, [value]", expected: 9},
+		{name: "cas failure", body: "mov x0, 1\n    mov x1, 9\n    cas x0, x1, [value]\n    jeq done\n    mov x1, [value]\n    mul x1, 10\n    add x0, x1", expected: 77},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := ".entry _start\n.section data\nvalue: dq 7\n.section text\n_start:\n    " + tt.body + "\ndone:\n    mov x15, 1\n    syscall\n"
+			if status := runStatus(t, source); status != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
+
+func TestThreadSynchronization(t *testing.T) {
+	tests := []struct {
+		name   string
+		worker string
+	}{
+		{
+			name: "xadd",
+			worker: `
+worker:
+    mov x2, 0
+.loop:
+    mov x1, 1
+    xadd x1, [counter]
+    add x2, 1
+    cmp x2, 100
+    jne .loop
+    hlt
+`,
+		},
+		{
+			name: "mutex",
+			worker: `
+worker:
+    mov x2, 0
+.loop:
+    mov x0, [lock]
+    mov x15, 25
+    syscall
+    mov x1, [counter]
+    add x1, 1
+    mov [counter], x1
+    mov x0, [lock]
+    mov x15, 26
+    syscall
+    add x2, 1
+    cmp x2, 100
+    jne .loop
+    hlt
+`,
+		},
+		{
+			// the first worker waits until the second one marks the counter ready
+			name: "condition variable",
+			worker: `
+worker:
+    mov x0, [lock]
+    mov x15, 25
+    syscall
+    mov x1, 1
+    xadd x1, [ready]
+    cmp x1, 0
+    jne .wake
+.wait:
+    mov x0, [cond]
+    mov x1, [lock]
+    mov x15, 28
+    syscall
+    mov x1, [ready]
+    cmp x1, 2
+    jne .wait
+    mov x1, 200
+    mov [counter], x1
+    jmp .done
+.wake:
+    mov x1, 2
+    mov [ready], x1
+    mov x0, [cond]
+    mov x15, 29
+    syscall
+.done:
+    mov x0, [lock]
+    mov x15, 26
+    syscall
+    hlt
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := vm.Run(compile(t, spawnTwo+tt.worker), vm.Options{MemorySize: 8192, Timeout: 5 * time.Second})
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if status != 200 {
+				t.Fatalf("expected 200, got %d", status)
+			}
+		})
+		t.Run(tt.name+" deterministic", func(t *testing.T) {
+			for seed := int64(0); seed < 5; seed++ {
+				status, err := vm.Run(compile(t, spawnTwo+tt.worker), vm.Options{MemorySize: 8192, Timeout: 5 * time.Second, Deterministic: true, Seed: seed})
+				if err != nil {
+					t.Fatalf("seed %d: run failed: %v", seed, err)
+				}
+				if status != 200 {
+					t.Fatalf("seed %d: expected 200, got %d", seed, status)
+				}
+			}
+		})
+	}
+}
+
+func TestMutexErrors(t *testing.T) {
+	source := `
+.entry _start
+_start:
+    mov x15, 24
+    syscall
+    mov x4, x0
+    mov x15, 25
+    syscall
+    mov x0, x4
+    mov x15, 25
+    syscall
+    mov x0, er
+    mov x15, 1
+    syscall
+`
+	if status := runStatus(t, source); status != int(vm.EDEADLK) {
+		t.Fatalf("expected EDEADLK, got %d", status)
+	}
+}
@@ -0,0 +1,69 @@
+package lexer_test
+
+import (
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/internal/preprocessor"
+	"fishy/pkg/vm"
+	"io"
+	"testing"
+)
+
+// benchmarkExamples finish on their own without input, files or the network.
+// Their includes are relative to the repository root like when they are built
+// from examples/.
+var benchmarkExamples = []string{
+	"array_index",
+	"array_sum",
+	"bubble_sort",
+	"channel",
+	"counter",
+	"factorial",
+	"fib",
+	"hello",
+	"memcpy",
+	"thread",
+}
+
+func compileExample(b *testing.B, name string) []byte {
+	b.Helper()
+	pp, err := preprocessor.New("../examples/"+name+".fi", false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := pp.Process(); err != nil {
+		b.Fatal(err)
+	}
+	statements, err := parser.New(lexer.New(pp.Output())).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	program, err := compiler.New(statements).Compile()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return program
+}
+
+// BenchmarkExamples runs every example from loading to exit and reports the
+// time per executed instruction next to the time per run.
+func BenchmarkExamples(b *testing.B) {
+	for _, name := range benchmarkExamples {
+		program := compileExample(b, name)
+		b.Run(name, func(b *testing.B) {
+			steps := uint64(0)
+			for i := 0; i < b.N; i++ {
+				m, err := vm.New(program, vm.Options{MemorySize: 256 * 1024, Stdout: io.Discard})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := m.Run(); err != nil {
+					b.Fatal(err)
+				}
+				steps += m.Steps()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(steps), "ns/instr")
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package lexer_test
+
+import (
+	"bytes"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/disasm"
+	"strings"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	file := &bytecode.File{
+		Version: bytecode.Version,
+		Entry:   2,
+		Sections: []*bytecode.Section{
+			{Kind: bytecode.SECTION_TEXT, Addr: 0, Size: 4, Data: []byte{0x00, 0x00, 0x00, 0x01}},
+			{Kind: bytecode.SECTION_DATA, Addr: 4, Size: 2, Data: []byte{0xAB, 0xCD}},
+			{Kind: bytecode.SECTION_BSS, Addr: 6, Size: 32},
+		},
+	}
+
+	encoded := file.Encode()
+	decoded, err := bytecode.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Entry != file.Entry {
+		t.Fatalf("expected entry %d, got %d", file.Entry, decoded.Entry)
+	}
+	if decoded.ImageSize() != 38 {
+		t.Fatalf("expected image size 38, got %d", decoded.ImageSize())
+	}
+	if bss := decoded.Section(bytecode.SECTION_BSS); bss == nil || len(bss.Data) != 0 {
+		t.Fatalf("expected bss to take no space in the file")
+	}
+
+	image := decoded.Image()
+	if !bytes.Equal(image[:6], []byte{0x00, 0x00, 0x00, 0x01, 0xAB, 0xCD}) {
+		t.Fatalf("unexpected image %v", image[:6])
+	}
+}
+
+func TestBytecodeRejectsInvalidFiles(t *testing.T) {
+	valid := (&bytecode.File{
+		Version:  bytecode.Version,
+		Sections: []*bytecode.Section{{Kind: bytecode.SECTION_TEXT, Size: 2, Data: []byte{0x00, 0x01}}},
+	}).Encode()
+
+	badMagic := append([]byte{}, valid...)
+	badMagic[0] = 'X'
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[5] = bytecode.Version + 1
+
+	badChecksum := append([]byte{}, valid...)
+	badChecksum[len(badChecksum)-1] ^= 0xFF
+
+	wrapping := (&bytecode.File{
+		Version: bytecode.Version,
+		Sections: []*bytecode.Section{
+			{Kind: bytecode.SECTION_TEXT, Size: 2, Data: []byte{0x00, 0x01}},
+			{Kind: bytecode.SECTION_DATA, Addr: 0xFFFFFFFFFFFFFFFF, Size: 2},
+		},
+	}).Encode()
+
+	overlapping := (&bytecode.File{
+		Version: bytecode.Version,
+		Sections: []*bytecode.Section{
+			{Kind: bytecode.SECTION_TEXT, Size: 4, Data: []byte{0x00, 0x01, 0x00, 0x01}},
+			{Kind: bytecode.SECTION_BSS, Addr: 2, Size: 8},
+		},
+	}).Encode()
+
+	huge := (&bytecode.File{
+		Version: bytecode.Version,
+		Sections: []*bytecode.Section{
+			{Kind: bytecode.SECTION_TEXT, Size: 2, Data: []byte{0x00, 0x01}},
+			{Kind: bytecode.SECTION_BSS, Addr: 2, Size: 1 << 50},
+		},
+	}).Encode()
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{input: []byte("random garbage that is long enough"), expected: "not a Fishy Bytecode file"},
+		{input: badMagic, expected: "not a Fishy Bytecode file"},
+		{input: badVersion, expected: "unsupported bytecode version"},
+		{input: badChecksum, expected: "checksum mismatch"},
+		{input: wrapping, expected: "data section at 0xFFFFFFFFFFFFFFFF does not fit in memory"},
+		{input: overlapping, expected: "text section overlaps the bss section"},
+		{input: huge, expected: "more than the 1073741824 a program may use"},
+	}
+
+	for _, tt := range tests {
+		_, err := bytecode.Decode(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Fatalf("expected error containing %q, got %v", tt.expected, err)
+		}
+		// the disassembler builds the memory image of whatever decodes
+		if _, err := disasm.ParseProgram(tt.input); err == nil {
+			t.Fatalf("expected the disassembler to reject the file with %q", tt.expected)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package lexer_test
+
+import (
+	"encoding/binary"
+	"fishy/pkg/opcode"
+	"fishy/pkg/utils"
+	"fishy/pkg/vm"
+	"testing"
+)
+
+func TestIndirectCall(t *testing.T) {
+	source := `
+.entry _start
+
+.section data
+table: dq add_one, add_two, add_ten
+
+.section text
+_start:
+    mov x0, 0
+    mov x1, 16
+    call [table + x1]
+    mov x3, add_two
+    call x3
+    mov x1, table
+    call [x1]
+    mov x15, 1
+    syscall
+
+add_one:
+    add x0, 1
+    ret
+add_two:
+    add x0, 2
+    ret
+add_ten:
+    add x0, 10
+    ret
+`
+	if status := runStatus(t, source); status != 13 {
+		t.Fatalf("expected exit status 13, got %d", status)
+	}
+}
+
+func TestHostCallback(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x0, square
+    mov x1, 7
+    mov x15, 100
+    syscall
+    mov x15, 1
+    syscall
+
+square:
+    mul x0, x0
+    ret
+`)
+
+	status, err := vm.Run(program, vm.Options{
+		MemorySize: 4096,
+		Syscalls: map[vm.SyscallIndex]vm.SyscallFunction{
+			100: func(m *vm.Machine, thread *vm.Thread) {
+				fn := m.RegisterValue(thread, utils.RegisterToIndex("x0"))
+				arg := m.RegisterValue(thread, utils.RegisterToIndex("x1"))
+				result, err := m.Call(thread, fn, arg)
+				if err != nil {
+					t.Errorf("callback failed: %v", err)
+				}
+				m.SetRegisterValue(thread, utils.RegisterToIndex("x0"), result+1)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status != 50 {
+		t.Fatalf("expected exit status 50, got %d", status)
+	}
+}
+
+// TestHostCallbackThreads makes sure the threads of Call do not pile up in
+// the thread table, where the guest could see them.
+func TestHostCallbackThreads(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x0, square
+    mov x1, 3
+    mov x15, 100
+    syscall
+    mov x0, worker
+    mov x1, 0
+    mov x15, 15
+    syscall
+    mov x15, 1
+    syscall
+
+square:
+    mul x0, x0
+    ret
+worker:
+    hlt
+`)
+
+	m, err := vm.New(program, vm.Options{MemorySize: 65536})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RegisterSyscall(100, func(m *vm.Machine, thread *vm.Thread) {
+		fn := m.RegisterValue(thread, utils.RegisterToIndex("x0"))
+		arg := m.RegisterValue(thread, utils.RegisterToIndex("x1"))
+		for range 10 {
+			if _, err := m.Call(thread, fn, arg); err != nil {
+				t.Errorf("callback failed: %v", err)
+			}
+			if _, ok := m.GetThread(1); ok {
+				t.Errorf("expected the callback not to be in the thread table")
+			}
+		}
+	})
+	if err := m.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status, _ := m.ExitCode(); status != 1 {
+		t.Fatalf("expected the spawned thread to get index 1, got %d", status)
+	}
+}
+
+// TestHostPatchesCode makes sure code the host rewrites is not run from the
+// instructions decoded before.
+func TestHostPatchesCode(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x0, square
+    mov x1, 7
+    mov x15, 100
+    syscall
+    mov x15, 1
+    syscall
+
+square:
+    mul x0, x0
+    ret
+`)
+	status, err := vm.Run(program, vm.Options{
+		MemorySize: 4096,
+		Syscalls: map[vm.SyscallIndex]vm.SyscallFunction{
+			100: func(m *vm.Machine, thread *vm.Thread) {
+				fn := m.RegisterValue(thread, utils.RegisterToIndex("x0"))
+				arg := m.RegisterValue(thread, utils.RegisterToIndex("x1"))
+				squared, err := m.Call(thread, fn, arg)
+				if err != nil {
+					t.Errorf("callback failed: %v", err)
+				}
+
+				patch := binary.BigEndian.AppendUint16(nil, uint16(opcode.ADD_REG_REG))
+				if !m.WriteMemory(fn, patch) {
+					t.Errorf("failed to patch the callback")
+				}
+				doubled, err := m.Call(thread, fn, arg)
+				if err != nil {
+					t.Errorf("patched callback failed: %v", err)
+				}
+				m.SetRegisterValue(thread, utils.RegisterToIndex("x0"), squared+doubled)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status != 63 {
+		t.Fatalf("expected exit status 63, got %d", status)
+	}
+}
@@ -0,0 +1,151 @@
+package lexer_test
+
+import (
+	"errors"
+	"fishy/pkg/vm"
+	"testing"
+	"time"
+)
+
+func TestChannels(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{
+			name:     "buffered",
+			body:     "mov x0, 2\n    mov x15, 35\n    syscall\n    mov x5, x0\n    mov x1, 3\n    mov x15, 36\n    syscall\n    mov x0, x5\n    mov x1, 4\n    mov x15, 36\n    syscall\n    mov x0, x5\n    mov x15, 37\n    syscall\n    mov x4, x0\n    mul x4, 10\n    mov x0, x5\n    mov x15, 37\n    syscall\n    add x0, x4",
+			expected: 34,
+		},
+		{
+			name:     "try recv on an empty channel",
+			body:     "mov x0, 1\n    mov x15, 35\n    syscall\n    mov x15, 39\n    syscall\n    mov x0, er",
+			expected: int(vm.EAGAIN),
+		},
+		{
+			name:     "try send on a full channel",
+			body:     "mov x0, 1\n    mov x15, 35\n    syscall\n    mov x5, x0\n    mov x15, 38\n    syscall\n    mov x0, x5\n    mov x15, 38\n    syscall\n    mov x0, er",
+			expected: int(vm.EAGAIN),
+		},
+		{
+			name:     "try send without a receiver",
+			body:     "mov x0, 0\n    mov x15, 35\n    syscall\n    mov x15, 38\n    syscall\n    mov x0, er",
+			expected: int(vm.EAGAIN),
+		},
+		{
+			name:     "send on a closed channel",
+			body:     "mov x0, 1\n    mov x15, 35\n    syscall\n    mov x5, x0\n    mov x15, 40\n    syscall\n    mov x0, x5\n    mov x15, 36\n    syscall\n    mov x0, er",
+			expected: int(vm.EPIPE),
+		},
+		{
+			// the value sent before closing is still received, then x1 is 0
+			name:     "recv on a closed channel",
+			body:     "mov x0, 1\n    mov x15, 35\n    syscall\n    mov x5, x0\n    mov x1, 6\n    mov x15, 36\n    syscall\n    mov x0, x5\n    mov x15, 40\n    syscall\n    mov x0, x5\n    mov x15, 37\n    syscall\n    mov x4, x0\n    mul x4, 10\n    mov x0, x5\n    mov x15, 37\n    syscall\n    add x4, x1\n    mov x0, x4",
+			expected: 60,
+		},
+		{
+			name:     "close twice",
+			body:     "mov x0, 1\n    mov x15, 35\n    syscall\n    mov x5, x0\n    mov x15, 40\n    syscall\n    mov x0, x5\n    mov x15, 40\n    syscall\n    mov x0, er",
+			expected: int(vm.EINVAL),
+		},
+	}
+
+	for _, tt := range tests {
+		source := ".entry _start\n.section text\n_start:\n    " + tt.body + "\n    mov x15, 1\n    syscall\n"
+		for _, deterministic := range []bool{false, true} {
+			status, err := vm.Run(compile(t, source), vm.Options{MemorySize: 4096, Deterministic: deterministic})
+			if err != nil {
+				t.Fatalf("%s: run failed: %v", tt.name, err)
+			}
+			if status != tt.expected {
+				t.Fatalf("%s (deterministic %v): expected %d, got %d", tt.name, deterministic, tt.expected, status)
+			}
+		}
+	}
+}
+
+func TestChannelProducerConsumer(t *testing.T) {
+	for _, capacity := range []string{"0", "3"} {
+		// the producer sends 1 to 10 and closes, main adds up what it receives
+		source := `
+.entry _start
+.section data
+channel: dq 0
+.section text
+_start:
+    mov x0, ` + capacity + `
+    mov x15, 35
+    syscall
+    mov [channel], x0
+    mov x0, producer
+    mov x1, 256
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    mov x4, 0
+.receive:
+    mov x0, [channel]
+    mov x15, 37
+    syscall
+    cmp x1, 0
+    jeq .closed
+    add x4, x0
+    jmp .receive
+.closed:
+    mov x0, x4
+    mov x15, 1
+    syscall
+producer:
+    mov x4, 1
+.send:
+    mov x0, [channel]
+    mov x1, x4
+    mov x15, 36
+    syscall
+    add x4, 1
+    cmp x4, 11
+    jne .send
+    mov x0, [channel]
+    mov x15, 40
+    syscall
+    hlt
+`
+		program := compile(t, source)
+		status, err := vm.Run(program, vm.Options{MemorySize: 8192, Timeout: 5 * time.Second})
+		if err != nil || status != 55 {
+			t.Fatalf("capacity %s: expected 55, got %d (%v)", capacity, status, err)
+		}
+		for seed := int64(0); seed < 5; seed++ {
+			status, err := vm.Run(program, vm.Options{MemorySize: 8192, Timeout: 5 * time.Second, Deterministic: true, Seed: seed})
+			if err != nil || status != 55 {
+				t.Fatalf("capacity %s, seed %d: expected 55, got %d (%v)", capacity, seed, status, err)
+			}
+		}
+	}
+}
+
+func TestChannelBlocksForever(t *testing.T) {
+	source := `
+.entry _start
+.section text
+_start:
+    mov x0, 0
+    mov x15, 35
+    syscall
+    mov x15, 37
+    syscall
+    hlt
+`
+	_, err := vm.Run(compile(t, source), vm.Options{MemorySize: 4096, Timeout: 100 * time.Millisecond})
+	if !errors.Is(err, vm.ErrTimeout) {
+		t.Fatalf("expected the timeout to interrupt the receive, got %v", err)
+	}
+
+	_, err = vm.Run(compile(t, source), vm.Options{MemorySize: 4096, Deterministic: true})
+	if !errors.Is(err, vm.ErrDeadlock) {
+		t.Fatalf("expected a deadlock, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package lexer_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// fishy builds the fishy binary into dir and returns a function running it
+// there.
+func fishy(t *testing.T, dir string) func(args ...string) ([]byte, error) {
+	t.Helper()
+	binary := filepath.Join(dir, "fishy")
+	build := exec.Command("go", "build", "-o", binary, "..")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building fishy failed: %v\n%s", err, output)
+	}
+	return func(args ...string) ([]byte, error) {
+		cmd := exec.Command(binary, args...)
+		cmd.Dir = dir
+		return cmd.CombinedOutput()
+	}
+}
+
+// compileFile builds source with fishy build into prog.fbc in dir.
+func compileFile(t *testing.T, run func(args ...string) ([]byte, error), dir string, source string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, "prog.fi"), []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := run("build", "prog.fi", "-o", "prog.fbc"); err != nil {
+		t.Fatalf("fishy build failed: %v\n%s", err, output)
+	}
+}
+
+func TestRunFileFlags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the fishy binary")
+	}
+	dir := t.TempDir()
+	run := fishy(t, dir)
+	compileFile(t, run, dir, `.entry _start
+_start:
+    mov x0, 1
+    hlt
+`)
+
+	for _, flag := range []string{"--coverage", "--profile", "--trace"} {
+		path := filepath.Join(dir, flag[2:]+".out")
+		// the file is the next argument, not the program
+		if output, err := run("run", flag, path, "prog.fbc"); err != nil {
+			t.Fatalf("fishy run %s %s prog.fbc failed: %v\n%s", flag, path, err, output)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Fatalf("expected %s to write %s, got %v", flag, path, err)
+		}
+	}
+}
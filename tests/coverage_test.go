@@ -0,0 +1,117 @@
+package lexer_test
+
+import (
+	"bytes"
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/pkg/cover"
+	"fishy/pkg/vm"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	source := `.entry _start
+.section text
+_start:
+    mov x0, 0
+.loop:
+    add x0, 1
+    cmp x0, 5
+    jne .loop
+    cmp x0, 100
+    jeq never
+    hlt
+never:
+    mov x1, 2
+    hlt
+`
+	path := filepath.Join(t.TempDir(), "cover.fi")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	statements, err := parser.New(lexer.New(source)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compiler.New(statements)
+	c.SetSource(source, compiler.SourcePositions(path, source))
+	program, err := c.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := vm.New(program, vm.Options{MemorySize: 4096, Coverage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	profile := m.Coverage()
+
+	if len(profile.Instructions) != 9 {
+		t.Fatalf("expected 9 instructions, got %+v", profile.Instructions)
+	}
+	for _, instruction := range profile.Instructions {
+		switch instruction.Instruction {
+		case "jne .loop":
+			if !instruction.Branch || instruction.Taken != 4 || instruction.NotTaken != 1 {
+				t.Fatalf("expected jne to be taken 4 times and not taken once, got %+v", instruction)
+			}
+		case "jeq never":
+			if instruction.Taken != 0 || instruction.NotTaken != 1 {
+				t.Fatalf("expected jeq to never be taken, got %+v", instruction)
+			}
+		case "mov x1, 2":
+			if instruction.Count != 0 || instruction.Position.Line != 13 {
+				t.Fatalf("expected line 13 not to run, got %+v", instruction)
+			}
+		}
+	}
+
+	summary := profile.Summary()
+	expected := cover.Summary{
+		Lines: 9, LinesCovered: 6,
+		Instructions: 9, InstructionsCovered: 7,
+		Branches: 4, BranchesCovered: 3,
+	}
+	if summary != expected {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+
+	var out bytes.Buffer
+	if err := profile.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := cover.Parse(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(profile, parsed) {
+		t.Fatalf("expected the coverage file to round trip, got %+v", parsed)
+	}
+
+	var report strings.Builder
+	if err := profile.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	for _, missed := range []string{path + ":10: jeq never never taken", path + ":13: not executed"} {
+		if !strings.Contains(report.String(), missed) {
+			t.Fatalf("expected the report to contain %q:\n%s", missed, report.String())
+		}
+	}
+
+	var html strings.Builder
+	if err := profile.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), `<span class="missed" title="not executed"><span class="number">13</span>    mov x1, 2</span>`) {
+		t.Fatalf("expected line 13 to be marked as missed:\n%s", html.String())
+	}
+}
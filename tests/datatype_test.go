@@ -0,0 +1,70 @@
+package lexer_test
+
+import (
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/internal/vm"
+	"fishy/pkg/datatype"
+	"fishy/pkg/utils"
+	"testing"
+)
+
+func TestReadBytes(t *testing.T) {
+	code := []byte{0xFF, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+
+	tests := []struct {
+		dt       datatype.DataType
+		expected uint64
+	}{
+		{dt: datatype.BYTE, expected: 0x11},
+		{dt: datatype.WORD, expected: 0x1122},
+		{dt: datatype.DWORD, expected: 0x11223344},
+		{dt: datatype.QWORD, expected: 0x1122334455667788},
+		{dt: datatype.UNSET, expected: 0x1122334455667788},
+	}
+
+	for _, tt := range tests {
+		if value := tt.dt.ReadBytes(code, 1); value != tt.expected {
+			t.Errorf("%s: expected 0x%X, got 0x%X", tt.dt, tt.expected, value)
+		}
+	}
+}
+
+// TestPushLiteralWidth makes sure a pushed literal takes as many bytes in the
+// code as its data type, the instructions after it decode correctly.
+func TestPushLiteralWidth(t *testing.T) {
+	source := `
+.entry _start
+_start:
+    push byte 7
+    push word 200
+    push dword 30
+    pop dword x2
+    pop word x1
+    pop byte x0
+    add x0, x1
+    add x0, x2
+    hlt
+`
+	statements, err := parser.New(lexer.New(source)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := compiler.New(statements).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := vm.New(program, 4096, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	mainThread, _ := m.GetThread(0)
+	if x0 := m.RegisterValue(mainThread, utils.RegisterToIndex("x0")); x0 != 237 {
+		t.Fatalf("expected x0 = 237, got %d", x0)
+	}
+}
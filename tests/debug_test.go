@@ -0,0 +1,109 @@
+package lexer_test
+
+import (
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/internal/vm"
+	"fishy/pkg/utils"
+	"testing"
+)
+
+const debugSource = `
+.entry _start
+_start:
+    mov x0, 1
+first:
+    call add_two
+second:
+    call add_two
+back:
+    add x0, 5
+stop:
+    brk
+    hlt
+
+add_two:
+    add x0, 2
+    ret
+`
+
+type breakEvent struct {
+	reason vm.BreakReason
+	label  string
+}
+
+// TestDebugger drives a BreakHandler like fishy debug does. It steps into the
+// first call, steps over the second one with a breakpoint after it like a
+// debugger's next and continues to the end.
+func TestDebugger(t *testing.T) {
+	statements, err := parser.New(lexer.New(debugSource)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compiler.New(statements)
+	program, err := c.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := vm.New(program, 4096, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := c.Labels()
+	names := map[uint64]string{}
+	for name, addr := range labels {
+		names[addr] = name
+	}
+
+	var events []breakEvent
+	m.SetBreakpoint(labels["first"])
+	m.SetBreakHandler(func(m *vm.Machine, thread *vm.Thread, reason vm.BreakReason) {
+		ip := m.RegisterValue(thread, utils.RegisterToIndex("ip"))
+		name, ok := names[ip]
+		if !ok {
+			// the only instruction without a label it stops at
+			name = "ret"
+		}
+		events = append(events, breakEvent{reason, name})
+
+		switch name {
+		case "first":
+			m.SetStepping(thread, true)
+		case "second":
+			m.SetBreakpoint(labels["back"])
+			m.SetStepping(thread, false)
+		case "back":
+			m.ClearBreakpoint(labels["back"])
+		}
+	})
+
+	if err := m.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	expected := []breakEvent{
+		{vm.BREAK_BREAKPOINT, "first"},
+		{vm.BREAK_STEP, "add_two"},
+		{vm.BREAK_STEP, "ret"},
+		{vm.BREAK_STEP, "second"},
+		{vm.BREAK_BREAKPOINT, "back"},
+		{vm.BREAK_INSTRUCTION, "stop"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected the breaks %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected the breaks %v, got %v", expected, events)
+		}
+	}
+	mainThread, _ := m.GetThread(0)
+	if x0 := m.RegisterValue(mainThread, utils.RegisterToIndex("x0")); x0 != 10 {
+		t.Fatalf("expected the program to run to the end with x0 = 10, got %d", x0)
+	}
+	if breakpoints := m.Breakpoints(); len(breakpoints) != 1 || breakpoints[0] != labels["first"] {
+		t.Fatalf("expected only the breakpoint at first to be left, got %v", breakpoints)
+	}
+}
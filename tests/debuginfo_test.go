@@ -0,0 +1,103 @@
+package lexer_test
+
+import (
+	"errors"
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/internal/preprocessor"
+	"fishy/pkg/bytecode"
+	"fishy/pkg/vm"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDebugInfoEncoding(t *testing.T) {
+	info := &bytecode.DebugInfo{
+		Labels: map[string]uint64{"_start": 0, "work": 0x18},
+		Lines: []bytecode.LineEntry{
+			{Addr: 0x00, Position: bytecode.Position{File: "main.fi", Line: 5, Column: 5}},
+			{Addr: 0x0C, Position: bytecode.Position{File: "inc.fi", Line: 2, Column: 1}},
+			{Addr: 0x18, Position: bytecode.Position{File: "main.fi", Line: 9, Column: 5}},
+		},
+	}
+
+	decoded, err := bytecode.DecodeDebugInfo(info.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info, decoded) {
+		t.Fatalf("expected %+v, got %+v", info, decoded)
+	}
+
+	if position, ok := decoded.Lookup(0x14); !ok || position.String() != "inc.fi:2:1" {
+		t.Fatalf("expected 0x14 to belong to inc.fi:2:1, got %v", position)
+	}
+
+	encoded := info.Encode()
+	if _, err := bytecode.DecodeDebugInfo(encoded[:len(encoded)-1]); err == nil {
+		t.Fatal("expected truncated debug info to fail")
+	}
+}
+
+func TestFaultSource(t *testing.T) {
+	dir := t.TempDir()
+	include := filepath.Join(dir, "inc.fi")
+	main := filepath.Join(dir, "main.fi")
+
+	files := map[string]string{
+		include: "#macro divide a b\n    mov x1, b\n    div a, x1\n#end\n",
+		main: "#include \"" + include + "\"\n" +
+			".entry _start\n" +
+			".section text\n" +
+			"_start:\n" +
+			"    mov x0, 10\n" +
+			"    call work\n" +
+			"    hlt\n" +
+			"work:\n" +
+			"    divide x0, 0\n" +
+			"    ret\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pp, err := preprocessor.New(main, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pp.Process(); err != nil {
+		t.Fatal(err)
+	}
+	statements, err := parser.New(lexer.New(pp.Output())).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compiler.New(statements)
+	c.SetSource(pp.Output(), pp.Positions())
+	program, err := c.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := vm.New(program, vm.Options{MemorySize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := m.DebugInfo(); info == nil || info.Labels["work"] != 0x18 {
+		t.Fatalf("expected the debug info to name work, got %+v", info)
+	}
+
+	var fault *vm.Fault
+	if err := m.Run(); !errors.As(err, &fault) {
+		t.Fatalf("expected a fault, got %v", err)
+	}
+	expected := bytecode.Position{File: main, Line: 9, Column: 5}
+	if fault.Source == nil || *fault.Source != expected {
+		t.Fatalf("expected the fault at %v, got %v", expected, fault.Source)
+	}
+}
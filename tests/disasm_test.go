@@ -0,0 +1,158 @@
+package lexer_test
+
+import (
+	"bytes"
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"fishy/pkg/disasm"
+	"strings"
+	"testing"
+)
+
+func compile(t *testing.T, source string) []byte {
+	t.Helper()
+	p := parser.New(lexer.New(source))
+	statements, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	bytecode, err := compiler.New(statements).Compile()
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	return bytecode
+}
+
+func TestDecodeInstruction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "mov x0, x1", expected: "mov x0, x1"},
+		{input: "mov byte x15, 4", expected: "mov byte x15, 4"},
+		{input: "mov word x4, [x0 + x2]", expected: "mov word x4, [x0 + x2]"},
+		{input: "mov [x1 - 8], x3", expected: "mov [x1 - 8], x3"},
+		{input: "add x0, [x2]", expected: "add x0, [x2]"},
+		{input: "cmp x3, 10", expected: "cmp x3, 10"},
+		{input: "push byte 5", expected: "push byte 5"},
+		{input: "pop dword [x5]", expected: "pop dword [x5]"},
+		{input: "jmp x4", expected: "jmp x4"},
+		{input: "syscall", expected: "syscall"},
+		{input: "idiv x1, [x2]", expected: "idiv x1, [x2]"},
+		{input: "sar x3, 4", expected: "sar x3, 4"},
+		{input: "jng x2", expected: "jng x2"},
+		{input: "neg x3", expected: "neg x3"},
+		{input: "mod word x1, [x2 + 4]", expected: "mod word x1, [x2 + 4]"},
+		{input: "fadd x0, 1.5", expected: "fadd x0, 1.5"},
+		{input: "fmul dword x2, -2.0", expected: "fmul dword x2, -2.0"},
+		{input: "itof x1, 3", expected: "itof x1, 3"},
+		{input: "call x3", expected: "call x3"},
+		{input: "call [x2 + x1]", expected: "call [x2 + x1]"},
+		{input: "xadd x1, [x2]", expected: "xadd x1, [x2]"},
+		{input: "cas dword x0, x1, [x2 + 8]", expected: "cas dword x0, x1, [x2 + 8]"},
+		{input: "yield", expected: "yield"},
+	}
+
+	for _, tt := range tests {
+		program, err := disasm.ParseProgram(compile(t, "_start:\n"+tt.input))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		instruction, err := disasm.NewDecoder(program.Code, nil).Decode(0)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if got := disasm.FormatInstruction(instruction.Instruction); got != tt.expected {
+			t.Fatalf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	source := `
+.section data
+array:      dw 1, 2, 3, 4, 5
+array_len:  db 5
+
+.section text
+_start:
+    mov x0, array
+    mov x1, [array_len]
+    call sum
+    hlt
+
+sum:
+.loop:
+    mov word x4, [x0]
+    cmp x2, x1
+    jeq .done
+    add x3, x4
+    add x0, 2
+    jmp .loop
+.done:
+    ret
+`
+	original := compile(t, source)
+
+	program, err := disasm.ParseProgram(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := disasm.Disassemble(&out, program, false); err != nil {
+		t.Fatal(err)
+	}
+
+	recompiled := compile(t, out.String())
+	if !bytes.Equal(original, recompiled) {
+		t.Fatalf("round trip produced different bytecode:\n%s", out.String())
+	}
+}
+
+func TestDisassembleDebugLabels(t *testing.T) {
+	source := `.entry main
+.section data
+total: dq 0
+
+.section text
+main:
+    call sum
+    mov [total], x0
+    hlt
+
+sum:
+    mov x0, 3
+    ret
+`
+	statements, err := parser.New(lexer.New(source)).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compiler.New(statements)
+	c.SetSource(source, compiler.SourcePositions("main.fi", source))
+	original, err := c.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	program, err := disasm.ParseProgram(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := disasm.Disassemble(&out, program, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{".entry main", "main:", "    call sum", "    mov [total], x0", "sum:", "total:"} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("expected %q in the disassembly:\n%s", line, out.String())
+		}
+	}
+
+	// the same program without the debug info section
+	if recompiled := compile(t, out.String()); !bytes.Equal(recompiled, compile(t, source)) {
+		t.Fatalf("round trip produced different bytecode:\n%s", out.String())
+	}
+}
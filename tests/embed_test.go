@@ -0,0 +1,82 @@
+package lexer_test
+
+import (
+	"bytes"
+	"fishy/pkg/utils"
+	"fishy/pkg/vm"
+	"strings"
+	"testing"
+)
+
+func TestEmbedHostSyscall(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x0, 21
+    mov x15, 100
+    syscall
+
+    mov x15, 1
+    syscall
+`)
+
+	status, err := vm.Run(program, vm.Options{
+		Syscalls: map[vm.SyscallIndex]vm.SyscallFunction{
+			100: func(m *vm.Machine, thread *vm.Thread) {
+				x0 := m.RegisterValue(thread, utils.RegisterToIndex("x0"))
+				m.SetRegisterValue(thread, utils.RegisterToIndex("x0"), x0*2)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status != 42 {
+		t.Fatalf("expected exit status 42, got %d", status)
+	}
+}
+
+func TestEmbedStdio(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x0, 0
+    mov x1, buffer
+    mov x2, 5
+    mov x15, 3
+    syscall
+
+    mov x2, x0
+    mov x0, 1
+    mov x1, buffer
+    mov x15, 4
+    syscall
+
+    mov x0, 7
+    mov x15, 1
+    syscall
+
+.section bss
+buffer:
+    resb 16
+`)
+
+	var stdout bytes.Buffer
+	status, err := vm.Run(program, vm.Options{
+		Stdin:  strings.NewReader("fishy business"),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status != 7 {
+		t.Errorf("expected exit status 7, got %d", status)
+	}
+	if stdout.String() != "fishy" {
+		t.Errorf("expected stdout %q, got %q", "fishy", stdout.String())
+	}
+}
@@ -0,0 +1,38 @@
+package lexer_test
+
+import "testing"
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "zero after sub", body: "mov x1, 5\n    sub x1, 5\n    jz done\n    mov x0, 1", expected: 0},
+		{name: "not zero", body: "mov x1, 5\n    sub x1, 4\n    jnz done\n    mov x0, 1", expected: 0},
+		{name: "carry on add", body: "mov x1, -1\n    add x1, 1\n    jc done\n    mov x0, 1", expected: 0},
+		{name: "no carry", body: "mov x1, 1\n    add x1, 1\n    jnc done\n    mov x0, 1", expected: 0},
+		{name: "borrow on sub", body: "mov x1, 0\n    sub x1, 1\n    jc done\n    mov x0, 1", expected: 0},
+		{name: "signed overflow", body: "mov x1, 0x7FFFFFFFFFFFFFFF\n    add x1, 1\n    jo done\n    mov x0, 1", expected: 0},
+		{name: "no overflow", body: "mov x1, -1\n    add x1, 1\n    jno done\n    mov x0, 1", expected: 0},
+		{name: "sign", body: "mov x1, 3\n    dec x1, 0\n    js done\n    mov x0, 1", expected: 0},
+		{name: "imul overflow", body: "mov x1, 0x4000000000000000\n    imul x1, 2\n    jo done\n    mov x0, 1", expected: 0},
+		{name: "logic clears carry", body: "mov x1, -1\n    add x1, 1\n    or x1, 1\n    jnc done\n    mov x0, 1", expected: 0},
+		{name: "shift carry", body: "mov x1, 3\n    shr x1, 1\n    jc done\n    mov x0, 1", expected: 0},
+		{name: "cmp still works", body: "mov x1, 2\n    cmp x1, 3\n    jlt done\n    mov x0, 1", expected: 0},
+		{
+			name:     "128 bit add",
+			body:     "mov x1, -1\n    mov x2, 0\n    add x1, 1\n    jnc nocarry\n    inc x2\nnocarry:\n    mov x0, x2",
+			expected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := ".entry _start\n_start:\n    mov x0, 0\n    " + tt.body + "\ndone:\n    mov x15, 1\n    syscall\n"
+			if status := runStatus(t, source); status != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package lexer_test
+
+import (
+	"fishy/internal/compiler"
+	"fishy/internal/lexer"
+	"fishy/internal/parser"
+	"strings"
+	"testing"
+)
+
+func TestFloatingPoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "fadd", body: "mov x0, 1.25\n    fadd x0, 2.75\n    ftoi x0", expected: 4},
+		{name: "fdiv", body: "itof x0, 7\n    fdiv x0, 2.0\n    fmul x0, 10.0\n    ftoi x0", expected: 35},
+		{name: "ftoi truncates", body: "mov x0, -2.9\n    ftoi x0\n    add x0, 10", expected: 8},
+		{name: "single precision", body: "itof dword x0, 3\n    fmul dword x0, 0.5\n    fadd dword x0, [half]\n    ftoi dword x0", expected: 2},
+		{name: "double from memory", body: "mov x0, [value]\n    fsub x0, 0.5\n    ftoi x0", expected: 12},
+		{name: "fcmp less", body: "mov x0, 1.5\n    fcmp x0, 2.5\n    mov x0, 1\n    jlt done\n    mov x0, 2", expected: 1},
+		{name: "fcmp equal", body: "mov x0, 0.1\n    fadd x0, 0.2\n    fcmp x0, 0.3\n    mov x0, 1\n    jeq done\n    mov x0, 2", expected: 2},
+		{name: "integer literal", body: "mov x0, 1.5\n    fadd x0, 2\n    fmul x0, 4\n    ftoi x0", expected: 14},
+		{name: "single precision integer literal", body: "itof dword x0, 3\n    fsub dword x0, 1\n    ftoi dword x0", expected: 2},
+		{name: "fcmp integer literal", body: "mov x0, 2.0\n    fcmp x0, 2\n    mov x0, 1\n    jeq done\n    mov x0, 2", expected: 1},
+		{name: "fcmp nan", body: "mov x0, 0.0\n    fdiv x0, 0.0\n    fcmp x0, 1.0\n    mov x0, 1\n    jo done\n    mov x0, 2", expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := ".entry _start\n.section data\nhalf: dd 0.5\nvalue: dq 12.5\n.section text\n_start:\n    " + tt.body + "\ndone:\n    mov x15, 1\n    syscall\n"
+			if status := runStatus(t, source); status != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
+
+func TestFloatLiteralWidth(t *testing.T) {
+	tests := []string{
+		"mov byte x0, 1.5",
+		"mov word [x1], 1.5",
+		"push word 2.5",
+		"fadd byte x0, 0.5",
+		"fadd word x0, 2",
+	}
+
+	for _, input := range tests {
+		statements, err := parser.New(lexer.New(input)).Parse()
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		_, err = compiler.New(statements).Compile()
+		if err == nil || !strings.Contains(err.Error(), "does not fit in a") {
+			t.Errorf("%s: expected the float not to fit, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package lexer_test
+
+import (
+	"fishy/pkg/vm"
+	"testing"
+)
+
+func runHeap(t *testing.T, body string, heapSize uint64) int {
+	t.Helper()
+	source := ".entry _start\n_start:\n" + body + "\n    mov x15, 1\n    syscall\n"
+	status, err := vm.Run(compile(t, source), vm.Options{MemorySize: 4096, HeapSize: heapSize})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	return status
+}
+
+func TestHeapAllocator(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		heapSize uint64
+		expected int
+	}{
+		{
+			name: "realloc keeps contents",
+			body: `
+    mov x0, 16
+    mov x15, 21
+    syscall
+    mov [x0], 40
+    mov x3, x0
+    mov x0, 8
+    mov x15, 21
+    syscall
+    mov x0, x3
+    mov x1, 64
+    mov x15, 23
+    syscall
+    sub x3, x0
+    mov x1, [x0]
+    mov x0, x1
+    add x0, 2
+    cmp x3, 0
+    jne done
+    mov x0, 0
+done:`,
+			expected: 42,
+		},
+		{
+			name: "free reuses memory",
+			body: `
+    mov x0, 32
+    mov x15, 21
+    syscall
+    mov x4, x0
+    mov x15, 22
+    syscall
+    mov x0, 32
+    mov x15, 21
+    syscall
+    sub x0, x4`,
+			expected: 0,
+		},
+		{
+			name: "double free",
+			body: `
+    mov x0, 8
+    mov x15, 21
+    syscall
+    mov x4, x0
+    mov x15, 22
+    syscall
+    mov x0, x4
+    mov x15, 22
+    syscall
+    mov x0, er`,
+			expected: int(vm.EDOUBLEFREE),
+		},
+		{
+			name: "out of memory",
+			body: `
+    mov x0, 128
+    mov x15, 21
+    syscall
+    mov x4, x0
+    mov x0, er`,
+			heapSize: 64,
+			expected: int(vm.ENOMEM),
+		},
+		{
+			name: "size overflows",
+			body: `
+    mov x0, -1
+    mov x15, 21
+    syscall
+    mov x0, er`,
+			expected: int(vm.ENOMEM),
+		},
+		{
+			name: "failed alloc does not overlap",
+			body: `
+    mov x0, -1
+    mov x15, 21
+    syscall
+    mov x4, x0
+    mov x0, 16
+    mov x15, 21
+    syscall
+    cmp x0, x4
+    mov x0, 0
+    jne done
+    mov x0, 1
+done:`,
+			expected: 0,
+		},
+		{
+			name: "realloc size overflows",
+			body: `
+    mov x0, 8
+    mov x15, 21
+    syscall
+    mov x1, -1
+    mov x15, 23
+    syscall
+    mov x0, er`,
+			expected: int(vm.ENOMEM),
+		},
+		{
+			name: "thread stack overflows",
+			body: `
+    mov x0, 0
+    mov x1, -8
+    mov x15, 15
+    syscall
+    mov x0, er`,
+			expected: int(vm.ENOMEM),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := runHeap(t, tt.body, tt.heapSize); status != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
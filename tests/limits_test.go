@@ -0,0 +1,95 @@
+package lexer_test
+
+import (
+	"errors"
+	"fishy/pkg/opcode"
+	"fishy/pkg/vm"
+	"testing"
+	"time"
+)
+
+const loopProgram = `
+.entry _start
+
+.section text
+_start:
+    add x0, 1
+    jmp _start
+`
+
+func TestStepLimit(t *testing.T) {
+	m, err := vm.New(compile(t, loopProgram), vm.Options{MaxSteps: 1000})
+	if err != nil {
+		t.Fatalf("failed to load program: %v", err)
+	}
+
+	err = m.Run()
+	if !errors.Is(err, vm.ErrStepLimit) {
+		t.Fatalf("expected step limit fault, got %v", err)
+	}
+	if m.Steps() != 1001 {
+		t.Errorf("expected 1001 steps, got %d", m.Steps())
+	}
+}
+
+func TestThreadStepLimit(t *testing.T) {
+	_, err := vm.Run(compile(t, loopProgram), vm.Options{MaxThreadSteps: 10})
+	if !errors.Is(err, vm.ErrThreadStepLimit) {
+		t.Fatalf("expected thread step limit fault, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := vm.Run(compile(t, loopProgram), vm.Options{Timeout: 50 * time.Millisecond})
+	if !errors.Is(err, vm.ErrTimeout) {
+		t.Fatalf("expected timeout fault, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timeout took %s to stop the program", elapsed)
+	}
+}
+
+// blockingReader never returns, like stdin nobody types into.
+type blockingReader struct{}
+
+func (blockingReader) Read(p []byte) (int, error) {
+	select {}
+}
+
+func TestTimeoutBlockedRead(t *testing.T) {
+	program := compile(t, `
+.entry _start
+.section text
+_start:
+    mov x0, 0
+    mov x1, buffer
+    mov x2, 8
+    mov x15, 3
+    syscall
+    hlt
+
+.section bss
+buffer:
+    resb 8
+`)
+	done := make(chan error, 1)
+	go func() {
+		_, err := vm.Run(program, vm.Options{Stdin: blockingReader{}, Timeout: 50 * time.Millisecond})
+		done <- err
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the timeout did not stop the program blocked on stdin")
+	}
+	var fault *vm.Fault
+	if !errors.As(err, &fault) || !errors.Is(err, vm.ErrTimeout) {
+		t.Fatalf("expected timeout fault, got %v", err)
+	}
+	if fault.Opcode != opcode.SYSCALL || fault.IP != 48 {
+		t.Errorf("expected the fault at the syscall at 0x0030, got %v", fault)
+	}
+}
@@ -0,0 +1,93 @@
+package lexer_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fishy/pkg/vm"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	// work is called 10 times and loops 100 times each
+	source := `
+.entry _start
+.section text
+_start:
+    mov x2, 0
+.outer:
+    call work
+    add x2, 1
+    cmp x2, 10
+    jne .outer
+    mov x15, 9
+    syscall
+    hlt
+work:
+    mov x0, 0
+.loop:
+    add x0, 1
+    cmp x0, 100
+    jne .loop
+    ret
+`
+	m, err := vm.New(compile(t, source), vm.Options{MemorySize: 4096, Profile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	profile := m.Profile()
+
+	labels := profile.Labels()
+	if len(labels) != 4 {
+		t.Fatalf("expected 4 labels, got %+v", labels)
+	}
+	if hottest := labels[0]; hottest.Instructions != 3010 || hottest.Cumulative != 3010 {
+		t.Fatalf("expected the loop to be the hottest label, got %+v", hottest)
+	}
+
+	total := uint64(0)
+	for _, label := range labels {
+		total += label.Instructions
+		if label.Name == "_start" && (label.Instructions != 1 || label.Cumulative != 1) {
+			t.Fatalf("unexpected cost of _start: %+v", label)
+		}
+	}
+	if total != m.Steps() {
+		t.Fatalf("expected %d instructions in the profile, got %d", m.Steps(), total)
+	}
+
+	for _, sample := range profile.Samples {
+		if sample.Stack[0] > labels[0].Addr && len(sample.Stack) != 2 {
+			t.Fatalf("expected the loop to be called from .outer, got stack %v", sample.Stack)
+		}
+	}
+
+	var report strings.Builder
+	if err := profile.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[1], "3010") {
+		t.Fatalf("unexpected report:\n%s", report.String())
+	}
+
+	var out bytes.Buffer
+	if err := profile.WritePprof(&out); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(raw, []byte("instructions")) || !bytes.Contains(raw, []byte("_start")) {
+		t.Fatal("expected the pprof string table to name the sample type and labels")
+	}
+}
@@ -0,0 +1,146 @@
+package lexer_test
+
+import (
+	"errors"
+	"fishy/internal/vm"
+	"fishy/pkg/bytecode"
+	"strings"
+	"testing"
+)
+
+func TestMemoryProtection(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		access vm.Permission
+		region string
+	}{
+		{name: "write text", body: "mov x0, _start\n    mov [x0], x1", access: vm.PERM_WRITE, region: "text"},
+		{name: "write rodata", body: "mov byte [greeting], 0", access: vm.PERM_WRITE, region: "rodata"},
+		{name: "pop into text", body: "push 1\n    pop [_start]", access: vm.PERM_WRITE, region: "text"},
+		{name: "execute data", body: "jmp counter", access: vm.PERM_EXEC, region: "data"},
+		{name: "execute stack", body: "mov x0, sp\n    sub x0, 16\n    jmp x0", access: vm.PERM_EXEC, region: "stack"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := ".entry _start\n.section rodata\ngreeting: db \"hi\", 0\n.section data\ncounter: dq 0\n.section text\n_start:\n    " + tt.body + "\n    hlt\n"
+			m, err := vm.New(compile(t, source), 4096, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = m.Run()
+			var protection *vm.ProtectionError
+			if !errors.As(err, &protection) {
+				t.Fatalf("expected a protection fault, got %v", err)
+			}
+			if protection.Access != tt.access || protection.Region != tt.region {
+				t.Fatalf("expected %s fault in %s, got %v", tt.access, tt.region, protection)
+			}
+			if !strings.Contains(err.Error(), "protection fault") {
+				t.Fatalf("unexpected fault message %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestMemoryProtectionAllowsData(t *testing.T) {
+	source := `
+.entry _start
+.section rodata
+start: dq 40
+.section data
+counter: dq 0
+.section text
+_start:
+    mov x0, [start]
+    add x0, 2
+    mov [counter], x0
+    mov x0, [counter]
+    push x0
+    pop x0
+    mov x15, 1
+    syscall
+`
+	if status := runStatus(t, source); status != 42 {
+		t.Fatalf("expected exit status 42, got %d", status)
+	}
+}
+
+// TestMemoryProtectionGap reads from between the text and the data section of
+// a program the compiler would not lay out like that.
+func TestMemoryProtectionGap(t *testing.T) {
+	compiled, err := bytecode.Decode(compile(t, ".entry _start\n_start:\n    mov x0, [64]\n    hlt\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := compiled.Section(bytecode.SECTION_TEXT)
+	program := (&bytecode.File{
+		Version: bytecode.Version,
+		Sections: []*bytecode.Section{
+			{Kind: bytecode.SECTION_TEXT, Size: text.Size, Data: text.Data},
+			{Kind: bytecode.SECTION_DATA, Addr: 128, Size: 8},
+		},
+	}).Encode()
+
+	m, err := vm.New(program, 4096, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var protection *vm.ProtectionError
+	if err := m.Run(); !errors.As(err, &protection) || protection.Region != "unmapped memory" || protection.Addr != 64 {
+		t.Fatalf("expected reading between the sections to fault, got %v", err)
+	}
+}
+
+// Syscalls reading a guest buffer fail with EFAULT instead of reading memory
+// the guest could not load itself, here the guard below a thread's stack.
+func TestSyscallReadProtection(t *testing.T) {
+	tests := []struct {
+		name string
+		call string
+	}{
+		{name: "write", call: "mov x0, 1\n    mov x1, sp\n    sub x1, 72\n    mov x2, 8\n    mov x15, 4"},
+		{name: "str_to_int", call: "mov x0, sp\n    sub x0, 72\n    mov x1, 2\n    mov x2, number\n    mov x15, 8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := `
+.entry _start
+.section data
+number: dq 0
+.section text
+_start:
+    mov x0, worker
+    mov x1, 64
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    mov x0, x5
+    mov x15, 18
+    syscall
+    mov x15, 1
+    syscall
+worker:
+    ` + tt.call + `
+    syscall
+    mov x0, er
+    hlt
+`
+			m, err := vm.New(compile(t, source), 8192, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Run(); err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if status, _ := m.ExitCode(); status != int(vm.EFAULT) {
+				t.Fatalf("expected EFAULT, got %d", status)
+			}
+		})
+	}
+}
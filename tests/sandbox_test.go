@@ -0,0 +1,139 @@
+package lexer_test
+
+import (
+	"fishy/pkg/vm"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// openProgram tries to open path and exits with the value of er.
+func openProgram(t *testing.T, path string) []byte {
+	return compile(t, fmt.Sprintf(`
+.entry _start
+
+.section text
+_start:
+    mov x0, path
+    mov x1, %d
+    mov x2, 0
+    mov x3, 0
+    mov x15, 2
+    syscall
+
+    mov x0, er
+    mov x15, 1
+    syscall
+
+.section data
+path:
+    db "%s"
+`, len(path), path))
+}
+
+func TestSandboxFilesystem(t *testing.T) {
+	root := t.TempDir()
+	inside := filepath.Join(root, "input.txt")
+	if err := os.WriteFile(inside, []byte("fish"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("shark"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &vm.Policy{
+		Filesystem: []string{root},
+		Syscalls:   []vm.PolicySyscall{vm.PolicySyscall(vm.SYS_EXIT), vm.PolicySyscall(vm.SYS_OPEN)},
+	}
+
+	tests := []struct {
+		path string
+		er   int
+	}{
+		{path: inside, er: 0},
+		{path: outside, er: int(vm.EPERM)},
+		{path: filepath.Join(root, "..", filepath.Base(filepath.Dir(outside)), "secret.txt"), er: int(vm.EPERM)},
+	}
+
+	for _, tt := range tests {
+		status, err := vm.Run(openProgram(t, tt.path), vm.Options{Policy: policy})
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if status != tt.er {
+			t.Errorf("open %s: expected er %d, got %d", tt.path, tt.er, status)
+		}
+	}
+}
+
+func TestSandboxSyscalls(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x15, 9
+    syscall
+
+    mov x0, er
+    mov x15, 1
+    syscall
+`)
+
+	status, err := vm.Run(program, vm.Options{Policy: &vm.Policy{
+		Syscalls: []vm.PolicySyscall{vm.PolicySyscall(vm.SYS_EXIT)},
+	}})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status != int(vm.EPERM) {
+		t.Errorf("expected er %d, got %d", int(vm.EPERM), status)
+	}
+}
+
+// A sandboxed guest closing stdin only loses access to it, the host's stdin
+// stays open.
+func TestSandboxCloseStdio(t *testing.T) {
+	program := compile(t, `
+.entry _start
+
+.section text
+_start:
+    mov x0, 0
+    mov x15, 5
+    syscall
+    mov x5, x0
+
+    mov x0, 0
+    mov x1, buffer
+    mov x2, 8
+    mov x15, 3
+    syscall
+
+    mov x0, er
+    add x0, x5
+    mov x15, 1
+    syscall
+
+.section bss
+buffer:
+    resb 8
+`)
+
+	status, err := vm.Run(program, vm.Options{Policy: &vm.Policy{
+		Syscalls: []vm.PolicySyscall{vm.PolicySyscall(vm.SYS_EXIT), vm.PolicySyscall(vm.SYS_READ), vm.PolicySyscall(vm.SYS_CLOSE)},
+	}})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status != int(vm.EPERM) {
+		t.Errorf("expected closing to succeed and reading to fail with er %d, got %d", int(vm.EPERM), status)
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(0, &stat); err != nil {
+		t.Fatalf("expected the host's stdin to stay open, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package lexer_test
+
+import (
+	"bytes"
+	"errors"
+	"fishy/pkg/vm"
+	"testing"
+	"time"
+)
+
+// interleave has two threads write their name to stdout one byte at a time,
+// so the output shows the order they were scheduled in.
+const interleave = `
+.entry _start
+.section data
+names: db "ab"
+next:  dq 0
+.section text
+_start:
+    mov x0, writer
+    mov x1, 1024
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+
+    mov x0, writer
+    mov x1, 2048
+    mov x15, 15
+    syscall
+    mov x6, x0
+    mov x15, 16
+    syscall
+
+    mov x0, x5
+    mov x15, 18
+    syscall
+    mov x0, x6
+    mov x15, 18
+    syscall
+    mov x0, 0
+    mov x15, 1
+    syscall
+
+writer:
+    mov x3, 1
+    xadd x3, [next]
+    add x3, names
+    mov x4, 0
+.loop:
+    mov x0, 1
+    mov x1, x3
+    mov x2, 1
+    mov x15, 4
+    syscall
+    add x4, 1
+    cmp x4, 3
+    jge .skip
+    yield
+.skip:
+    cmp x4, 20
+    jne .loop
+    hlt
+`
+
+func runSeed(t *testing.T, program []byte, seed int64) string {
+	t.Helper()
+	var out bytes.Buffer
+	_, err := vm.Run(program, vm.Options{MemorySize: 8192, Stdout: &out, Timeout: 5 * time.Second, Deterministic: true, Seed: seed})
+	if err != nil {
+		t.Fatalf("seed %d: run failed: %v", seed, err)
+	}
+	return out.String()
+}
+
+func TestDeterministicScheduler(t *testing.T) {
+	program := compile(t, interleave)
+
+	outputs := make(map[string]bool)
+	for seed := int64(0); seed < 8; seed++ {
+		first := runSeed(t, program, seed)
+		if len(first) != 40 {
+			t.Fatalf("seed %d: expected 40 bytes of output, got %q", seed, first)
+		}
+		for i := 0; i < 3; i++ {
+			if again := runSeed(t, program, seed); again != first {
+				t.Fatalf("seed %d: output changed between runs: %q and %q", seed, first, again)
+			}
+		}
+		outputs[first] = true
+	}
+
+	if len(outputs) < 2 {
+		t.Errorf("expected different seeds to interleave differently, always got %v", outputs)
+	}
+}
+
+func TestDeterministicDeadlock(t *testing.T) {
+	// the main thread locks the mutex and then joins a thread that needs it
+	source := `
+.entry _start
+.section data
+lock: dq 0
+.section text
+_start:
+    mov x15, 24
+    syscall
+    mov [lock], x0
+    mov x15, 25
+    syscall
+    mov x0, worker
+    mov x1, 1024
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    mov x0, x5
+    mov x15, 18
+    syscall
+    hlt
+worker:
+    mov x0, [lock]
+    mov x15, 25
+    syscall
+    hlt
+`
+	_, err := vm.Run(compile(t, source), vm.Options{MemorySize: 8192, Timeout: 5 * time.Second, Deterministic: true})
+	if !errors.Is(err, vm.ErrDeadlock) {
+		t.Fatalf("expected a deadlock, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package lexer_test
+
+import (
+	"fishy/pkg/vm"
+	"testing"
+)
+
+func runStatus(t *testing.T, source string) int {
+	t.Helper()
+	status, err := vm.Run(compile(t, source), vm.Options{MemorySize: 4096})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	return status
+}
+
+func TestSignedArithmetic(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "imul", body: "mov x0, -6\n    imul x0, 7\n    add x0, 100", expected: 58},
+		{name: "idiv", body: "mov x0, -42\n    mov x1, 6\n    idiv x0, x1\n    add x0, 10", expected: 3},
+		{name: "idiv byte literal", body: "mov x0, 20\n    idiv byte x0, 0xFB\n    add x0, 10", expected: 6},
+		{name: "sar", body: "mov x0, -16\n    sar x0, 2\n    add x0, 10", expected: 6},
+		{name: "shr", body: "mov x0, -16\n    shr x0, 60", expected: 15},
+		{name: "signed jump", body: "mov x0, -1\n    cmp x0, 1\n    mov x0, 1\n    jl done\n    mov x0, 2", expected: 1},
+		{name: "unsigned jump", body: "mov x0, -1\n    cmp x0, 1\n    mov x0, 1\n    jlt done\n    mov x0, 2", expected: 2},
+		{name: "jnl on equal", body: "mov x0, -5\n    cmp x0, -5\n    mov x0, 3\n    jnl done\n    mov x0, 4", expected: 3},
+		{name: "jg", body: "mov x0, 5\n    mov x1, -5\n    cmp x0, x1\n    mov x0, 7\n    jg done\n    mov x0, 8", expected: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := ".entry _start\n_start:\n    " + tt.body + "\ndone:\n    mov x15, 1\n    syscall\n"
+			if status := runStatus(t, source); status != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package lexer_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fishy/pkg/vm"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resumeFrom saves the paused machine and restores it with opts.
+func resumeFrom(t *testing.T, m *vm.Machine, opts vm.Options) *vm.Machine {
+	t.Helper()
+	var snapshot bytes.Buffer
+	if err := m.Snapshot(&snapshot); err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	restored, err := vm.Restore(snapshot.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	return restored
+}
+
+func TestSnapshotDeterministic(t *testing.T) {
+	program := compile(t, interleave)
+
+	for seed := int64(0); seed < 4; seed++ {
+		expected := runSeed(t, program, seed)
+
+		var out bytes.Buffer
+		opts := vm.Options{MemorySize: 8192, Stdout: &out, Timeout: 5 * time.Second, Deterministic: true, Seed: seed, PauseAfter: 30}
+		m, err := vm.New(program, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pauses := 0
+		for err = m.Run(); errors.Is(err, vm.ErrPaused); err = m.Run() {
+			pauses++
+			m = resumeFrom(t, m, opts)
+		}
+		if err != nil {
+			t.Fatalf("seed %d: run failed: %v", seed, err)
+		}
+		if pauses < 3 {
+			t.Fatalf("seed %d: expected the program to be paused a few times, got %d", seed, pauses)
+		}
+		if out.String() != expected {
+			t.Fatalf("seed %d: expected the resumed program to print %q, got %q", seed, expected, out.String())
+		}
+	}
+}
+
+// TestSnapshotThreads pauses threads while they wait for a mutex or to join,
+// they try again after the restore.
+func TestSnapshotThreads(t *testing.T) {
+	program := compile(t, `
+.entry _start
+.section data
+counter: dq 0
+lock:    dq 0
+.section text
+_start:
+    mov x15, 24
+    syscall
+    mov [lock], x0
+
+    mov x0, worker
+    mov x1, 1024
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    mov x0, worker
+    mov x1, 1024
+    mov x15, 15
+    syscall
+    mov x6, x0
+    mov x15, 16
+    syscall
+
+    mov x0, x5
+    mov x15, 18
+    syscall
+    mov x0, x6
+    mov x15, 18
+    syscall
+    mov x0, [counter]
+    mov x15, 1
+    syscall
+
+worker:
+    mov x4, 0
+.loop:
+    mov x0, [lock]
+    mov x15, 25
+    syscall
+    mov x1, [counter]
+    add x1, 1
+    mov [counter], x1
+    mov x0, [lock]
+    mov x15, 26
+    syscall
+    add x4, 1
+    cmp x4, 100
+    jne .loop
+    hlt
+`)
+
+	opts := vm.Options{MemorySize: 16384, Timeout: 5 * time.Second, PauseAfter: 50}
+	m, err := vm.New(program, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err = m.Run(); errors.Is(err, vm.ErrPaused); err = m.Run() {
+		m = resumeFrom(t, m, opts)
+	}
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status, _ := m.ExitCode(); status != 200 {
+		t.Fatalf("expected the counter to reach 200, got %d", status)
+	}
+}
+
+func TestSnapshotInvalidatesFds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte("fish"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// paused after the fd was moved to x5, then reads from it and exits
+	// with er
+	program := compile(t, fmt.Sprintf(`
+.entry _start
+
+.section text
+_start:
+    mov x0, path
+    mov x1, %d
+    mov x2, 0
+    mov x3, 0
+    mov x15, 2
+    syscall
+    mov x5, x0
+
+    mov x0, x5
+    mov x1, buffer
+    mov x2, 4
+    mov x15, 3
+    syscall
+
+    mov x0, er
+    mov x15, 1
+    syscall
+
+.section data
+path:
+    db "%s"
+buffer:
+    db "0000"
+`, len(path), path))
+
+	opts := vm.Options{MemorySize: 4096, PauseAfter: 7}
+	m, err := vm.New(program, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); !errors.Is(err, vm.ErrPaused) {
+		t.Fatalf("expected the machine to pause, got %v", err)
+	}
+
+	restored := resumeFrom(t, m, vm.Options{})
+	if err := restored.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status, _ := restored.ExitCode(); status != int(vm.EBADF) {
+		t.Fatalf("expected reading the fd of the snapshot to fail with EBADF, got %d", status)
+	}
+
+	// the machine that was paused still owns the file
+	if err := m.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if status, _ := m.ExitCode(); status != 0 {
+		t.Fatalf("expected reading the open fd to work, got %d", status)
+	}
+}
+
+// corruptState is the part of a snapshot TestSnapshotCorrupted changes, gob
+// matches it to the real state by field names.
+type corruptState struct {
+	Program []byte
+	Memory  []byte
+	Paused  bool
+	Steps   uint64
+	Heap    struct {
+		Start  uint64
+		End    uint64
+		Blocks map[uint64]uint64
+		Free   []struct{ Addr, Size uint64 }
+	}
+	Threads []struct {
+		Registers []uint64
+		Running   bool
+		State     int
+		Stack     uint64
+		StackBase uint64
+		StackTop  uint64
+		Waiting   uint64
+	}
+	Scheduler *struct {
+		Seed     int64
+		Draws    uint64
+		Quantum  int
+		Runnable []int
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	program := compile(t, `
+.entry _start
+_start:
+    mov x0, 0
+.loop:
+    add x0, 1
+    cmp x0, 100
+    jne .loop
+    mov x15, 1
+    syscall
+`)
+	m, err := vm.New(program, vm.Options{MemorySize: 4096, Deterministic: true, PauseAfter: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); !errors.Is(err, vm.ErrPaused) {
+		t.Fatalf("expected the machine to pause, got %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := m.Snapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	header := snapshot.Bytes()[:6]
+	zr, err := gzip.NewReader(bytes.NewReader(snapshot.Bytes()[6:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var original bytes.Buffer
+	if _, err := original.ReadFrom(zr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(state *corruptState)
+	}{
+		{name: "untouched", corrupt: func(state *corruptState) {}},
+		{name: "draws", corrupt: func(state *corruptState) { state.Scheduler.Draws = 1 << 62 }},
+		{name: "quantum", corrupt: func(state *corruptState) { state.Scheduler.Quantum = 0 }},
+		{name: "heap end", corrupt: func(state *corruptState) { state.Heap.End = 1 << 40 }},
+		{name: "heap start", corrupt: func(state *corruptState) { state.Heap.Start = 0 }},
+		{name: "heap block", corrupt: func(state *corruptState) { state.Heap.Blocks = map[uint64]uint64{state.Heap.Start: 1 << 40} }},
+		{name: "free span", corrupt: func(state *corruptState) { state.Heap.Free[0].Size = 1<<64 - 1 }},
+		{name: "stack", corrupt: func(state *corruptState) { state.Threads[0].StackTop = 1 << 40 }},
+		{name: "waiting", corrupt: func(state *corruptState) { state.Threads[0].Waiting = 7 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &corruptState{}
+			if err := gob.NewDecoder(bytes.NewReader(original.Bytes())).Decode(state); err != nil {
+				t.Fatal(err)
+			}
+			tt.corrupt(state)
+
+			corrupted := bytes.NewBuffer(append([]byte{}, header...))
+			zw := gzip.NewWriter(corrupted)
+			if err := gob.NewEncoder(zw).Encode(state); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := vm.Restore(corrupted.Bytes(), vm.Options{})
+			if tt.name == "untouched" {
+				if err != nil {
+					t.Fatalf("restore failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "snapshot is corrupted") {
+				t.Fatalf("expected the snapshot to be corrupted, got %v", err)
+			}
+		})
+	}
+}
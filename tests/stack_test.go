@@ -0,0 +1,145 @@
+package lexer_test
+
+import (
+	"errors"
+	"fishy/pkg/vm"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runThread spawns worker with a 64 byte stack, joins it and exits with x0.
+func runThread(t *testing.T, worker string) (int, error) {
+	t.Helper()
+	source := `
+.entry _start
+.section text
+_start:
+    push 42
+    mov x0, worker
+    mov x1, 64
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    mov x0, x5
+    mov x15, 18
+    syscall
+    pop x0
+    mov x15, 1
+    syscall
+` + worker
+	return vm.Run(compile(t, source), vm.Options{MemorySize: 8192, Timeout: 5 * time.Second})
+}
+
+func TestThreadStacks(t *testing.T) {
+	t.Run("isolated", func(t *testing.T) {
+		status, err := runThread(t, `
+worker:
+    mov x2, 0
+.loop:
+    push 7
+    add x2, 1
+    cmp x2, 8
+    jne .loop
+    hlt
+`)
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if status != 42 {
+			t.Fatalf("expected the main stack to be untouched, got %d", status)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		_, err := runThread(t, `
+worker:
+    call worker
+`)
+		var fault *vm.Fault
+		if !errors.As(err, &fault) || fault.Thread != 1 || !strings.Contains(fault.Reason, "stack overflow") {
+			t.Fatalf("expected a stack overflow in thread 1, got %v", err)
+		}
+	})
+
+	for name, worker := range map[string]string{
+		"pop below the stack":  "worker:\n    sub sp, 72\n    pop x1\n    hlt\n",
+		"push above the stack": "worker:\n    add sp, 64\n    push 1\n    hlt\n",
+		"pop above the stack":  "worker:\n    pop x1\n    hlt\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := runThread(t, worker)
+			var fault *vm.Fault
+			if !errors.As(err, &fault) || fault.Thread != 1 || !strings.Contains(fault.Reason, "stack") {
+				t.Fatalf("expected a stack fault in thread 1, got %v", err)
+			}
+		})
+	}
+
+	t.Run("guard", func(t *testing.T) {
+		_, err := runThread(t, `
+worker:
+    mov x1, 1
+    mov [sp - 72], x1
+    hlt
+`)
+		var perr *vm.ProtectionError
+		if !errors.As(err, &perr) || perr.Region != "stack guard" {
+			t.Fatalf("expected a protection fault in the stack guard, got %v", err)
+		}
+	})
+
+	for name, read := range map[string]string{"read guard": "mov x1, [sp - 72]", "push guard": "push [sp - 72]"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := runThread(t, "worker:\n    "+read+"\n    hlt\n")
+			var perr *vm.ProtectionError
+			if !errors.As(err, &perr) || perr.Region != "stack guard" || perr.Access != vm.PERM_READ {
+				t.Fatalf("expected reading the stack guard to fault, got %v", err)
+			}
+		})
+	}
+
+	t.Run("free", func(t *testing.T) {
+		status, err := runThread(t, `
+worker:
+    mov x0, sp
+    sub x0, 320
+    mov x15, 22
+    syscall
+    mov x0, er
+    mov x15, 1
+    syscall
+`)
+		if err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		if status != int(vm.EINVAL) {
+			t.Fatalf("expected freeing a thread stack to fail with EINVAL, got %d", status)
+		}
+	})
+}
+
+func TestMainStackSize(t *testing.T) {
+	source := `
+.entry _start
+.section text
+_start:
+    call _start
+`
+	m, err := vm.New(compile(t, source), vm.Options{MemorySize: 8192, StackSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.StackSize() != 512 {
+		t.Fatalf("expected a 512 byte stack, got %d", m.StackSize())
+	}
+	if err := m.Run(); err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Fatalf("expected a stack overflow, got %v", err)
+	}
+
+	if _, err := vm.New(compile(t, source), vm.Options{MemorySize: 8192, HeapSize: 4096, StackSize: 4096}); err == nil {
+		t.Fatal("expected heap and stack not to fit")
+	}
+}
@@ -0,0 +1,148 @@
+package lexer_test
+
+import (
+	"fishy/pkg/vm"
+	"testing"
+	"time"
+)
+
+func TestThreadLifecycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		worker   string
+		faults   bool
+		expected int
+	}{
+		{
+			name:     "join returns the exit value",
+			body:     "mov x0, x5\n    mov x15, 18\n    syscall",
+			worker:   "add x0, 1\n    hlt",
+			expected: 8,
+		},
+		{
+			name:     "thread exit",
+			body:     "mov x0, x5\n    mov x15, 18\n    syscall",
+			worker:   "mov x0, 3\n    mov x15, 34\n    syscall\n    mov x0, 9\n    hlt",
+			expected: 3,
+		},
+		{
+			name:     "self",
+			body:     "mov x0, x5\n    mov x15, 18\n    syscall\n    mov x1, x0\n    mov x15, 31\n    syscall\n    mul x1, 10\n    add x0, x1",
+			worker:   "mov x15, 31\n    syscall\n    hlt",
+			expected: 10,
+		},
+		{
+			name:     "status of a finished thread",
+			body:     "mov x0, x5\n    mov x15, 18\n    syscall\n    mov x0, x5\n    mov x15, 32\n    syscall",
+			worker:   "hlt",
+			expected: int(vm.THREAD_FINISHED),
+		},
+		{
+			name:     "join a faulted thread",
+			body:     "mov x0, x5\n    mov x15, 18\n    syscall\n    mov x0, er\n    mov x15, 1\n    syscall",
+			worker:   "mov x1, 0\n    div x0, x1\n    hlt",
+			faults:   true,
+			expected: int(vm.ETHREADFAULT),
+		},
+		{
+			name:     "join a detached thread",
+			body:     "mov x0, x5\n    mov x15, 33\n    syscall\n    mov x0, x5\n    mov x15, 18\n    syscall\n    mov x0, er",
+			worker:   "hlt",
+			expected: int(vm.EINVAL),
+		},
+		{
+			name:     "stop",
+			body:     "mov x0, x5\n    mov x15, 17\n    syscall\n    mov x0, x5\n    mov x15, 18\n    syscall\n    mov x0, x5\n    mov x15, 32\n    syscall",
+			worker:   "jmp worker",
+			expected: int(vm.THREAD_FINISHED),
+		},
+	}
+
+	for _, tt := range tests {
+		// the worker gets 7 as its argument
+		source := `
+.entry _start
+.section text
+_start:
+    mov x0, worker
+    mov x1, 0
+    mov x2, 7
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    ` + tt.body + `
+    mov x15, 1
+    syscall
+worker:
+    ` + tt.worker + "\n"
+
+		for _, deterministic := range []bool{false, true} {
+			m, err := vm.New(compile(t, source), vm.Options{Timeout: 5 * time.Second, Deterministic: deterministic})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Run reports the fault of the worker even though main handled it
+			if err := m.Run(); (err != nil) != tt.faults {
+				t.Fatalf("%s: unexpected result: %v", tt.name, err)
+			}
+			if status, _ := m.ExitCode(); status != tt.expected {
+				t.Fatalf("%s (deterministic %v): expected %d, got %d", tt.name, deterministic, tt.expected, status)
+			}
+		}
+	}
+}
+
+func TestThreadStatusBeforeStart(t *testing.T) {
+	source := `
+.entry _start
+.section text
+_start:
+    mov x0, worker
+    mov x1, 64
+    mov x15, 15
+    syscall
+    mov x15, 32
+    syscall
+    mov x15, 1
+    syscall
+worker:
+    hlt
+`
+	if status := runStatus(t, source); status != int(vm.THREAD_NEW) {
+		t.Fatalf("expected THREAD_NEW, got %d", status)
+	}
+}
+
+func TestThreadJoinBeforeStart(t *testing.T) {
+	source := `
+.entry _start
+.section text
+_start:
+    mov x0, worker
+    mov x1, 64
+    mov x15, 15
+    syscall
+    mov x15, 18
+    syscall
+    mov x0, er
+    mov x15, 1
+    syscall
+worker:
+    hlt
+`
+	for _, deterministic := range []bool{false, true} {
+		m, err := vm.New(compile(t, source), vm.Options{Timeout: 5 * time.Second, Deterministic: deterministic})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Run(); err != nil {
+			t.Fatalf("deterministic %v: unexpected result: %v", deterministic, err)
+		}
+		if status, _ := m.ExitCode(); status != int(vm.EINVAL) {
+			t.Fatalf("deterministic %v: expected EINVAL, got %d", deterministic, status)
+		}
+	}
+}
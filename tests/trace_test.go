@@ -0,0 +1,151 @@
+package lexer_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fishy/pkg/vm"
+	"testing"
+)
+
+func traceRecords(t *testing.T, source string, opts vm.Options) []vm.TraceRecord {
+	t.Helper()
+	var out bytes.Buffer
+	opts.Trace = &out
+	m, err := vm.New(compile(t, source), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+
+	var records []vm.TraceRecord
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var record vm.TraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid trace line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestTrace(t *testing.T) {
+	source := `
+.entry _start
+.section data
+value: dq 0
+.section text
+_start:
+    mov x0, 258
+    mov [value], x0
+    push 7
+    pop x1
+    mov x1, 0
+    div x0, x1
+`
+	records := traceRecords(t, source, vm.Options{MemorySize: 4096})
+	if len(records) != 6 {
+		t.Fatalf("expected 6 records, got %d", len(records))
+	}
+
+	first := records[0]
+	if first.IP != 0 || first.Step != 1 || first.Mnemonic != "mov" || len(first.Operands) != 2 || first.Operands[0] != "x0" || first.Operands[1] != "258" {
+		t.Fatalf("unexpected first record: %+v", first)
+	}
+	if len(first.Registers) != 1 || first.Registers["x0"] != 258 {
+		t.Fatalf("expected only x0 to be written, got %v", first.Registers)
+	}
+
+	// only the two low bytes of the qword change
+	store := records[1]
+	if len(store.Registers) != 0 || len(store.Memory) != 1 || store.Memory[0].Bytes != "0102" {
+		t.Fatalf("unexpected store record: %+v", store)
+	}
+
+	pop := records[3]
+	if pop.Registers["x1"] != 7 || pop.Registers["sp"] != 4096 || len(pop.Memory) != 0 {
+		t.Fatalf("unexpected pop record: %+v", pop)
+	}
+
+	if last := records[5]; last.Mnemonic != "div" || last.Fault != "integer divide by zero" {
+		t.Fatalf("expected the faulting div to be recorded, got %+v", last)
+	}
+}
+
+func TestTraceThreads(t *testing.T) {
+	source := `
+.entry _start
+.section text
+_start:
+    mov x0, worker
+    mov x1, 64
+    mov x15, 15
+    syscall
+    mov x5, x0
+    mov x15, 16
+    syscall
+    mov x0, x5
+    mov x15, 18
+    syscall
+    hlt
+worker:
+    mov x0, 1
+    hlt
+`
+	records := traceRecords(t, source, vm.Options{MemorySize: 4096, Deterministic: true})
+
+	syscalls := 0
+	workerSteps := 0
+	for _, record := range records {
+		if record.Thread == 1 {
+			workerSteps++
+		}
+		if record.Thread == 0 && record.Mnemonic == "syscall" {
+			syscalls++
+		}
+	}
+	// the join blocks until the worker finished but is recorded once
+	if workerSteps != 2 || syscalls != 3 {
+		t.Fatalf("expected 2 worker records and 3 syscalls, got %d and %d", workerSteps, syscalls)
+	}
+	if last := records[len(records)-1]; last.Thread != 0 || last.Mnemonic != "hlt" {
+		t.Fatalf("expected main to halt last, got %+v", last)
+	}
+}
+
+var errDiskFull = errors.New("disk full")
+
+// fullWriter takes n bytes and then fails.
+type fullWriter struct {
+	n int
+}
+
+func (w *fullWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		written := w.n
+		w.n = 0
+		return written, errDiskFull
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestTraceWriteError(t *testing.T) {
+	source := `
+.entry _start
+.section text
+_start:
+    mov x0, 1
+    add x0, 2
+    hlt
+`
+	m, err := vm.New(compile(t, source), vm.Options{MemorySize: 4096, Trace: &fullWriter{n: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); !errors.Is(err, errDiskFull) {
+		t.Fatalf("expected the trace write error, got %v", err)
+	}
+}
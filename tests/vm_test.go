@@ -0,0 +1,51 @@
+package lexer_test
+
+import (
+	"errors"
+	"fishy/internal/vm"
+	"testing"
+)
+
+func TestRuntimeFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason string
+	}{
+		{
+			name:   "divide by zero",
+			input:  ".entry _start\n_start:\n    mov x0, 10\n    mov x1, 0\n    div x0, x1\n    hlt\n",
+			reason: "integer divide by zero",
+		},
+		{
+			name:   "out of bounds",
+			input:  ".entry _start\n_start:\n    mov x1, 100000\n    mov x0, [x1]\n    hlt\n",
+			reason: "memory access out of bounds",
+		},
+		{
+			name:   "stack underflow",
+			input:  ".entry _start\n_start:\n    pop x0\n    hlt\n",
+			reason: "stack underflow",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := vm.New(compile(t, tt.input), 1024, false)
+			if err != nil {
+				t.Fatalf("failed to load program: %v", err)
+			}
+
+			var fault *vm.Fault
+			if err := m.Run(); !errors.As(err, &fault) {
+				t.Fatalf("expected a fault, got %v", err)
+			}
+			if fault.Reason != tt.reason {
+				t.Errorf("expected reason %q, got %q", tt.reason, fault.Reason)
+			}
+			if fault.Thread != 0 {
+				t.Errorf("expected fault in thread 0, got %d", fault.Thread)
+			}
+		})
+	}
+}